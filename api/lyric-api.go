@@ -1,19 +1,44 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"html"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	mathrand "math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
 // --- 类型定义 ---
@@ -33,12 +58,25 @@ type WordInfo struct {
 	Text      string
 	StartTime int
 	Duration  int
+	Ruby      []RubyInfo
+	ZeroDur   bool // 上游持续时间为 0，解析时已修正为 1ms
+}
+
+// RubyInfo 描述一段汉字的注音，记录在注音开始的词上，Start 为该词文本中的字符(rune)下标。
+// 注音可以延续到同一行后续的词 (YRC 把汉字逐字拆成词)，此时 Words 为延续的词数，
+// End 为最后一个词中的结束下标；Words 为 0 时 End 是本词中的结束下标
+type RubyInfo struct {
+	Start   int
+	End     int
+	Words   int
+	Reading string
 }
 
 type LineInfo struct {
 	Words     []WordInfo
 	StartTime int
 	EndTime   int
+	SongPart  string // 所在段落: Verse/Chorus/Bridge/Outro，未识别时为空
 }
 
 type DivInfo struct {
@@ -52,19 +90,163 @@ type MetaLine struct {
 	Content string
 }
 
+// ParsedLyric 上游歌词解析后的统一模型，供各输出格式共用
+type ParsedLyric struct {
+	Meta         map[string]string
+	Lines        []*LineInfo
+	Translations []MetaLine
+	Romaji       []*LineInfo
+	Estimated    bool // 逐字时间由逐行 LRC 推算而来
+	Interludes   []InterludeInfo
+}
+
+// InterludeInfo 间奏 (纯音乐) 区间
+type InterludeInfo struct {
+	StartTime int
+	EndTime   int
+}
+
+// ErrorResponse 统一的错误响应，code 为 HTTP 状态码，error_code 为稳定的错误码。
+// message 按请求语言翻译；details 是给开发者排查问题的诊断文本，始终为中文，
+// 可能包含参数值和上游错误原文，不做翻译，也不保证格式稳定
 type ErrorResponse struct {
+	Code      int             `json:"code"`
+	ErrorCode ErrorCode       `json:"error_code"`
+	Message   string          `json:"message"`
+	Details   string          `json:"details,omitempty"`  // 诊断信息，不翻译`
+	Upstream  json.RawMessage `json:"upstream,omitempty"` // 上游返回错误时附带的原始响应
+}
+
+// ErrorCode 稳定的机器可读错误码，与 HTTP 状态码相互独立
+type ErrorCode string
+
+const (
+	ErrCodeMissingParameter     ErrorCode = "MISSING_PARAMETER"     // 缺少必需参数
+	ErrCodeInvalidParameter     ErrorCode = "INVALID_PARAMETER"     // 参数取值不合法
+	ErrCodeInvalidBody          ErrorCode = "INVALID_BODY"          // 请求体不是合法的 JSON
+	ErrCodeBatchTooLarge        ErrorCode = "BATCH_TOO_LARGE"       // 批量请求项数超过上限
+	ErrCodeMethodNotAllowed     ErrorCode = "METHOD_NOT_ALLOWED"    // 请求方法不支持
+	ErrCodeUpstreamTimeout      ErrorCode = "UPSTREAM_TIMEOUT"      // 上游请求超时
+	ErrCodeUpstreamUnavailable  ErrorCode = "UPSTREAM_UNAVAILABLE"  // 上游无法访问或返回了无法解析的内容
+	ErrCodeUpstreamError        ErrorCode = "UPSTREAM_ERROR"        // 上游返回了错误码，原始响应见 upstream 字段
+	ErrCodeSongNotFound         ErrorCode = "SONG_NOT_FOUND"        // 搜索没有结果
+	ErrCodeLowMatchConfidence   ErrorCode = "LOW_MATCH_CONFIDENCE"  // 最佳匹配的置信度低于阈值
+	ErrCodeIndexOutOfRange      ErrorCode = "INDEX_OUT_OF_RANGE"    // n 超出搜索结果数量
+	ErrCodeLyricNotFound        ErrorCode = "LYRIC_NOT_FOUND"       // 歌曲没有歌词
+	ErrCodeNoWordTiming         ErrorCode = "NO_WORD_TIMING"        // 歌词没有可用的时间轴
+	ErrCodeTooManyRequests      ErrorCode = "TOO_MANY_REQUESTS"     // 请求过于频繁
+	ErrCodeStreamingUnsupported ErrorCode = "STREAMING_UNSUPPORTED" // 运行环境不支持流式响应 (SSE) 或 WebSocket
+	ErrCodeUnauthorized         ErrorCode = "UNAUTHORIZED"          // 缺少或无效的凭据
+	ErrCodeForbidden            ErrorCode = "FORBIDDEN"             // 接口未启用或无权访问
+	ErrCodeQuotaExceeded        ErrorCode = "QUOTA_EXCEEDED"        // API Key 当日配额已用完
+	ErrCodeUpstreamCircuitOpen  ErrorCode = "UPSTREAM_CIRCUIT_OPEN" // 上游连续失败已熔断，暂停请求
+	ErrCodeConfigInvalid        ErrorCode = "CONFIG_INVALID"        // 服务配置无效，修正后重新部署前拒绝请求
+)
+
+// errorCodes 全部错误码，用于 OpenAPI 枚举
+var errorCodes = []ErrorCode{
+	ErrCodeMissingParameter, ErrCodeInvalidParameter, ErrCodeInvalidBody, ErrCodeBatchTooLarge,
+	ErrCodeMethodNotAllowed, ErrCodeUpstreamTimeout, ErrCodeUpstreamUnavailable, ErrCodeUpstreamError,
+	ErrCodeSongNotFound, ErrCodeLowMatchConfidence, ErrCodeIndexOutOfRange, ErrCodeLyricNotFound,
+	ErrCodeNoWordTiming, ErrCodeTooManyRequests, ErrCodeStreamingUnsupported,
+	ErrCodeUnauthorized, ErrCodeForbidden, ErrCodeQuotaExceeded, ErrCodeUpstreamCircuitOpen,
+	ErrCodeConfigInvalid,
+}
+
+// StatusResponse 不携带数据的成功响应
+type StatusResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
-	Details string `json:"details,omitempty"`
 }
 
 // SearchSongItemRaw 用于解析上游API返回的原始歌曲条目
 type SearchSongItemRaw struct {
-	ID     int    `json:"id"`
-	MID    string `json:"mid"`
-	Song   string `json:"song"`
-	Singer string `json:"singer"`
-	Album  string `json:"album"`
+	ID       int             `json:"id"`
+	MID      string          `json:"mid"`
+	Song     string          `json:"song"`
+	Singer   string          `json:"singer"`
+	Album    string          `json:"album"`
+	Interval upstreamSeconds `json:"interval"`
+	Cover    string          `json:"cover"`
+	Time     string          `json:"time"` // 发行日期
+	Pay      upstreamFlag    `json:"pay"`  // 是否需要付费/会员
+}
+
+// upstreamFlag 兼容上游以布尔、数字或文字 ("付费"/"免费") 表示的标志，Set 表示上游提供了该字段
+type upstreamFlag struct {
+	Set   bool
+	Value bool
+}
+
+func (f *upstreamFlag) UnmarshalJSON(b []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case bool:
+		f.Set, f.Value = true, v
+	case float64:
+		f.Set, f.Value = true, v > 0
+	case string:
+		lower := strings.ToLower(v)
+		switch {
+		case strings.Contains(lower, "免费") || lower == "free" || lower == "0":
+			f.Set, f.Value = true, false
+		case strings.Contains(lower, "付费") || strings.Contains(lower, "vip") || strings.Contains(lower, "会员") || lower == "1":
+			f.Set, f.Value = true, true
+		}
+	}
+	return nil
+}
+
+// ptr 返回 upstreamFlag 的指针形式，上游未提供时为 nil
+func (f upstreamFlag) ptr() *bool {
+	if !f.Set {
+		return nil
+	}
+	value := f.Value
+	return &value
+}
+
+// upstreamSeconds 兼容上游以数字秒、"mm:ss" 或 "X分Y秒" 表示的时长
+type upstreamSeconds int
+
+func (s *upstreamSeconds) UnmarshalJSON(b []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*s = upstreamSeconds(v)
+	case string:
+		*s = upstreamSeconds(parseDurationText(v))
+	}
+	return nil
+}
+
+// parseDurationText 解析 "215"、"03:35" 或 "3分35秒" 形式的时长，返回秒数
+func parseDurationText(text string) int {
+	text = strings.TrimSpace(text)
+	if seconds, err := strconv.Atoi(text); err == nil {
+		return seconds
+	}
+	if parts := strings.Split(text, ":"); len(parts) == 2 {
+		minutes, err1 := strconv.Atoi(parts[0])
+		seconds, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil {
+			return minutes*60 + seconds
+		}
+	}
+	if matches := durationCnRe.FindStringSubmatch(text); len(matches) == 3 {
+		minutes, _ := strconv.Atoi(matches[1])
+		seconds, _ := strconv.Atoi(matches[2])
+		return minutes*60 + seconds
+	}
+	return 0
 }
 
 // SearchSongItemSimplified 精简后的歌曲信息结构体
@@ -75,26 +257,108 @@ type SearchSongItemSimplified struct {
 	ID     int    `json:"id"`
 	MID    string `json:"mid"`
 	Album  string `json:"album"`
+
+	// 以下字段仅在上游提供时返回
+	Duration      int    `json:"duration,omitempty"`        // 歌曲时长 (秒)
+	Cover         string `json:"cover,omitempty"`           // 封面图片 URL
+	ReleaseDate   string `json:"release_date,omitempty"`    // 发行日期
+	VIP           *bool  `json:"vip,omitempty"`             // 是否需要付费/会员
+	HasWordLyrics *bool  `json:"has_word_lyrics,omitempty"` // 是否有逐字歌词 (probe=1)
 }
 
 // UnifiedLyricResponse 统一的歌词响应结构
 type UnifiedLyricResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Data    struct {
-		Song   string `json:"song"`
-		Singer string `json:"singer"`
-		Album  string `json:"album"`
-		LRC    string `json:"lrc"`   // 原始 LRC (已合并翻译)
-		ESLRC  string `json:"eslrc"` // 增强型 LRC (逐字)
-		TTML   string `json:"ttml"`  // TTML 歌词
-	} `json:"data"`
+	Code    int              `json:"code"`
+	Message string           `json:"message"`
+	Data    UnifiedLyricData `json:"data"`
+}
+
+// UnifiedLyricData 统一歌词响应中的歌曲和歌词数据
+type UnifiedLyricData struct {
+	Song      string         `json:"song"`
+	Singer    string         `json:"singer"`
+	Album     string         `json:"album"`
+	LRC       string         `json:"lrc"`                // 原始 LRC (已合并翻译)
+	ESLRC     string         `json:"eslrc"`              // 增强型 LRC (逐字)
+	TTML      string         `json:"ttml"`               // TTML 歌词
+	Estimated bool           `json:"estimated"`          // ESLRC/TTML 的逐字时间为估算值 (上游无 YRC)
+	HTML      string         `json:"html,omitempty"`     // 带 <ruby> 注音的网页片段 (html=1)
+	Lines     []LyricLine    `json:"lines,omitempty"`    // 结构化逐字歌词 (lines=1)
+	Lint      *LintReport    `json:"lint,omitempty"`     // 歌词质量检查报告 (lint=1 或 lint=fix)
+	Sections  []LyricSection `json:"sections,omitempty"` // 歌曲段落，识别出副歌时才返回
+	Match     *MatchResult   `json:"match,omitempty"`    // 元数据匹配结果 (title=...)
+}
+
+// LyricLine 结构化输出中的一行歌词
+type LyricLine struct {
+	Start     int         `json:"start"`
+	End       int         `json:"end"`
+	Text      string      `json:"text"`
+	Words     []LyricWord `json:"words"`
+	Interlude bool        `json:"interlude,omitempty"` // 间奏占位行 (interlude=1)
+	Part      string      `json:"part,omitempty"`      // 所在段落: Verse/Chorus/Bridge/Outro
+}
+
+// LyricSection 歌曲段落 (主歌/副歌等)
+type LyricSection struct {
+	Part  string `json:"part"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// LyricWord 结构化输出中的一个字/词
+type LyricWord struct {
+	Text  string      `json:"text"`
+	Start int         `json:"start"`
+	End   int         `json:"end"`
+	Ruby  []LyricRuby `json:"ruby,omitempty"`
+}
+
+// LyricRuby 假名注音，Offset 为注音部分在词文本中的字符下标。
+// 一个读音对应逐字拆开的多个词时 (如「一」「緒」共用「いっしょ」)，注音记录在第一个词上，
+// Words 为延续到的后续词数，Base 为跨越这些词的完整文本
+type LyricRuby struct {
+	Base    string `json:"base"`
+	Reading string `json:"reading"`
+	Offset  int    `json:"offset"`
+	Words   int    `json:"words,omitempty"`
+}
+
+// MatchResult 元数据匹配选中的歌曲及置信度
+type MatchResult struct {
+	Score    float64 `json:"score"` // 0~1
+	N        int     `json:"n"`     // 在搜索结果中的序号
+	ID       int     `json:"id"`
+	MID      string  `json:"mid"`
+	Duration int     `json:"duration,omitempty"`
+}
+
+// LintFinding 歌词质量检查发现的一个问题
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`       // error | warning | info
+	Line     int    `json:"line"`           // 行序号 (从 1 开始，按上游顺序)，针对整首歌词时为 0
+	Word     int    `json:"word,omitempty"` // 词序号 (从 1 开始)，行级问题为 0
+	Time     int    `json:"time"`           // 问题所在位置的时间 (ms)
+	Message  string `json:"message"`
+	Fixed    bool   `json:"fixed,omitempty"`
+}
+
+// LintReport 歌词质量检查报告
+type LintReport struct {
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	Infos    int           `json:"infos"`
+	Fixed    int           `json:"fixed"`
+	Findings []LintFinding `json:"findings"`
 }
 
 // SearchResponse 用于搜索结果的响应
 type SearchResponse struct {
-	Code    int                       `json:"code"`
-	Message string                    `json:"message"`
+	Code    int                        `json:"code"`
+	Message string                     `json:"message"`
+	Page    int                        `json:"page"`
+	Num     int                        `json:"num"`
 	Data    []SearchSongItemSimplified `json:"data"`
 }
 
@@ -105,6 +369,9 @@ var (
 	wordInfoRe = regexp.MustCompile(`(.*?)\((\d+),(\d+)\)`)
 	lrcTimeRe  = regexp.MustCompile(`^\[(\d{2}):(\d{2})\.(\d{2,3})\](.*)$`)
 	metaRe     = regexp.MustCompile(`^\[(ti|ar|al|by|offset|kana|re|ve):(.*?)\]$`)
+	kanaRe     = regexp.MustCompile(`(\d+)(\D+)`)
+
+	durationCnRe = regexp.MustCompile(`^(?:(\d+)分)?(\d+)秒$`)
 )
 
 var stringBuilderPool = sync.Pool{
@@ -113,181 +380,953 @@ var stringBuilderPool = sync.Pool{
 	},
 }
 
-var (
-	debugMode = false
-)
+// --- 配置 ---
+
+// Config 服务配置。默认值见 defaultConfig，可由 LYRIC_CONFIG 指定的 TOML/JSON 文件
+// 和 LYRIC_<分组>_<键> 环境变量覆盖，例如 LYRIC_UPSTREAM_BASE_URL、LYRIC_SEARCH_DEFAULT_NUM。
+// 带 secret 标签的字段在管理接口中脱敏。
+type Config struct {
+	Upstream    UpstreamConfig    `json:"upstream"`
+	Search      SearchConfig      `json:"search"`
+	Lyric       LyricConfig       `json:"lyric"`
+	Match       MatchConfig       `json:"match"`
+	Batch       BatchConfig       `json:"batch"`
+	Cache       CacheConfig       `json:"cache"`
+	Log         LogConfig         `json:"log"`
+	Admin       AdminConfig       `json:"admin"`
+	Auth        AuthConfig        `json:"auth"`
+	CORS        CORSConfig        `json:"cors"`
+	HTTPCache   HTTPCacheConfig   `json:"http_cache"`
+	Compression CompressionConfig `json:"compression"`
+	Live        LiveConfig        `json:"live"`
+}
 
-// --- 初始化 ---
+// UpstreamConfig 上游接口
+type UpstreamConfig struct {
+	BaseURL       string         `json:"base_url"`
+	Timeout       configDuration `json:"timeout"`
+	ReadyProbeTTL configDuration `json:"ready_probe_ttl"`
+	Retry         RetryConfig    `json:"retry"`
+	Breaker       BreakerConfig  `json:"breaker"`
+}
 
-func init() {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
-	if os.Getenv("DEBUG") == "true" {
-		debugMode = true
-	}
+// RetryConfig 上游 GET 请求的重试，等待时间为指数退避加随机抖动
+type RetryConfig struct {
+	MaxAttempts    int            `json:"max_attempts"` // 含首次请求，1 表示不重试
+	InitialBackoff configDuration `json:"initial_backoff"`
+	MaxBackoff     configDuration `json:"max_backoff"`
+	Budget         configDuration `json:"budget"` // 一次调用含所有重试的总时长上限，同时受请求 context 的 deadline 限制
 }
 
-func logDebug(format string, args ...interface{}) {
-	if debugMode {
-		log.Printf("[DEBUG] "+format, args...)
-	}
+// BreakerConfig 按上游主机的熔断器
+type BreakerConfig struct {
+	FailureThreshold int            `json:"failure_threshold"` // 连续失败多少次后熔断，0 表示不启用
+	OpenDuration     configDuration `json:"open_duration"`     // 熔断持续时间，之后放行一个探测请求
 }
 
-func logInfo(format string, args ...interface{}) {
-	log.Printf("[INFO] "+format, args...)
+// SearchConfig 关键字搜索
+type SearchConfig struct {
+	DefaultNum       int `json:"default_num"`
+	MaxNum           int `json:"max_num"`
+	ProbeConcurrency int `json:"probe_concurrency"`
 }
 
-func logError(format string, args ...interface{}) {
-	log.Printf("[ERROR] "+format, args...)
+// LyricConfig 歌词解析和转换
+type LyricConfig struct {
+	DivGapMs            int `json:"div_gap_ms"`            // 行间空白超过该值时划分新的 div/段落
+	TranslationWindowMs int `json:"translation_window_ms"` // 翻译与原文行对齐的最大时间差
+	RomajiWindowMs      int `json:"romaji_window_ms"`      // 罗马音与原文行对齐的最大时间差
+	InterludeGapMs      int `json:"interlude_gap_ms"`      // interlude=1 时的间奏判定阈值
+	LintMaxGapMs        int `json:"lint_max_gap_ms"`       // 歌词检查提示超长间隔的阈值
 }
 
-func getTTMLBuilder() *strings.Builder {
-	sb := stringBuilderPool.Get().(*strings.Builder)
-	sb.Reset()
-	return sb
+// MatchConfig 元数据匹配
+type MatchConfig struct {
+	MinScore float64 `json:"min_score"`
 }
 
-func putTTMLBuilder(sb *strings.Builder) {
-	stringBuilderPool.Put(sb)
+// BatchConfig 批量接口
+type BatchConfig struct {
+	MaxItems           int `json:"max_items"`
+	DefaultConcurrency int `json:"default_concurrency"`
+	MaxConcurrency     int `json:"max_concurrency"`
 }
 
-// --- 歌词解析和转换函数 ---
+// CacheConfig 进程内缓存
+type CacheConfig struct {
+	TimelineTTL     configDuration `json:"timeline_ttl"`
+	TimelineMaxSize int            `json:"timeline_max_size"`
+}
 
-func parseLrcMeta(lrcContent string) map[string]string {
-	meta := make(map[string]string)
-	lines := strings.Split(lrcContent, "\n")
-	for _, line := range lines {
-		matches := metaRe.FindStringSubmatch(line)
-		if len(matches) == 3 {
-			meta[strings.TrimSpace(matches[1])] = strings.TrimSpace(matches[2])
-		}
-	}
-	logDebug("解析到 %d 个元数据标签", len(meta))
-	return meta
+// LogConfig 日志
+type LogConfig struct {
+	Level  string `json:"level"`  // debug | info | warn | error
+	Format string `json:"format"` // json | text
 }
 
-func parseYrcLine(line string) (*LineInfo, error) {
-	matches := yrcLineRe.FindStringSubmatch(line)
-	if len(matches) != 4 {
-		return nil, fmt.Errorf("invalid YRC line format: %s", line)
-	}
+// AdminConfig 管理接口，未设置 token 时管理接口不可用
+type AdminConfig struct {
+	Token string `json:"token" secret:"true"`
+}
 
-	startTime, _ := strconv.Atoi(matches[1])
-	duration, _ := strconv.Atoi(matches[2])
-	content := matches[3]
+// AuthConfig API Key 认证，未配置任何 key 时不启用
+type AuthConfig struct {
+	Keys []APIKeyConfig `json:"keys"`
+}
 
-	lineInfo := &LineInfo{
-		StartTime: startTime,
-		EndTime:   startTime + duration,
+// APIKeyConfig 单个 API Key 及其限额，限额按实例计算
+type APIKeyConfig struct {
+	Name       string  `json:"name"` // 用于日志和指标，不要包含密钥本身
+	Key        string  `json:"key" secret:"true"`
+	Rate       float64 `json:"rate"`        // 令牌桶每秒补充的请求数
+	Burst      int     `json:"burst"`       // 令牌桶容量，即允许的突发请求数
+	DailyQuota int     `json:"daily_quota"` // 每个 UTC 自然日的请求上限，0 表示不限
+}
+
+// CORSConfig 跨域策略
+type CORSConfig struct {
+	AllowedOrigins   []string       `json:"allowed_origins"` // "*"、完整的 origin 或 "https://*.example.com" 形式的子域名通配
+	AllowCredentials bool           `json:"allow_credentials"`
+	AllowedMethods   []string       `json:"allowed_methods"`
+	AllowedHeaders   []string       `json:"allowed_headers"`
+	ExposedHeaders   []string       `json:"exposed_headers"`
+	MaxAge           configDuration `json:"max_age"` // 预检结果的缓存时间，0 表示不设置
+}
+
+// HTTPCacheConfig 响应的 Cache-Control，搜索结果和歌词分别设置
+type HTTPCacheConfig struct {
+	Search CachePolicy `json:"search"`
+	Lyric  CachePolicy `json:"lyric"`
+}
+
+// CachePolicy 对应 Cache-Control 的各项，全部为 0 时只允许协商缓存 (no-cache)
+type CachePolicy struct {
+	MaxAge               configDuration `json:"max_age"`  // 浏览器缓存时间
+	SMaxAge              configDuration `json:"s_maxage"` // CDN 缓存时间
+	StaleWhileRevalidate configDuration `json:"stale_while_revalidate"`
+}
+
+// CompressionConfig 响应压缩
+type CompressionConfig struct {
+	Enabled   bool     `json:"enabled"`
+	MinSize   int      `json:"min_size"`  // 小于该字节数的响应不压缩
+	Level     int      `json:"level"`     // 压缩级别，-1 为默认，1~9 越大压缩率越高；br 和 zstd 按相近的级别换算
+	Encodings []string `json:"encodings"` // 按优先级排列，必须是已注册的编码
+}
+
+// LiveConfig 实时歌词同步
+type LiveConfig struct {
+	MaxDuration configDuration `json:"max_duration"` // 单次 SSE 连接的最长时间，应小于函数的最大执行时间
+}
+
+// configDuration 配置中的时长，支持 "10s"、"5m" 形式的字符串或毫秒数
+type configDuration time.Duration
+
+func (d configDuration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d configDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *configDuration) UnmarshalJSON(data []byte) error {
+	var ms int64
+	if err := json.Unmarshal(data, &ms); err == nil {
+		*d = configDuration(time.Duration(ms) * time.Millisecond)
+		return nil
 	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("时长必须是字符串或毫秒数: %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(parsed)
+	return nil
+}
 
-	wordMatches := wordInfoRe.FindAllStringSubmatch(content, -1)
-	for _, match := range wordMatches {
-		if len(match) == 4 {
-			text := match[1]
-			wordStartTime, _ := strconv.Atoi(match[2])
-			wordDuration, _ := strconv.Atoi(match[3])
+func defaultConfig() Config {
+	return Config{
+		Upstream: UpstreamConfig{
+			BaseURL:       "https://api.vkeys.cn/v2/music/tencent",
+			Timeout:       configDuration(10 * time.Second),
+			ReadyProbeTTL: configDuration(30 * time.Second),
+			Retry: RetryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: configDuration(200 * time.Millisecond),
+				MaxBackoff:     configDuration(2 * time.Second),
+				Budget:         configDuration(20 * time.Second),
+			},
+			Breaker: BreakerConfig{FailureThreshold: 5, OpenDuration: configDuration(30 * time.Second)},
+		},
+		Search: SearchConfig{DefaultNum: 10, MaxNum: 60, ProbeConcurrency: 4},
+		Lyric: LyricConfig{
+			DivGapMs:            1000,
+			TranslationWindowMs: 500,
+			RomajiWindowMs:      100,
+			InterludeGapMs:      5000,
+			LintMaxGapMs:        30000,
+		},
+		Match: MatchConfig{MinScore: 0.6},
+		Batch: BatchConfig{MaxItems: 500, DefaultConcurrency: 8, MaxConcurrency: 16},
+		Cache: CacheConfig{TimelineTTL: configDuration(10 * time.Minute), TimelineMaxSize: 256},
+		Log:   LogConfig{Level: "info", Format: "json"},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", "X-Request-ID", "traceparent"},
+			ExposedHeaders: []string{"X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "Retry-After", "ETag"},
+			MaxAge:         configDuration(10 * time.Minute),
+		},
+		Live: LiveConfig{MaxDuration: configDuration(25 * time.Second)},
+		Compression: CompressionConfig{
+			Enabled:   true,
+			MinSize:   1024,
+			Level:     flate.DefaultCompression,
+			Encodings: []string{"zstd", "br", "gzip", "deflate"},
+		},
+		HTTPCache: HTTPCacheConfig{
+			Search: CachePolicy{
+				MaxAge:               configDuration(time.Minute),
+				SMaxAge:              configDuration(10 * time.Minute),
+				StaleWhileRevalidate: configDuration(time.Hour),
+			},
+			Lyric: CachePolicy{
+				MaxAge:               configDuration(time.Hour),
+				SMaxAge:              configDuration(24 * time.Hour),
+				StaleWhileRevalidate: configDuration(7 * 24 * time.Hour),
+			},
+		},
+	}
+}
 
-			if wordDuration == 0 {
-				if strings.TrimSpace(text) != "" {
-					wordDuration = 1
-					logDebug("修正: 文本 '%s' (开始 %d) 持续时间为0，设为1ms", text, wordStartTime)
-				} else {
-					continue
-				}
-			}
+// config 当前生效的配置，启动时加载，之后只读
+var config = defaultConfig()
 
-			lineInfo.Words = append(lineInfo.Words, WordInfo{
-				Text:      text,
-				StartTime: wordStartTime,
-				Duration:  wordDuration,
-			})
+// loadConfig 依次应用默认值、配置文件和环境变量，并校验结果
+func loadConfig() (Config, error) {
+	cfg := defaultConfig()
+
+	if path := os.Getenv("LYRIC_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("读取配置文件失败: %w", err)
+		}
+		if strings.HasSuffix(path, ".json") {
+			err = decodeConfigJSON(data, &cfg)
+		} else {
+			err = decodeConfigTOML(string(data), &cfg)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
 		}
 	}
 
-	if len(lineInfo.Words) == 0 && content != "" {
-		wordDuration := duration
-		if wordDuration == 0 {
-			wordDuration = 1
-		}
-		lineInfo.Words = append(lineInfo.Words, WordInfo{
-			Text:      content,
-			StartTime: startTime,
-			Duration:  wordDuration,
-		})
+	// 兼容旧的环境变量
+	if os.Getenv("DEBUG") == "true" {
+		cfg.Log.Level = "debug"
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		cfg.Log.Level = v
+	}
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		cfg.Log.Format = v
 	}
 
-	return lineInfo, nil
+	if err := applyConfigEnv(reflect.ValueOf(&cfg).Elem(), "LYRIC"); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
 }
 
-func parseYrcToLines(yrcContent string) []*LineInfo {
-	var parsedLines []*LineInfo
-	lines := strings.Split(yrcContent, "\n")
+func decodeConfigJSON(data []byte, cfg *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(cfg)
+}
 
-	for _, line := range lines {
-		if !strings.HasPrefix(line, "[") || isMetadataLine(line) {
+// decodeConfigTOML 将 TOML 解析为通用结构后按 JSON 规则解码，未知键视为错误
+func decodeConfigTOML(data string, cfg *Config) error {
+	tree, err := parseTOML(data)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return decodeConfigJSON(encoded, cfg)
+}
+
+// applyConfigEnv 按 json 标签生成环境变量名，如 LYRIC_UPSTREAM_BASE_URL
+func applyConfigEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
 			continue
 		}
-		lineInfo, err := parseYrcLine(line)
-		if err != nil {
-			logError("解析YRC行失败: %v, 行内容: %s", err, line)
+		envName := prefix + "_" + strings.ToUpper(name)
+		fv := v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyConfigEnv(fv, envName); err != nil {
+				return err
+			}
 			continue
 		}
-		if len(lineInfo.Words) == 0 {
+		value, ok := os.LookupEnv(envName)
+		if !ok {
 			continue
 		}
-		parsedLines = append(parsedLines, lineInfo)
+
+		var err error
+		switch {
+		case field.Type == reflect.TypeOf(configDuration(0)):
+			// 与 JSON 配置一致，纯数字按毫秒处理
+			raw := strconv.Quote(value)
+			if _, convErr := strconv.ParseInt(value, 10, 64); convErr == nil {
+				raw = value
+			}
+			var d configDuration
+			if err = d.UnmarshalJSON([]byte(raw)); err == nil {
+				fv.Set(reflect.ValueOf(d))
+			}
+		case field.Type.Kind() == reflect.String:
+			fv.SetString(value)
+		case field.Type.Kind() == reflect.Int:
+			var n int64
+			if n, err = strconv.ParseInt(value, 10, 64); err == nil {
+				fv.SetInt(n)
+			}
+		case field.Type.Kind() == reflect.Float64:
+			var f float64
+			if f, err = strconv.ParseFloat(value, 64); err == nil {
+				fv.SetFloat(f)
+			}
+		case field.Type.Kind() == reflect.Bool:
+			var b bool
+			if b, err = strconv.ParseBool(value); err == nil {
+				fv.SetBool(b)
+			}
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String && !strings.HasPrefix(value, "["):
+			// 字符串列表也可以用逗号分隔，如 LYRIC_CORS_ALLOWED_ORIGINS=https://a.com,https://*.b.com
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			fv.Set(reflect.ValueOf(items))
+		case field.Type.Kind() == reflect.Slice:
+			// 列表以 JSON 数组形式设置，如 LYRIC_AUTH_KEYS='[{"name":"web","key":"...","rate":5,"burst":10}]'
+			decoder := json.NewDecoder(strings.NewReader(value))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(fv.Addr().Interface())
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("环境变量 %s 的值 '%s' 无效: %w", envName, value, err)
+		}
 	}
-	return parsedLines
+	return nil
 }
 
-func parseLrcTimedLines(lrcContent string) []MetaLine {
-	if strings.TrimSpace(lrcContent) == "" {
-		return nil
+// validate 校验配置，返回所有问题
+func (c Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
 	}
 
-	var timedLines []MetaLine
-	lines := strings.Split(lrcContent, "\n")
+	u, err := url.Parse(c.Upstream.BaseURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "upstream.base_url 必须是 http(s) 地址")
+	check(c.Upstream.Timeout > 0, "upstream.timeout 必须大于 0")
+	check(c.Upstream.ReadyProbeTTL >= 0, "upstream.ready_probe_ttl 不能为负")
+	check(c.Upstream.Retry.MaxAttempts >= 1, "upstream.retry.max_attempts 至少为 1")
+	check(c.Upstream.Retry.InitialBackoff > 0 && c.Upstream.Retry.InitialBackoff <= c.Upstream.Retry.MaxBackoff,
+		"upstream.retry.initial_backoff 必须大于 0 且不超过 max_backoff")
+	check(c.Upstream.Retry.Budget > 0, "upstream.retry.budget 必须大于 0")
+	check(c.Upstream.Breaker.FailureThreshold >= 0, "upstream.breaker.failure_threshold 不能为负")
+	check(c.Upstream.Breaker.FailureThreshold == 0 || c.Upstream.Breaker.OpenDuration > 0, "upstream.breaker.open_duration 必须大于 0")
+	check(c.Search.DefaultNum > 0 && c.Search.DefaultNum <= c.Search.MaxNum, "search.default_num 必须在 1 到 search.max_num 之间")
+	check(c.Search.ProbeConcurrency > 0, "search.probe_concurrency 必须大于 0")
+	check(c.Lyric.DivGapMs > 0, "lyric.div_gap_ms 必须大于 0")
+	check(c.Lyric.TranslationWindowMs > 0, "lyric.translation_window_ms 必须大于 0")
+	check(c.Lyric.RomajiWindowMs >= 0, "lyric.romaji_window_ms 不能为负")
+	check(c.Lyric.InterludeGapMs > 0, "lyric.interlude_gap_ms 必须大于 0")
+	check(c.Lyric.LintMaxGapMs > 0, "lyric.lint_max_gap_ms 必须大于 0")
+	check(validMinScore(c.Match.MinScore), "match.min_score 必须在 0 到 1 之间")
+	check(c.Batch.MaxItems > 0, "batch.max_items 必须大于 0")
+	check(c.Batch.DefaultConcurrency > 0 && c.Batch.DefaultConcurrency <= c.Batch.MaxConcurrency, "batch.default_concurrency 必须在 1 到 batch.max_concurrency 之间")
+	check(c.Live.MaxDuration > 0, "live.max_duration 必须大于 0")
+	check(c.Cache.TimelineTTL > 0, "cache.timeline_ttl 必须大于 0")
+	check(c.Cache.TimelineMaxSize > 0, "cache.timeline_max_size 必须大于 0")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level 必须是 debug、info、warn 或 error")
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format 必须是 json 或 text")
+
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, k := range c.Auth.Keys {
+		check(k.Name != "" && !names[k.Name], "auth.keys[%d].name 不能为空或重复", i)
+		check(k.Key != "" && !keys[k.Key], "auth.keys[%d].key 不能为空或重复", i)
+		check(k.Rate > 0, "auth.keys[%d].rate 必须大于 0", i)
+		check(k.Burst >= 1, "auth.keys[%d].burst 至少为 1", i)
+		check(k.DailyQuota >= 0, "auth.keys[%d].daily_quota 不能为负", i)
+		names[k.Name], keys[k.Key] = true, true
+	}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(validOriginPattern(origin), "cors.allowed_origins 中的 '%s' 不是合法的 origin", origin)
+		// 携带凭据时浏览器不接受 "*"，而把任意 origin 原样返回等于关闭了跨域保护
+		check(origin != "*" || !c.CORS.AllowCredentials, "cors.allow_credentials 不能与 allowed_origins = \"*\" 同时使用")
+	}
+	check(len(c.CORS.AllowedMethods) > 0, "cors.allowed_methods 不能为空")
+	check(c.CORS.MaxAge >= 0, "cors.max_age 不能为负")
+	check(c.Compression.MinSize >= 0, "compression.min_size 不能为负")
+	check(c.Compression.Level >= flate.HuffmanOnly && c.Compression.Level <= flate.BestCompression, "compression.level 必须在 -2 到 9 之间")
+	for _, name := range c.Compression.Encodings {
+		_, ok := responseEncoders[name]
+		check(ok, "compression.encodings 中的 '%s' 未注册，可选 zstd、br、gzip、deflate", name)
+	}
+	check(c.HTTPCache.Search.MaxAge >= 0 && c.HTTPCache.Search.SMaxAge >= 0 && c.HTTPCache.Search.StaleWhileRevalidate >= 0,
+		"http_cache.search 的时长不能为负")
+	check(c.HTTPCache.Lyric.MaxAge >= 0 && c.HTTPCache.Lyric.SMaxAge >= 0 && c.HTTPCache.Lyric.StaleWhileRevalidate >= 0,
+		"http_cache.lyric 的时长不能为负")
 
-		matches := lrcTimeRe.FindStringSubmatch(line)
-		if len(matches) == 5 {
-			minutes, _ := strconv.Atoi(matches[1])
-			seconds, _ := strconv.Atoi(matches[2])
+	if len(problems) > 0 {
+		return fmt.Errorf("配置无效: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
 
-			var milliseconds int
-			msStr := matches[3]
-			if len(msStr) == 2 {
-				milliseconds, _ = strconv.Atoi(msStr)
-				milliseconds *= 10
-			} else {
-				milliseconds, _ = strconv.Atoi(msStr)
+// redactConfig 将配置转换为通用结构，secret 字段非空时替换为 "******"
+func redactConfig(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		if _, ok := v.Interface().(json.Marshaler); ok {
+			return v.Interface()
+		}
+		result := make(map[string]interface{})
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
 			}
-
-			totalMs := minutes*60*1000 + seconds*1000 + milliseconds
-			content := strings.TrimSpace(matches[4])
-
-			if content != "" && content != "//" && !strings.Contains(content, "QQ音乐") && !strings.Contains(content, "制作") {
-				timedLines = append(timedLines, MetaLine{
-					Time:    totalMs,
-					Content: content,
-				})
+			if field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+				result[name] = "******"
+				continue
 			}
+			result[name] = redactConfig(v.Field(i))
+		}
+		return result
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = redactConfig(v.Index(i))
 		}
+		return items
+	case reflect.Map:
+		result := make(map[string]interface{})
+		for _, key := range v.MapKeys() {
+			result[fmt.Sprint(key.Interface())] = redactConfig(v.MapIndex(key))
+		}
+		return result
 	}
+	return v.Interface()
+}
 
-	sort.Slice(timedLines, func(i, j int) bool {
-		return timedLines[i].Time < timedLines[j].Time
-	})
+// authorizeAdmin 校验 Authorization: Bearer <admin.token>，未通过时写入错误响应并返回 false
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if config.Admin.Token == "" {
+		writeErrorJSON(w, r, http.StatusForbidden, ErrCodeForbidden, "管理接口未启用", "未配置 admin.token")
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.Admin.Token)) != 1 {
+		writeErrorJSON(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "未授权", "需要有效的管理令牌")
+		return false
+	}
+	return true
+}
 
-	return timedLines
+// adminConfigHandler 返回当前生效的配置 (已脱敏)，需要 Authorization: Bearer <admin.token>
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	renderJSON(w, http.StatusOK, redactConfig(reflect.ValueOf(config)))
 }
 
-// mergeLrcWithTranslation 合并原始LRC和翻译LRC
+// --- 跨域 ---
+
+// validOriginPattern 检查 origin 配置：scheme://host[:port]，host 可以以 "*." 开头
+func validOriginPattern(pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	scheme, host, ok := strings.Cut(pattern, "://")
+	if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+		return false
+	}
+	host = strings.TrimPrefix(host, "*.")
+	return host != "" && !strings.Contains(host, "*")
+}
+
+// originAllowed 判断请求的 Origin 是否在允许列表中，"https://*.example.com" 匹配其任意子域名但不含 example.com 本身
+func originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range config.CORS.AllowedOrigins {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		scheme, host, _ := strings.Cut(pattern, "://")
+		if !strings.HasPrefix(host, "*.") {
+			continue
+		}
+		if strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, host[1:]) &&
+			len(origin) > len(scheme)+3+len(host)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCORS 按配置写入跨域响应头，返回值表示是否为已处理的预检请求
+func applyCORS(w http.ResponseWriter, r *http.Request) bool {
+	cors := config.CORS
+	origin := r.Header.Get("Origin")
+	wildcard := len(cors.AllowedOrigins) == 1 && cors.AllowedOrigins[0] == "*"
+	if !wildcard {
+		w.Header().Add("Vary", "Origin")
+	}
+
+	allowed := wildcard || (origin != "" && originAllowed(origin))
+	if allowed {
+		if wildcard {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if cors.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if len(cors.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+		}
+	}
+
+	if r.Method != http.MethodOptions {
+		return false
+	}
+	// 预检请求：不允许的 origin 不返回任何 CORS 头，由浏览器拒绝
+	if allowed {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+		if len(cors.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+		}
+		if cors.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Duration().Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// --- TOML 解析 ---
+
+// parseTOML 解析配置所需的 TOML 子集：[表]、[[表数组]]、key = value，
+// 值支持字符串、整数、浮点数、布尔和数组 (可跨行)，不支持内联表和日期。
+func parseTOML(data string) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	current := root
+
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") && strings.HasSuffix(line, "]]") {
+			path := strings.Split(strings.TrimSpace(line[2:len(line)-2]), ".")
+			parent, err := tomlTable(root, path[:len(path)-1])
+			if err != nil {
+				return nil, fmt.Errorf("第 %d 行: %w", lineNo, err)
+			}
+			key := strings.TrimSpace(path[len(path)-1])
+			array, _ := parent[key].([]interface{})
+			if _, exists := parent[key]; exists && array == nil {
+				return nil, fmt.Errorf("第 %d 行: '%s' 不是表数组", lineNo, key)
+			}
+			table := make(map[string]interface{})
+			parent[key] = append(array, table)
+			current = table
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table, err := tomlTable(root, strings.Split(strings.TrimSpace(line[1:len(line)-1]), "."))
+			if err != nil {
+				return nil, fmt.Errorf("第 %d 行: %w", lineNo, err)
+			}
+			current = table
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("第 %d 行: 缺少 '='", lineNo)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		raw = strings.TrimSpace(raw)
+		// 多行数组：累积到括号配对为止
+		for strings.HasPrefix(raw, "[") && strings.Count(raw, "[") > strings.Count(raw, "]") && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+
+		value, err := parseTOMLValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %w", lineNo, err)
+		}
+		if _, exists := current[key]; exists {
+			return nil, fmt.Errorf("第 %d 行: 重复的键 '%s'", lineNo, key)
+		}
+		current[key] = value
+	}
+	return root, nil
+}
+
+// tomlTable 按路径取得 (必要时创建) 表，路径上的表数组取最后一个元素
+func tomlTable(root map[string]interface{}, path []string) (map[string]interface{}, error) {
+	table := root
+	for _, part := range path {
+		part = strings.Trim(strings.TrimSpace(part), `"`)
+		switch next := table[part].(type) {
+		case nil:
+			created := make(map[string]interface{})
+			table[part] = created
+			table = created
+		case map[string]interface{}:
+			table = next
+		case []interface{}:
+			table = next[len(next)-1].(map[string]interface{})
+		default:
+			return nil, fmt.Errorf("'%s' 不是表", part)
+		}
+	}
+	return table, nil
+}
+
+// stripTOMLComment 去掉不在字符串内的 # 注释
+func stripTOMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0 && c == quote && (quote == '\'' || i == 0 || line[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseTOMLValue(raw string) (interface{}, error) {
+	switch {
+	case raw == "true":
+		return true, nil
+	case raw == "false":
+		return false, nil
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'") && strings.HasSuffix(raw, "'") && len(raw) >= 2:
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]"):
+		items := []interface{}{}
+		for _, part := range splitTOMLArray(raw[1 : len(raw)-1]) {
+			item, err := parseTOMLValue(part)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+
+	number := strings.ReplaceAll(raw, "_", "")
+	if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("无法解析的值: %s", raw)
+}
+
+// splitTOMLArray 按顶层逗号拆分数组元素，忽略字符串和嵌套数组内的逗号
+func splitTOMLArray(s string) []string {
+	var parts []string
+	var quote rune
+	depth, start := 0, 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote && (quote == '\'' || s[i-1] != '\\') {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+// --- 初始化 ---
+
+var (
+	// logger 全局结构化日志，请求内的日志通过 context 附带 request_id 等字段
+	logger *slog.Logger
+
+	// configErr 加载配置时的错误。默认配置不含 API Key 等限制，
+	// 因此除健康检查外的请求都返回 503，直到配置修正后重新部署
+	configErr error
+)
+
+func init() {
+	cfg, err := loadConfig()
+	if err != nil {
+		configErr = err
+		cfg = defaultConfig()
+	}
+	config = cfg
+
+	var level slog.Level
+	level.UnmarshalText([]byte(config.Log.Level))
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if config.Log.Format == "text" {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	logger = slog.New(handler)
+
+	if configErr != nil {
+		logger.Error("配置无效，拒绝健康检查以外的请求", "error", configErr)
+	}
+}
+
+type logContextKey struct{}
+
+// withLogAttrs 返回附带日志字段的 context，之后通过该 context 输出的日志都会带上这些字段
+func withLogAttrs(ctx context.Context, args ...interface{}) context.Context {
+	return context.WithValue(ctx, logContextKey{}, loggerFrom(ctx).With(args...))
+}
+
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(logContextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return logger
+}
+
+func logDebug(ctx context.Context, msg string, args ...interface{}) {
+	loggerFrom(ctx).DebugContext(ctx, msg, args...)
+}
+
+func logInfo(ctx context.Context, msg string, args ...interface{}) {
+	loggerFrom(ctx).InfoContext(ctx, msg, args...)
+}
+
+func logWarn(ctx context.Context, msg string, args ...interface{}) {
+	loggerFrom(ctx).WarnContext(ctx, msg, args...)
+}
+
+func logError(ctx context.Context, msg string, args ...interface{}) {
+	loggerFrom(ctx).ErrorContext(ctx, msg, args...)
+}
+
+// requestID 取请求头 X-Request-ID，没有时生成一个
+func requestID(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get("X-Request-ID")); id != "" && len(id) <= 128 {
+		return id
+	}
+	return newRandomID()
+}
+
+func getTTMLBuilder() *strings.Builder {
+	sb := stringBuilderPool.Get().(*strings.Builder)
+	sb.Reset()
+	return sb
+}
+
+func putTTMLBuilder(sb *strings.Builder) {
+	stringBuilderPool.Put(sb)
+}
+
+// --- 歌词解析和转换函数 ---
+
+func parseLrcMeta(lrcContent string) map[string]string {
+	meta := make(map[string]string)
+	lines := strings.Split(lrcContent, "\n")
+	for _, line := range lines {
+		matches := metaRe.FindStringSubmatch(line)
+		if len(matches) == 3 {
+			meta[strings.TrimSpace(matches[1])] = strings.TrimSpace(matches[2])
+		}
+	}
+	return meta
+}
+
+func parseYrcLine(line string) (*LineInfo, error) {
+	matches := yrcLineRe.FindStringSubmatch(line)
+	if len(matches) != 4 {
+		return nil, fmt.Errorf("invalid YRC line format: %s", line)
+	}
+
+	startTime, _ := strconv.Atoi(matches[1])
+	duration, _ := strconv.Atoi(matches[2])
+	content := matches[3]
+
+	lineInfo := &LineInfo{
+		StartTime: startTime,
+		EndTime:   startTime + duration,
+	}
+
+	wordMatches := wordInfoRe.FindAllStringSubmatch(content, -1)
+	for _, match := range wordMatches {
+		if len(match) == 4 {
+			text := match[1]
+			wordStartTime, _ := strconv.Atoi(match[2])
+			wordDuration, _ := strconv.Atoi(match[3])
+			zeroDur := false
+
+			if wordDuration == 0 {
+				if strings.TrimSpace(text) != "" {
+					wordDuration = 1
+					zeroDur = true
+				} else {
+					continue
+				}
+			}
+
+			lineInfo.Words = append(lineInfo.Words, WordInfo{
+				Text:      text,
+				StartTime: wordStartTime,
+				Duration:  wordDuration,
+				ZeroDur:   zeroDur,
+			})
+		}
+	}
+
+	if len(lineInfo.Words) == 0 && content != "" {
+		wordDuration := duration
+		if wordDuration == 0 {
+			wordDuration = 1
+		}
+		lineInfo.Words = append(lineInfo.Words, WordInfo{
+			Text:      content,
+			StartTime: startTime,
+			Duration:  wordDuration,
+			ZeroDur:   duration == 0,
+		})
+	}
+
+	return lineInfo, nil
+}
+
+func parseYrcToLines(ctx context.Context, yrcContent string) []*LineInfo {
+	var parsedLines []*LineInfo
+	lines := strings.Split(yrcContent, "\n")
+
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "[") || isMetadataLine(line) {
+			continue
+		}
+		lineInfo, err := parseYrcLine(line)
+		if err != nil {
+			logError(ctx, "解析YRC行失败", "error", err, "line", line)
+			continue
+		}
+		if len(lineInfo.Words) == 0 {
+			continue
+		}
+		parsedLines = append(parsedLines, lineInfo)
+	}
+	return parsedLines
+}
+
+func parseLrcTimedLines(lrcContent string) []MetaLine {
+	if strings.TrimSpace(lrcContent) == "" {
+		return nil
+	}
+
+	var timedLines []MetaLine
+	lines := strings.Split(lrcContent, "\n")
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		matches := lrcTimeRe.FindStringSubmatch(line)
+		if len(matches) == 5 {
+			minutes, _ := strconv.Atoi(matches[1])
+			seconds, _ := strconv.Atoi(matches[2])
+
+			var milliseconds int
+			msStr := matches[3]
+			if len(msStr) == 2 {
+				milliseconds, _ = strconv.Atoi(msStr)
+				milliseconds *= 10
+			} else {
+				milliseconds, _ = strconv.Atoi(msStr)
+			}
+
+			totalMs := minutes*60*1000 + seconds*1000 + milliseconds
+			content := strings.TrimSpace(matches[4])
+
+			if content != "" && content != "//" && !strings.Contains(content, "QQ音乐") && !strings.Contains(content, "制作") {
+				timedLines = append(timedLines, MetaLine{
+					Time:    totalMs,
+					Content: content,
+				})
+			}
+		}
+	}
+
+	sort.Slice(timedLines, func(i, j int) bool {
+		return timedLines[i].Time < timedLines[j].Time
+	})
+
+	return timedLines
+}
+
+// mergeLrcWithTranslation 合并原始LRC和翻译LRC
 func mergeLrcWithTranslation(originalLrc, transLrc string) string {
 	if strings.TrimSpace(transLrc) == "" {
 		return originalLrc
@@ -325,567 +1364,5691 @@ func mergeLrcWithTranslation(originalLrc, transLrc string) string {
 			}
 			totalMs := minutes*60*1000 + seconds*1000 + milliseconds
 
-			// 查找匹配的翻译
-			transText := findClosestLine(totalMs, translations)
-			if transText != "" {
-				// 使用相同的时间戳格式写入翻译
-				timestamp := msToLrcTime(totalMs)
-				result.WriteString(fmt.Sprintf("%s%s\n", timestamp, transText))
-			}
+			// 查找匹配的翻译
+			transText := findClosestLine(totalMs, translations)
+			if transText != "" {
+				// 使用相同的时间戳格式写入翻译
+				timestamp := msToLrcTime(totalMs)
+				result.WriteString(fmt.Sprintf("%s%s\n", timestamp, transText))
+			}
+		}
+	}
+
+	return result.String()
+}
+
+// parseLyricData 将上游歌词数据解析为统一模型，行和词保持上游顺序。
+// 假名注音由需要的输出另外调用 applyKanaRuby 添加
+func parseLyricData(ctx context.Context, data *LyricData) *ParsedLyric {
+	parsed := &ParsedLyric{
+		Meta:         parseLrcMeta(data.Data.Lrc),
+		Lines:        parseYrcToLines(ctx, data.Data.Yrc),
+		Translations: parseLrcTimedLines(data.Data.Trans),
+		Romaji:       parseYrcToLines(ctx, data.Data.Roma),
+	}
+
+	// 上游给了 YRC 却没能解析出任何行，之后按逐行 LRC 估算
+	if len(parsed.Lines) == 0 && strings.TrimSpace(data.Data.Yrc) != "" {
+		logWarn(ctx, "YRC 歌词无法解析", "bytes", len(data.Data.Yrc))
+		conversionFailures.inc("yrc")
+	}
+
+	// 上游没有逐字歌词时，根据逐行 LRC 估算逐字时间
+	if len(parsed.Lines) == 0 {
+		parsed.Lines = synthesizeWordTiming(parseLrcTimedLines(data.Data.Lrc))
+		parsed.Estimated = len(parsed.Lines) > 0
+	}
+
+	zeroDur := 0
+	for _, line := range parsed.Lines {
+		for _, word := range line.Words {
+			if word.ZeroDur {
+				zeroDur++
+			}
+		}
+	}
+	logDebug(ctx, "歌词解析完成", "meta", len(parsed.Meta), "lines", len(parsed.Lines),
+		"translations", len(parsed.Translations), "estimated", parsed.Estimated, "zero_duration_fixed", zeroDur)
+	return parsed
+}
+
+// --- 逐字时间估算 ---
+
+const (
+	estimatedUnitMs    = 500 // 最后一行每个音节的估算时长
+	estimatedMaxUnitMs = 800 // 每个音节的最长时长，超出部分视为行间空白
+)
+
+type timingToken struct {
+	Text   string
+	Weight int
+}
+
+// isCJKChar 判断字符是否按单字计时 (汉字、假名、谚文)
+func isCJKChar(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// countSyllables 粗略统计拉丁文单词的音节数 (连续元音算一个)
+func countSyllables(word string) int {
+	count := 0
+	prevVowel := false
+	for _, r := range strings.ToLower(word) {
+		isVowel := strings.ContainsRune("aeiouyàáâäèéêëìíîïòóôöùúûü", r)
+		if isVowel && !prevVowel {
+			count++
+		}
+		prevVowel = isVowel
+	}
+	if count == 0 {
+		count = 1
+	}
+	return count
+}
+
+// tokenizeForTiming 将一行文本切分为计时单元：CJK 按字，其他文字按空格分词。
+// 空白和标点并入相邻单元，不单独占用时间。
+func tokenizeForTiming(text string) []timingToken {
+	var tokens []timingToken
+	var pending strings.Builder // 尚未归属的前导标点
+
+	appendToLast := func(s string) {
+		if len(tokens) == 0 {
+			pending.WriteString(s)
+			return
+		}
+		tokens[len(tokens)-1].Text += s
+	}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case isCJKChar(r):
+			tokens = append(tokens, timingToken{Text: pending.String() + string(r), Weight: 1})
+			pending.Reset()
+			i++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !isCJKChar(runes[j]) {
+				j++
+			}
+			word := string(runes[i:j])
+			tokens = append(tokens, timingToken{Text: pending.String() + word, Weight: countSyllables(word)})
+			pending.Reset()
+			i = j
+		default:
+			appendToLast(string(r))
+			i++
+		}
+	}
+
+	if pending.Len() > 0 {
+		if len(tokens) == 0 {
+			tokens = append(tokens, timingToken{Text: pending.String(), Weight: 1})
+		} else {
+			tokens[len(tokens)-1].Text += pending.String()
+		}
+	}
+	return tokens
+}
+
+// synthesizeWordTiming 将逐行 LRC 转换为估算的逐字时间。
+// 每行时长按音节/字数加权分配，且不超过下一行的开始时间。
+func synthesizeWordTiming(timedLines []MetaLine) []*LineInfo {
+	var lines []*LineInfo
+	for i, timed := range timedLines {
+		tokens := tokenizeForTiming(timed.Content)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		totalWeight := 0
+		for _, token := range tokens {
+			totalWeight += token.Weight
+		}
+
+		duration := totalWeight * estimatedUnitMs
+		if i+1 < len(timedLines) {
+			available := timedLines[i+1].Time - timed.Time
+			duration = totalWeight * estimatedMaxUnitMs
+			if available < duration {
+				duration = available
+			}
+		}
+		if duration < len(tokens) {
+			duration = len(tokens)
+		}
+
+		lineInfo := &LineInfo{
+			StartTime: timed.Time,
+			EndTime:   timed.Time + duration,
+		}
+
+		elapsedWeight := 0
+		for _, token := range tokens {
+			wordStart := timed.Time + duration*elapsedWeight/totalWeight
+			elapsedWeight += token.Weight
+			wordEnd := timed.Time + duration*elapsedWeight/totalWeight
+			if wordEnd <= wordStart {
+				wordEnd = wordStart + 1
+			}
+			lineInfo.Words = append(lineInfo.Words, WordInfo{
+				Text:      token.Text,
+				StartTime: wordStart,
+				Duration:  wordEnd - wordStart,
+			})
+		}
+		lines = append(lines, lineInfo)
+	}
+	return lines
+}
+
+// --- 假名注音 ---
+
+type kanaEntry struct {
+	Count   int
+	Reading string
+}
+
+// parseKanaTag 解析 [kana:] 标签，格式为 "<汉字个数><读音>" 的重复序列，如 "1こころ2いっしょ"
+func parseKanaTag(kana string) []kanaEntry {
+	var entries []kanaEntry
+	for _, match := range kanaRe.FindAllStringSubmatch(kana, -1) {
+		count, _ := strconv.Atoi(match[1])
+		reading := strings.TrimSpace(match[2])
+		if count <= 0 || reading == "" {
+			continue
+		}
+		entries = append(entries, kanaEntry{Count: count, Reading: reading})
+	}
+	return entries
+}
+
+func isKanji(r rune) bool {
+	return unicode.Is(unicode.Han, r) || r == '々' || r == '〆' || r == 'ヶ'
+}
+
+// applyKanaRuby 按歌词中汉字出现的顺序依次消费 kana 条目，为每个词生成注音。
+// YRC 把汉字逐字拆成词，一个条目跨越同一行中连续的汉字词时，注音从起始词延续到这些词上，
+// 词本身和时间轴保持不变；无法延续 (中间有假名等) 时读音只标注在起始词上。
+func applyKanaRuby(ctx context.Context, lines []*LineInfo, kana string) {
+	entries := parseKanaTag(kana)
+	if len(entries) == 0 {
+		return
+	}
+
+	next := 0
+	remaining := 0
+	for _, line := range lines {
+		for wi := 0; wi < len(line.Words); wi++ {
+			word := &line.Words[wi]
+			runes := []rune(word.Text)
+			for i := 0; i < len(runes); i++ {
+				if !isKanji(runes[i]) {
+					continue
+				}
+				if remaining > 0 {
+					remaining--
+					continue
+				}
+				if next >= len(entries) {
+					logDebug(ctx, "kana 条目不足，注音提前停止", "word", word.Text)
+					return
+				}
+
+				entry := entries[next]
+				next++
+				ruby := RubyInfo{Start: i, End: i + 1, Reading: entry.Reading}
+				for ruby.End < len(runes) && ruby.End-i < entry.Count && isKanji(runes[ruby.End]) {
+					ruby.End++
+				}
+				covered := ruby.End - i
+
+				// 汉字延续到词尾时继续覆盖后续以汉字开头的词
+				last := runes
+				for covered < entry.Count && ruby.End == len(last) && wi+ruby.Words+1 < len(line.Words) {
+					following := []rune(line.Words[wi+ruby.Words+1].Text)
+					n := 0
+					for n < len(following) && covered < entry.Count && isKanji(following[n]) {
+						n++
+						covered++
+					}
+					if n == 0 {
+						break
+					}
+					ruby.Words++
+					ruby.End = n
+					last = following
+				}
+				remaining = entry.Count - covered
+				word.Ruby = append(word.Ruby, ruby)
+
+				// 从注音结束的位置继续扫描
+				if ruby.Words > 0 {
+					wi += ruby.Words
+					word = &line.Words[wi]
+					runes = last
+				}
+				i = ruby.End - 1
+			}
+		}
+	}
+
+	if next < len(entries) {
+		logDebug(ctx, "kana 条目多于歌词中的汉字", "unused", len(entries)-next)
+	}
+}
+
+type rubySegment struct {
+	Text    string
+	Reading string
+}
+
+// rubySegments 将词文本切分为普通文本段和注音段，供 TTML/HTML 输出使用。
+// 跨词的注音不在这里处理，见 lineRubyRuns
+func rubySegments(word WordInfo) []rubySegment {
+	var segments []rubySegment
+	runes := []rune(word.Text)
+	pos := 0
+	for _, ruby := range word.Ruby {
+		if ruby.Words > 0 || ruby.Start < pos || ruby.End > len(runes) {
+			continue
+		}
+		if ruby.Start > pos {
+			segments = append(segments, rubySegment{Text: string(runes[pos:ruby.Start])})
+		}
+		segments = append(segments, rubySegment{Text: string(runes[ruby.Start:ruby.End]), Reading: ruby.Reading})
+		pos = ruby.End
+	}
+	if pos < len(runes) {
+		segments = append(segments, rubySegment{Text: string(runes[pos:])})
+	}
+	return segments
+}
+
+// rubyRun 一行歌词输出时的一段：Reading 为空时是单个词 (词内注音见 rubySegments)，
+// 否则是跨词注音组，Words 为组内的词片段
+type rubyRun struct {
+	Words   []WordInfo
+	Reading string
+}
+
+// lineRubyRuns 按跨词注音把一行的词分段。注音只覆盖首尾词的一部分时，
+// 词被拆成时间相同的多个片段，组外的片段保留各自的词内注音
+func lineRubyRuns(line *LineInfo) []rubyRun {
+	var runs []rubyRun
+	var carry *WordInfo // 上一个注音组最后一个词的剩余部分
+	for wi := 0; wi < len(line.Words); wi++ {
+		word := line.Words[wi]
+		if carry != nil {
+			word, carry = *carry, nil
+		}
+		runes := []rune(word.Text)
+
+		span := -1
+		for k, ruby := range word.Ruby {
+			if ruby.Words > 0 && wi+ruby.Words < len(line.Words) && ruby.Start < len(runes) {
+				span = k
+				break
+			}
+		}
+		if span < 0 {
+			runs = append(runs, rubyRun{Words: []WordInfo{word}})
+			continue
+		}
+
+		ruby := word.Ruby[span]
+		if ruby.Start > 0 {
+			runs = append(runs, rubyRun{Words: []WordInfo{wordPiece(word, 0, ruby.Start)}})
+		}
+		group := rubyRun{Reading: ruby.Reading, Words: []WordInfo{wordPiece(word, ruby.Start, len(runes))}}
+		for k := 1; k < ruby.Words; k++ {
+			middle := line.Words[wi+k]
+			group.Words = append(group.Words, wordPiece(middle, 0, utf8.RuneCountInString(middle.Text)))
+		}
+		wi += ruby.Words
+		last := line.Words[wi]
+		lastLen := utf8.RuneCountInString(last.Text)
+		end := ruby.End
+		if end > lastLen {
+			end = lastLen
+		}
+		group.Words = append(group.Words, wordPiece(last, 0, end))
+		for k := range group.Words {
+			group.Words[k].Ruby = nil
+		}
+		runs = append(runs, group)
+
+		if end < lastLen {
+			rest := wordPiece(last, end, lastLen)
+			carry = &rest
+			wi--
+		}
+	}
+	return runs
+}
+
+// wordPiece 返回词文本 [start, end) 部分，时间不变，只保留从该范围内开始的注音
+func wordPiece(word WordInfo, start, end int) WordInfo {
+	runes := []rune(word.Text)
+	piece := word
+	piece.Text = string(runes[start:end])
+	piece.Ruby = nil
+	for _, ruby := range word.Ruby {
+		if ruby.Start < start || ruby.Start >= end || (ruby.Words == 0 && ruby.End > end) {
+			continue
+		}
+		ruby.Start -= start
+		if ruby.Words == 0 {
+			ruby.End -= start
+		}
+		piece.Ruby = append(piece.Ruby, ruby)
+	}
+	return piece
+}
+
+// rubyBase 返回第 wi 个词上的注音覆盖的文本，越界时 ok 为 false
+func rubyBase(line *LineInfo, wi int, ruby RubyInfo) (string, bool) {
+	runes := []rune(line.Words[wi].Text)
+	if ruby.Words == 0 {
+		if ruby.Start < 0 || ruby.End > len(runes) || ruby.Start >= ruby.End {
+			return "", false
+		}
+		return string(runes[ruby.Start:ruby.End]), true
+	}
+	if ruby.Start >= len(runes) || wi+ruby.Words >= len(line.Words) {
+		return "", false
+	}
+	base := string(runes[ruby.Start:])
+	for k := 1; k < ruby.Words; k++ {
+		base += line.Words[wi+k].Text
+	}
+	last := []rune(line.Words[wi+ruby.Words].Text)
+	if ruby.End > len(last) {
+		return "", false
+	}
+	return base + string(last[:ruby.End]), true
+}
+
+// --- 间奏检测 ---
+
+const (
+	interludeLrcText  = "…"
+	interludeDotCount = 3 // ESLRC 倒计时圆点数
+)
+
+// detectInterludes 找出行间空白超过 threshold 的区间，包括第一行之前的前奏
+func detectInterludes(lines []*LineInfo, threshold int) []InterludeInfo {
+	if len(lines) == 0 || threshold <= 0 {
+		return nil
+	}
+
+	var interludes []InterludeInfo
+	if lines[0].StartTime > threshold {
+		interludes = append(interludes, InterludeInfo{StartTime: 0, EndTime: lines[0].StartTime})
+	}
+	for i := 1; i < len(lines); i++ {
+		prevEnd := lineContentEndTime(lines[i-1])
+		if lines[i].StartTime-prevEnd > threshold {
+			interludes = append(interludes, InterludeInfo{StartTime: prevEnd, EndTime: lines[i].StartTime})
+		}
+	}
+	return interludes
+}
+
+// parseInterludeThreshold 解析 interlude 参数：1/true 使用默认阈值，数字为自定义阈值 (ms)
+func parseInterludeThreshold(value string) int {
+	switch value {
+	case "", "0", "false":
+		return 0
+	case "1", "true":
+		return config.Lyric.InterludeGapMs
+	}
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 0 {
+		return config.Lyric.InterludeGapMs
+	}
+	return threshold
+}
+
+// insertLrcInterludes 在 LRC 中间奏开始处插入省略号占位行
+func insertLrcInterludes(lrc string, interludes []InterludeInfo) string {
+	if len(interludes) == 0 {
+		return lrc
+	}
+
+	var result strings.Builder
+	next := 0
+	for _, line := range strings.Split(lrc, "\n") {
+		if matches := lrcTimeRe.FindStringSubmatch(line); len(matches) == 5 {
+			lineTime := parseLrcTime(matches[1], matches[2], matches[3])
+			for next < len(interludes) && interludes[next].StartTime < lineTime {
+				result.WriteString(msToLrcTime(interludes[next].StartTime) + interludeLrcText + "\n")
+				next++
+			}
+		}
+		if line != "" {
+			result.WriteString(line + "\n")
+		}
+	}
+	for ; next < len(interludes); next++ {
+		result.WriteString(msToLrcTime(interludes[next].StartTime) + interludeLrcText + "\n")
+	}
+	return result.String()
+}
+
+// enhancedLrcInterlude 生成 ESLRC 倒计时占位行，每个圆点平分间奏时长
+func enhancedLrcInterlude(interlude InterludeInfo) string {
+	var sb strings.Builder
+	sb.WriteString(msToLrcTime(interlude.StartTime))
+	span := interlude.EndTime - interlude.StartTime
+	for i := 0; i < interludeDotCount; i++ {
+		sb.WriteString(msToEnhancedLrcTime(interlude.StartTime + span*i/interludeDotCount))
+		sb.WriteString("●")
+	}
+	sb.WriteString(msToEnhancedLrcTime(interlude.EndTime))
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// --- 歌曲段落识别 ---
+
+const (
+	songPartVerse  = "Verse"
+	songPartChorus = "Chorus"
+	songPartBridge = "Bridge"
+	songPartOutro  = "Outro"
+
+	lineSimilarThreshold = 0.8 // 两行文本视为重复的相似度
+)
+
+// normalizeLineText 去除空白和标点并转为小写，用于比较歌词行
+func normalizeLineText(text string) []rune {
+	var runes []rune
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			runes = append(runes, r)
+		}
+	}
+	return runes
+}
+
+// textSimilarity 计算两段文本的字符二元组 Dice 系数
+func textSimilarity(a, b []rune) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		if string(a) == string(b) && len(a) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	bigrams := make(map[[2]rune]int)
+	for i := 0; i+1 < len(a); i++ {
+		bigrams[[2]rune{a[i], a[i+1]}]++
+	}
+	common := 0
+	for i := 0; i+1 < len(b); i++ {
+		key := [2]rune{b[i], b[i+1]}
+		if bigrams[key] > 0 {
+			bigrams[key]--
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(a)+len(b)-2)
+}
+
+func lineText(line *LineInfo) string {
+	var sb strings.Builder
+	for _, word := range line.Words {
+		sb.WriteString(word.Text)
+	}
+	return sb.String()
+}
+
+// labelSongStructure 按时间间隔划分段落，连续两行以上在全曲重复出现的段落标为副歌，
+// 其余段落按相对副歌的位置标为主歌、桥段或尾声。未识别出副歌时不做标注。
+func labelSongStructure(lines []*LineInfo) []LyricSection {
+	for _, line := range lines {
+		line.SongPart = ""
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	texts := make([][]rune, len(lines))
+	for i, line := range lines {
+		texts[i] = normalizeLineText(lineText(line))
+	}
+
+	repeated := make([]bool, len(lines))
+	for i := range lines {
+		for j := range lines {
+			if i != j && textSimilarity(texts[i], texts[j]) >= lineSimilarThreshold {
+				repeated[i] = true
+				break
+			}
+		}
+	}
+
+	// 只有连续出现的重复行才算作副歌的一部分
+	inChorus := make(map[*LineInfo]bool)
+	for i := 0; i < len(lines); {
+		if !repeated[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < len(lines) && repeated[j] {
+			j++
+		}
+		if j-i >= 2 {
+			for k := i; k < j; k++ {
+				inChorus[lines[k]] = true
+			}
+		}
+		i = j
+	}
+	if len(inChorus) == 0 {
+		return nil
+	}
+
+	divs := groupLinesIntoDivs(lines, config.Lyric.DivGapMs)
+	isChorus := make([]bool, len(divs))
+	firstChorus, lastChorus := -1, -1
+	for i, div := range divs {
+		count := 0
+		for _, line := range div.Lines {
+			if inChorus[line] {
+				count++
+			}
+		}
+		if count*2 >= len(div.Lines) {
+			isChorus[i] = true
+			if firstChorus == -1 {
+				firstChorus = i
+			}
+			lastChorus = i
+		}
+	}
+	if firstChorus == -1 {
+		return nil
+	}
+
+	var sections []LyricSection
+	choruses := 0
+	for i, div := range divs {
+		part := songPartVerse
+		switch {
+		case isChorus[i]:
+			part = songPartChorus
+			choruses++
+		case i > lastChorus:
+			part = songPartOutro
+		case choruses >= 2:
+			part = songPartBridge
+		}
+
+		for _, line := range div.Lines {
+			line.SongPart = part
+		}
+		// 相邻的同类 div 合并为一个段落
+		if n := len(sections); n > 0 && sections[n-1].Part == part {
+			sections[n-1].End = div.EndTime
+			continue
+		}
+		sections = append(sections, LyricSection{Part: part, Start: div.StartTime, End: div.EndTime})
+	}
+	return sections
+}
+
+// --- 歌词质量检查 ---
+
+const (
+	lintSeverityError   = "error"
+	lintSeverityWarning = "warning"
+	lintSeverityInfo    = "info"
+)
+
+// lintLyric 检查解析后的歌词模型，fix 为 true 时同时修正可自动修复的问题。
+// 应在添加注音等处理之前调用，行号和词序号均指向修正前的上游顺序。
+// 逐字时间为估算值时只报告 estimated-timing，不检查时间轴。
+func lintLyric(parsed *ParsedLyric, fix bool) LintReport {
+	report := LintReport{Findings: []LintFinding{}}
+	add := func(f LintFinding) {
+		switch f.Severity {
+		case lintSeverityError:
+			report.Errors++
+		case lintSeverityWarning:
+			report.Warnings++
+		default:
+			report.Infos++
+		}
+		if f.Fixed {
+			report.Fixed++
+		}
+		report.Findings = append(report.Findings, f)
+	}
+
+	if parsed.Estimated {
+		add(LintFinding{
+			Rule: "estimated-timing", Severity: lintSeverityInfo,
+			Message: "上游没有逐字歌词，逐字时间由逐行 LRC 估算，未检查时间轴",
+		})
+		return report
+	}
+
+	lines := parsed.Lines
+	for li, line := range lines {
+		lineNo := li + 1
+
+		for wi := range line.Words {
+			word := &line.Words[wi]
+			wordNo := wi + 1
+
+			if word.ZeroDur {
+				finding := LintFinding{
+					Rule: "zero-duration-word", Severity: lintSeverityWarning,
+					Line: lineNo, Word: wordNo, Time: word.StartTime,
+					Message: fmt.Sprintf("'%s' 的持续时间为 0，已按 1ms 处理", word.Text),
+				}
+				if fix {
+					end := line.EndTime
+					if wi+1 < len(line.Words) {
+						end = line.Words[wi+1].StartTime
+					}
+					if end-word.StartTime > word.Duration {
+						word.Duration = end - word.StartTime
+						finding.Fixed = true
+					}
+				}
+				add(finding)
+			}
+
+			if wi > 0 {
+				prev := &line.Words[wi-1]
+				prevEnd := prev.StartTime + prev.Duration
+				if word.StartTime < prevEnd {
+					finding := LintFinding{
+						Rule: "word-overlap", Severity: lintSeverityWarning,
+						Line: lineNo, Word: wordNo, Time: word.StartTime,
+						Message: fmt.Sprintf("'%s' 与前一个词重叠 %dms", word.Text, prevEnd-word.StartTime),
+					}
+					if fix && word.StartTime > prev.StartTime {
+						prev.Duration = word.StartTime - prev.StartTime
+						finding.Fixed = true
+					}
+					add(finding)
+				}
+			}
+
+			wordEnd := word.StartTime + word.Duration
+			if word.StartTime < line.StartTime || wordEnd > line.EndTime {
+				add(LintFinding{
+					Rule: "word-out-of-line", Severity: lintSeverityWarning,
+					Line: lineNo, Word: wordNo, Time: word.StartTime,
+					Message: fmt.Sprintf("'%s' [%d,%d] 超出所在行的时间范围 [%d,%d]", word.Text, word.StartTime, wordEnd, line.StartTime, line.EndTime),
+					Fixed:   fix,
+				})
+			}
+		}
+
+		if fix {
+			// 扩展行的时间范围以覆盖所有词
+			for _, word := range line.Words {
+				if word.StartTime < line.StartTime {
+					line.StartTime = word.StartTime
+				}
+				if end := word.StartTime + word.Duration; end > line.EndTime {
+					line.EndTime = end
+				}
+			}
+		}
+
+		if li == 0 {
+			continue
+		}
+		prevLine := lines[li-1]
+		if line.StartTime < prevLine.StartTime {
+			add(LintFinding{
+				Rule: "non-monotonic-line", Severity: lintSeverityError,
+				Line: lineNo, Time: line.StartTime,
+				Message: fmt.Sprintf("行开始时间 %dms 早于上一行 %dms", line.StartTime, prevLine.StartTime),
+				Fixed:   fix,
+			})
+			continue
+		}
+
+		prevEnd := lineContentEndTime(prevLine)
+		if line.StartTime < prevEnd {
+			add(LintFinding{
+				Rule: "line-overlap", Severity: lintSeverityInfo,
+				Line: lineNo, Time: line.StartTime,
+				Message: fmt.Sprintf("与上一行重叠 %dms", prevEnd-line.StartTime),
+			})
+		} else if gap := line.StartTime - prevEnd; gap > config.Lyric.LintMaxGapMs {
+			add(LintFinding{
+				Rule: "large-gap", Severity: lintSeverityInfo,
+				Line: lineNo, Time: prevEnd,
+				Message: fmt.Sprintf("与上一行间隔 %dms", gap),
+			})
+		}
+	}
+
+	if fix {
+		sort.SliceStable(parsed.Lines, func(i, j int) bool {
+			return parsed.Lines[i].StartTime < parsed.Lines[j].StartTime
+		})
+	}
+
+	return report
+}
+
+func groupLinesIntoDivs(lines []*LineInfo, maxGap int) []DivInfo {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var divs []DivInfo
+	currentDiv := DivInfo{
+		StartTime: lines[0].StartTime,
+		Lines:     []*LineInfo{lines[0]},
+	}
+
+	for i := 1; i < len(lines); i++ {
+		prevLine := lines[i-1]
+		var prevContentEndTime int
+		if len(prevLine.Words) > 0 {
+			lastWord := prevLine.Words[len(prevLine.Words)-1]
+			prevContentEndTime = lastWord.StartTime + lastWord.Duration
+		} else {
+			prevContentEndTime = prevLine.EndTime
+		}
+
+		gap := lines[i].StartTime - prevContentEndTime
+
+		if gap > maxGap {
+			lastLineInCurrentDiv := currentDiv.Lines[len(currentDiv.Lines)-1]
+			if len(lastLineInCurrentDiv.Words) > 0 {
+				lastWord := lastLineInCurrentDiv.Words[len(lastLineInCurrentDiv.Words)-1]
+				currentDiv.EndTime = lastWord.StartTime + lastWord.Duration
+			} else {
+				currentDiv.EndTime = lastLineInCurrentDiv.EndTime
+			}
+			divs = append(divs, currentDiv)
+
+			currentDiv = DivInfo{
+				StartTime: lines[i].StartTime,
+				Lines:     []*LineInfo{lines[i]},
+			}
+		} else {
+			currentDiv.Lines = append(currentDiv.Lines, lines[i])
+		}
+	}
+
+	if len(currentDiv.Lines) > 0 {
+		lastLineInCurrentDiv := currentDiv.Lines[len(currentDiv.Lines)-1]
+		if len(lastLineInCurrentDiv.Words) > 0 {
+			lastWord := lastLineInCurrentDiv.Words[len(lastLineInCurrentDiv.Words)-1]
+			currentDiv.EndTime = lastWord.StartTime + lastWord.Duration
+		} else {
+			currentDiv.EndTime = lastLineInCurrentDiv.EndTime
+		}
+		divs = append(divs, currentDiv)
+	}
+
+	return divs
+}
+
+func calculateSongDuration(lines []*LineInfo) int {
+	if len(lines) == 0 {
+		return 0
+	}
+
+	maxEndTime := 0
+	for _, line := range lines {
+		if len(line.Words) > 0 {
+			lastWord := line.Words[len(line.Words)-1]
+			lineContentEndTime := lastWord.StartTime + lastWord.Duration
+			if lineContentEndTime > maxEndTime {
+				maxEndTime = lineContentEndTime
+			}
+		} else {
+			if line.EndTime > maxEndTime {
+				maxEndTime = line.EndTime
+			}
+		}
+	}
+	return maxEndTime + 1000
+}
+
+func matchRomajiLine(mainLineTime int, romajiLines []*LineInfo) *LineInfo {
+	for _, romaLine := range romajiLines {
+		timeDiff := abs(romaLine.StartTime - mainLineTime)
+		if timeDiff <= config.Lyric.RomajiWindowMs {
+			return romaLine
+		}
+	}
+	return nil
+}
+
+func convertYrcToTtml(parsed *ParsedLyric) (string, error) {
+	sb := getTTMLBuilder()
+	defer putTTMLBuilder(sb)
+
+	translations := parsed.Translations
+	parsedLines := parsed.Lines
+	parsedRomaji := parsed.Romaji
+
+	if len(parsedLines) == 0 {
+		return "", fmt.Errorf("未找到有效的YRC歌词行")
+	}
+
+	sb.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	sb.WriteString("<tt xmlns=\"http://www.w3.org/ns/ttml\" xmlns:ttm=\"http://www.w3.org/ns/ttml#metadata\" xmlns:tts=\"http://www.w3.org/ns/ttml#styling\" xmlns:itunes=\"http://music.apple.com/lyric-ttml-internal\" itunes:timing=\"Word\">\n")
+	sb.WriteString("    <head>\n        <metadata>\n")
+	sb.WriteString("            <ttm:agent type=\"person\" xml:id=\"v1\"/>\n")
+	sb.WriteString("        </metadata>\n    </head>\n")
+
+	songDuration := calculateSongDuration(parsedLines)
+	songDurationStr := msToTtmlTime(songDuration)
+
+	divs := groupLinesIntoDivs(parsedLines, config.Lyric.DivGapMs)
+
+	sb.WriteString(fmt.Sprintf("    <body dur=\"%s\">\n", songDurationStr))
+
+	lineCounter := 1
+	nextInterlude := 0
+	for divIdx, div := range divs {
+		for nextInterlude < len(parsed.Interludes) && parsed.Interludes[nextInterlude].StartTime < div.StartTime {
+			writeTtmlInterlude(sb, parsed.Interludes[nextInterlude])
+			nextInterlude++
+		}
+
+		divBegin := msToTtmlTime(div.StartTime)
+		divEnd := msToTtmlTime(div.EndTime)
+
+		if part := div.Lines[0].SongPart; part != "" {
+			sb.WriteString(fmt.Sprintf("        <div begin=\"%s\" end=\"%s\" itunes:song-part=\"%s\">\n", divBegin, divEnd, part))
+		} else {
+			sb.WriteString(fmt.Sprintf("        <div begin=\"%s\" end=\"%s\">\n", divBegin, divEnd))
+		}
+
+		for _, line := range div.Lines {
+			lineBegin := msToTtmlTime(line.StartTime)
+
+			var pTagEndTime int
+			if len(line.Words) > 0 {
+				lastWord := line.Words[len(line.Words)-1]
+				pTagEndTime = lastWord.StartTime + lastWord.Duration
+			} else {
+				pTagEndTime = line.EndTime
+			}
+			pTagEndTimeStr := msToTtmlTime(pTagEndTime)
+
+			sb.WriteString(fmt.Sprintf("            <p begin=\"%s\" end=\"%s\" ttm:agent=\"v1\" itunes:key=\"L%d\">\n", lineBegin, pTagEndTimeStr, lineCounter))
+
+			for _, run := range lineRubyRuns(line) {
+				if run.Reading == "" {
+					word := run.Words[0]
+					sb.WriteString(fmt.Sprintf("                <span begin=\"%s\" end=\"%s\">%s</span>\n", msToTtmlTime(word.StartTime), msToTtmlTime(word.StartTime+word.Duration), ttmlWordContent(word)))
+					continue
+				}
+				// 跨词注音：ruby 容器的 base 中保留每个词各自的时间
+				sb.WriteString("                <span tts:ruby=\"container\"><span tts:ruby=\"base\">")
+				for _, word := range run.Words {
+					sb.WriteString(fmt.Sprintf("<span begin=\"%s\" end=\"%s\">%s</span>", msToTtmlTime(word.StartTime), msToTtmlTime(word.StartTime+word.Duration), html.EscapeString(word.Text)))
+				}
+				sb.WriteString(fmt.Sprintf("</span><span tts:ruby=\"text\">%s</span></span>\n", html.EscapeString(run.Reading)))
+			}
+
+			transText := findClosestLine(line.StartTime, translations)
+			if transText != "" {
+				sb.WriteString(fmt.Sprintf("                <span ttm:role=\"x-translation\" xml:lang=\"zh-CN\">%s</span>\n", html.EscapeString(transText)))
+			}
+
+			romaLine := matchRomajiLine(line.StartTime, parsedRomaji)
+			if romaLine != nil {
+				var romaBuilder strings.Builder
+				hasContent := false
+				for _, word := range romaLine.Words {
+					trimmed := strings.TrimSpace(word.Text)
+					if trimmed != "" {
+						hasContent = true
+						romaBuilder.WriteString(word.Text)
+					}
+				}
+				if hasContent {
+					romaText := strings.TrimSpace(romaBuilder.String())
+					if romaText != "" {
+						sb.WriteString(fmt.Sprintf("                <span ttm:role=\"x-roman\">%s</span>\n", html.EscapeString(romaText)))
+					}
+				}
+			}
+
+			sb.WriteString("            </p>\n")
+			lineCounter++
+		}
+
+		sb.WriteString("        </div>\n")
+		if divIdx < len(divs)-1 {
+			sb.WriteString("\n")
+		}
+	}
+
+	for ; nextInterlude < len(parsed.Interludes); nextInterlude++ {
+		writeTtmlInterlude(sb, parsed.Interludes[nextInterlude])
+	}
+
+	sb.WriteString("    </body>\n</tt>\n")
+	return sb.String(), nil
+}
+
+// writeTtmlInterlude 以空 <p> 标记间奏，位于独立的 div 中
+func writeTtmlInterlude(sb *strings.Builder, interlude InterludeInfo) {
+	begin := msToTtmlTime(interlude.StartTime)
+	end := msToTtmlTime(interlude.EndTime)
+	sb.WriteString(fmt.Sprintf("        <div begin=\"%s\" end=\"%s\">\n", begin, end))
+	sb.WriteString(fmt.Sprintf("            <p begin=\"%s\" end=\"%s\" ttm:role=\"x-interlude\"></p>\n", begin, end))
+	sb.WriteString("        </div>\n\n")
+}
+
+// lrcMetaOrder 常见 LRC 标签的输出顺序，其余标签按名称排序放在后面。
+// 输出必须稳定，否则相同歌词的 ETag 会变化
+var lrcMetaOrder = []string{"ti", "ar", "al", "by", "offset"}
+
+func sortedMetaKeys(meta map[string]string) []string {
+	rank := func(key string) int {
+		for i, k := range lrcMetaOrder {
+			if k == key {
+				return i
+			}
+		}
+		return len(lrcMetaOrder)
+	}
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank(keys[i]), rank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func convertYrcToEnhancedLrc(parsed *ParsedLyric) (string, error) {
+	var result strings.Builder
+
+	for _, key := range sortedMetaKeys(parsed.Meta) {
+		if key != "kana" {
+			result.WriteString(fmt.Sprintf("[%s:%s]\n", key, parsed.Meta[key]))
+		}
+	}
+
+	translations := parsed.Translations
+	hasTranslation := len(translations) > 0
+
+	nextInterlude := 0
+	for _, lineInfo := range parsed.Lines {
+		for nextInterlude < len(parsed.Interludes) && parsed.Interludes[nextInterlude].StartTime < lineInfo.StartTime {
+			result.WriteString(enhancedLrcInterlude(parsed.Interludes[nextInterlude]))
+			nextInterlude++
+		}
+
+		mainTimestamp := msToLrcTime(lineInfo.StartTime)
+		result.WriteString(mainTimestamp)
+
+		for _, word := range lineInfo.Words {
+			wordTimestamp := msToEnhancedLrcTime(word.StartTime)
+			result.WriteString(wordTimestamp)
+			result.WriteString(word.Text)
+		}
+
+		if len(lineInfo.Words) > 0 {
+			lastWord := lineInfo.Words[len(lineInfo.Words)-1]
+			finalTimestamp := msToEnhancedLrcTime(lastWord.StartTime + lastWord.Duration)
+			result.WriteString(finalTimestamp)
+		}
+
+		result.WriteString("\n")
+
+		if hasTranslation {
+			translation := findClosestLine(lineInfo.StartTime, translations)
+			if translation != "" {
+				result.WriteString(fmt.Sprintf("%s%s\n", mainTimestamp, translation))
+			}
+		}
+	}
+	for ; nextInterlude < len(parsed.Interludes); nextInterlude++ {
+		result.WriteString(enhancedLrcInterlude(parsed.Interludes[nextInterlude]))
+	}
+
+	return result.String(), nil
+}
+
+// ttmlWordContent 输出词文本，带注音时使用 TTML2 的 tts:ruby 容器
+func ttmlWordContent(word WordInfo) string {
+	if len(word.Ruby) == 0 {
+		return html.EscapeString(word.Text)
+	}
+
+	var sb strings.Builder
+	for _, seg := range rubySegments(word) {
+		if seg.Reading == "" {
+			sb.WriteString(html.EscapeString(seg.Text))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("<span tts:ruby=\"container\"><span tts:ruby=\"base\">%s</span><span tts:ruby=\"text\">%s</span></span>", html.EscapeString(seg.Text), html.EscapeString(seg.Reading)))
+	}
+	return sb.String()
+}
+
+// writeHtmlWord 输出一个带时间的词，词内注音使用 <ruby>
+func writeHtmlWord(sb *strings.Builder, word WordInfo) {
+	sb.WriteString(fmt.Sprintf("<span data-begin=\"%d\" data-end=\"%d\">", word.StartTime, word.StartTime+word.Duration))
+	for _, seg := range rubySegments(word) {
+		if seg.Reading == "" {
+			sb.WriteString(html.EscapeString(seg.Text))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("<ruby>%s<rt>%s</rt></ruby>", html.EscapeString(seg.Text), html.EscapeString(seg.Reading)))
+	}
+	sb.WriteString("</span>")
+}
+
+// convertLinesToHtml 生成带 <ruby> 注音的网页片段，时间信息放在 data-* 属性中
+func convertLinesToHtml(parsed *ParsedLyric) string {
+	var sb strings.Builder
+	sb.WriteString("<div class=\"lyrics\">\n")
+	nextInterlude := 0
+	writeInterlude := func(interlude InterludeInfo) {
+		sb.WriteString(fmt.Sprintf("  <p class=\"interlude\" data-begin=\"%d\" data-end=\"%d\">%s</p>\n", interlude.StartTime, interlude.EndTime, interludeLrcText))
+	}
+	for _, line := range parsed.Lines {
+		for nextInterlude < len(parsed.Interludes) && parsed.Interludes[nextInterlude].StartTime < line.StartTime {
+			writeInterlude(parsed.Interludes[nextInterlude])
+			nextInterlude++
+		}
+		sb.WriteString(fmt.Sprintf("  <p data-begin=\"%d\" data-end=\"%d\">", line.StartTime, lineContentEndTime(line)))
+		for _, run := range lineRubyRuns(line) {
+			if run.Reading == "" {
+				writeHtmlWord(&sb, run.Words[0])
+				continue
+			}
+			// 跨词注音：<ruby> 中保留每个词各自的时间
+			sb.WriteString("<ruby>")
+			for _, word := range run.Words {
+				writeHtmlWord(&sb, word)
+			}
+			sb.WriteString(fmt.Sprintf("<rt>%s</rt></ruby>", html.EscapeString(run.Reading)))
+		}
+		sb.WriteString("</p>\n")
+	}
+	for ; nextInterlude < len(parsed.Interludes); nextInterlude++ {
+		writeInterlude(parsed.Interludes[nextInterlude])
+	}
+	sb.WriteString("</div>\n")
+	return sb.String()
+}
+
+// convertLinesToJSON 生成结构化逐字歌词，注音记录在开始的词上
+func convertLinesToJSON(parsed *ParsedLyric) []LyricLine {
+	result := make([]LyricLine, 0, len(parsed.Lines)+len(parsed.Interludes))
+	nextInterlude := 0
+	appendInterlude := func(interlude InterludeInfo) {
+		result = append(result, LyricLine{
+			Start:     interlude.StartTime,
+			End:       interlude.EndTime,
+			Words:     []LyricWord{},
+			Interlude: true,
+		})
+	}
+	for _, line := range parsed.Lines {
+		for nextInterlude < len(parsed.Interludes) && parsed.Interludes[nextInterlude].StartTime < line.StartTime {
+			appendInterlude(parsed.Interludes[nextInterlude])
+			nextInterlude++
+		}
+
+		var text strings.Builder
+		words := make([]LyricWord, 0, len(line.Words))
+		for wi, word := range line.Words {
+			text.WriteString(word.Text)
+			lw := LyricWord{
+				Text:  word.Text,
+				Start: word.StartTime,
+				End:   word.StartTime + word.Duration,
+			}
+			for _, ruby := range word.Ruby {
+				base, ok := rubyBase(line, wi, ruby)
+				if !ok {
+					continue
+				}
+				lw.Ruby = append(lw.Ruby, LyricRuby{
+					Base:    base,
+					Reading: ruby.Reading,
+					Offset:  ruby.Start,
+					Words:   ruby.Words,
+				})
+			}
+			words = append(words, lw)
+		}
+		result = append(result, LyricLine{
+			Start: line.StartTime,
+			End:   lineContentEndTime(line),
+			Text:  text.String(),
+			Words: words,
+			Part:  line.SongPart,
+		})
+	}
+	for ; nextInterlude < len(parsed.Interludes); nextInterlude++ {
+		appendInterlude(parsed.Interludes[nextInterlude])
+	}
+	return result
+}
+
+// lineContentEndTime 返回行内最后一个字的结束时间
+func lineContentEndTime(line *LineInfo) int {
+	if len(line.Words) == 0 {
+		return line.EndTime
+	}
+	lastWord := line.Words[len(line.Words)-1]
+	return lastWord.StartTime + lastWord.Duration
+}
+
+func findClosestLine(time int, lines []MetaLine) string {
+	bestIndex := -1
+	minDiff := config.Lyric.TranslationWindowMs
+
+	for i, line := range lines {
+		timeDiff := abs(line.Time - time)
+		if timeDiff < minDiff {
+			minDiff = timeDiff
+			bestIndex = i
+		}
+	}
+
+	if bestIndex != -1 {
+		return lines[bestIndex].Content
+	}
+	return ""
+}
+
+// parseLrcTime 将 LRC 时间戳的分、秒、百分秒/毫秒部分转换为毫秒
+func parseLrcTime(minStr, secStr, msStr string) int {
+	minutes, _ := strconv.Atoi(minStr)
+	seconds, _ := strconv.Atoi(secStr)
+	milliseconds, _ := strconv.Atoi(msStr)
+	if len(msStr) == 2 {
+		milliseconds *= 10
+	}
+	return minutes*60*1000 + seconds*1000 + milliseconds
+}
+
+func msToLrcTime(ms int) string {
+	seconds := ms / 1000
+	milliseconds := (ms % 1000) / 10
+	minutes := seconds / 60
+	seconds = seconds % 60
+	return fmt.Sprintf("[%02d:%02d.%02d]", minutes, seconds, milliseconds)
+}
+
+func msToEnhancedLrcTime(ms int) string {
+	seconds := ms / 1000
+	milliseconds := (ms % 1000) / 10
+	minutes := seconds / 60
+	seconds = seconds % 60
+	return fmt.Sprintf("<%02d:%02d.%02d>", minutes, seconds, milliseconds)
+}
+
+func msToTtmlTime(ms int) string {
+	hours := ms / 3600000
+	ms %= 3600000
+	minutes := ms / 60000
+	ms %= 60000
+	seconds := ms / 1000
+	milliseconds := ms % 1000
+
+	if hours > 0 {
+		return fmt.Sprintf("%02d:%02d:%02d.%03d", hours, minutes, seconds, milliseconds)
+	}
+	return fmt.Sprintf("%02d:%02d.%03d", minutes, seconds, milliseconds)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func isMetadataLine(line string) bool {
+	return strings.HasPrefix(line, "[ti:") ||
+		strings.HasPrefix(line, "[ar:") ||
+		strings.HasPrefix(line, "[al:") ||
+		strings.HasPrefix(line, "[by:") ||
+		strings.HasPrefix(line, "[offset:") ||
+		strings.HasPrefix(line, "[kana:") ||
+		strings.HasPrefix(line, "[re:") ||
+		strings.HasPrefix(line, "[ve:")
+}
+
+// --- API 客户端函数 ---
+
+// --- 上游重试与熔断 ---
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// circuitOpenError 熔断期间拒绝上游请求时返回的错误
+type circuitOpenError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("上游 %s 已熔断，%s 后重试", e.Host, e.RetryAfter.Round(time.Second))
+}
+
+// circuitBreaker 连续失败 failure_threshold 次后熔断 open_duration，
+// 之后进入半开状态只放行一个探测请求，成功则恢复，失败则再次熔断
+type circuitBreaker struct {
+	mu        sync.Mutex
+	host      string
+	state     string
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// CircuitStatus 上游主机的熔断状态
+type CircuitStatus struct {
+	Host      string `json:"host"`
+	State     string `json:"state"` // closed | open | half_open
+	Failures  int    `json:"failures"`
+	OpenUntil string `json:"open_until,omitempty"`
+}
+
+var (
+	circuitBreakersMu sync.Mutex
+	circuitBreakers   = make(map[string]*circuitBreaker)
+)
+
+func upstreamBreaker(host string) *circuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+	b := circuitBreakers[host]
+	if b == nil {
+		b = &circuitBreaker{host: host, state: circuitClosed}
+		circuitBreakers[host] = b
+	}
+	return b
+}
+
+// allow 判断是否可以发出请求，熔断期间返回 circuitOpenError
+func (b *circuitBreaker) allow(now time.Time) error {
+	if config.Upstream.Breaker.FailureThreshold == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen && !now.Before(b.openUntil) {
+		b.state, b.probing = circuitHalfOpen, false
+	}
+	switch b.state {
+	case circuitOpen:
+		return &circuitOpenError{Host: b.host, RetryAfter: b.openUntil.Sub(now)}
+	case circuitHalfOpen:
+		if b.probing {
+			return &circuitOpenError{Host: b.host, RetryAfter: time.Second}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// abandon 放弃一次已放行但没有结果的请求 (调用方取消)，半开状态下允许下一个探测请求
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitHalfOpen {
+		b.probing = false
+	}
+}
+
+// record 记录一次请求的结果
+func (b *circuitBreaker) record(ctx context.Context, failed bool, now time.Time) {
+	if config.Upstream.Breaker.FailureThreshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		if b.state != circuitClosed {
+			logInfo(ctx, "上游熔断恢复", "host", b.host)
+		}
+		b.state, b.failures, b.probing = circuitClosed, 0, false
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= config.Upstream.Breaker.FailureThreshold {
+		b.state, b.probing = circuitOpen, false
+		b.openUntil = now.Add(config.Upstream.Breaker.OpenDuration.Duration())
+		logWarn(ctx, "上游熔断", "host", b.host, "failures", b.failures, "open_until", b.openUntil)
+	}
+}
+
+func circuitSnapshot() []CircuitStatus {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	statuses := make([]CircuitStatus, 0, len(circuitBreakers))
+	for _, b := range circuitBreakers {
+		b.mu.Lock()
+		status := CircuitStatus{Host: b.host, State: b.state, Failures: b.failures}
+		if b.state == circuitOpen {
+			status.OpenUntil = b.openUntil.UTC().Format(time.RFC3339)
+		}
+		b.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
+
+// retryBackoff 第 attempt 次失败后的等待时间：在 [0, min(initial*2^(attempt-1), max)] 内随机 (full jitter)
+func retryBackoff(attempt int) time.Duration {
+	retry := config.Upstream.Retry
+	backoff := retry.InitialBackoff.Duration() << uint(attempt-1)
+	if backoff <= 0 || backoff > retry.MaxBackoff.Duration() {
+		backoff = retry.MaxBackoff.Duration()
+	}
+	return time.Duration(mathrand.Int63n(int64(backoff) + 1))
+}
+
+// retryAfterDelay 解析上游响应的 Retry-After (秒数或 HTTP 日期)
+func retryAfterDelay(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// cancelOnClose 在响应体关闭时释放单次尝试的 context
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// retryableResponse 判断请求结果是否值得重试：连接错误、超时、429 和 5xx。
+// 调用方取消或 deadline 到期时不重试
+func retryableResponse(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// upstreamGet 请求上游接口，失败时按 upstream.retry 重试，并按主机熔断。
+// 每次尝试分别记录耗时和结果
+func upstreamGet(ctx context.Context, endpoint, requestURL string) (resp *http.Response, err error) {
+	ctx, span := startSpan(ctx, "upstream "+endpoint, spanKindClient)
+	span.setAttr("http.request.method", http.MethodGet)
+	span.setAttr("url.full", requestURL)
+	span.setAttr("provider", "tencent")
+	defer func() {
+		if resp != nil {
+			span.setAttr("http.response.status_code", resp.StatusCode)
+		}
+		span.finish(err)
+	}()
+
+	if err = chargeUpstream(ctx); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("traceparent", span.traceparent())
+
+	// 所有重试共享同一个截止时间，取配置的预算和请求 context 的 deadline 中较早者
+	deadline := time.Now().Add(config.Upstream.Retry.Budget.Duration())
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	breaker := upstreamBreaker(req.URL.Host)
+	client := http.Client{Timeout: config.Upstream.Timeout.Duration()}
+	for attempt := 1; ; attempt++ {
+		span.setAttr("http.request.resend_count", attempt-1)
+		if err = breaker.allow(time.Now()); err != nil {
+			upstreamRequests.inc(endpoint, "circuit_open")
+			logWarn(ctx, "上游请求被熔断拒绝", "provider", "tencent", "endpoint", endpoint, "error", err)
+			return nil, err
+		}
+
+		// 每次尝试都不能超过整体截止时间，响应体关闭时释放
+		attemptCtx, cancel := context.WithDeadline(ctx, deadline)
+		resp, err = upstreamAttempt(attemptCtx, &client, req.Clone(attemptCtx), endpoint)
+		if resp != nil {
+			resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		} else {
+			cancel()
+		}
+
+		// 调用方取消时结果不代表上游的健康状况，不计入熔断
+		if ctx.Err() != nil {
+			breaker.abandon()
+		} else {
+			breaker.record(ctx, err != nil || resp.StatusCode >= 500, time.Now())
+		}
+
+		if attempt >= config.Upstream.Retry.MaxAttempts || !retryableResponse(ctx, resp, err) {
+			return resp, err
+		}
+		wait := retryBackoff(attempt)
+		if d, ok := retryAfterDelay(resp, time.Now()); ok && d > wait {
+			wait = d
+		}
+		if !time.Now().Add(wait).Before(deadline) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		upstreamRetries.inc(endpoint)
+		logInfo(ctx, "重试上游请求", "provider", "tencent", "endpoint", endpoint, "attempt", attempt+1, "wait_ms", wait.Milliseconds())
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// upstreamAttempt 发出一次上游请求，按 endpoint 记录耗时和结果
+func upstreamAttempt(ctx context.Context, client *http.Client, req *http.Request, endpoint string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := client.Do(req)
+	elapsed := time.Since(start)
+	upstreamDuration.observe(elapsed.Seconds(), endpoint)
+
+	result := "ok"
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	case resp.StatusCode != http.StatusOK:
+		result = "http_" + strconv.Itoa(resp.StatusCode)
+	}
+	upstreamRequests.inc(endpoint, result)
+	upstreamHealth.record(endpoint, start, elapsed, result, err)
+
+	args := []interface{}{"provider", "tencent", "endpoint", endpoint, "result", result, "duration_ms", elapsed.Milliseconds()}
+	if err != nil {
+		logError(ctx, "上游请求失败", append(args, "error", err)...)
+	} else {
+		logDebug(ctx, "上游请求完成", args...)
+	}
+	return resp, err
+}
+
+// searchSongs 搜索歌曲，page 从 1 开始
+func searchSongs(ctx context.Context, word string, page, num int) (songs []SearchSongItemSimplified, err error) {
+	ctx, span := startSpan(ctx, "searchSongs", spanKindInternal)
+	span.setAttr("keyword", word)
+	span.setAttr("page", page)
+	span.setAttr("num", num)
+	defer func() {
+		span.setAttr("results", len(songs))
+		span.finish(err)
+	}()
+
+	searchURL := fmt.Sprintf("%s?word=%s&page=%d&num=%d", config.Upstream.BaseURL, url.QueryEscape(word), page, num)
+	logInfo(ctx, "搜索歌曲", "keyword", word, "page", page, "num", num)
+
+	resp, err := upstreamGet(ctx, "search", searchURL)
+	if err != nil {
+		return nil, fmt.Errorf("搜索请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取搜索响应失败: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("搜索API返回状态: %s", resp.Status)
+	}
+
+	var rawResult struct {
+		Code    int                 `json:"code"`
+		Message string              `json:"message"`
+		Data    []SearchSongItemRaw `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &rawResult); err != nil {
+		return nil, fmt.Errorf("解析搜索结果失败: %w", err)
+	}
+
+	if rawResult.Code != 200 {
+		return nil, fmt.Errorf("搜索API返回错误: %s", rawResult.Message)
+	}
+
+	simplifiedSongs := make([]SearchSongItemSimplified, 0, len(rawResult.Data))
+	for i, item := range rawResult.Data {
+		simplifiedSongs = append(simplifiedSongs, SearchSongItemSimplified{
+			N:      i + 1,
+			Song:   item.Song,
+			Singer: item.Singer,
+			Album:  item.Album,
+			ID:     item.ID,
+			MID:    item.MID,
+
+			Duration:    int(item.Interval),
+			Cover:       item.Cover,
+			ReleaseDate: item.Time,
+			VIP:         item.Pay.ptr(),
+		})
+	}
+
+	return simplifiedSongs, nil
+}
+
+// filterSongs 按歌手、专辑过滤搜索结果 (忽略大小写、空白和标点的包含匹配)，并重新编号
+func filterSongs(songs []SearchSongItemSimplified, singer, album string) []SearchSongItemSimplified {
+	normSinger := string(normalizeLineText(toHalfWidth(singer)))
+	normAlbum := string(normalizeLineText(toHalfWidth(album)))
+	if normSinger == "" && normAlbum == "" {
+		return songs
+	}
+
+	filtered := make([]SearchSongItemSimplified, 0, len(songs))
+	for _, song := range songs {
+		if normSinger != "" && !strings.Contains(string(normalizeLineText(toHalfWidth(song.Singer))), normSinger) {
+			continue
+		}
+		if normAlbum != "" && !strings.Contains(string(normalizeLineText(toHalfWidth(song.Album))), normAlbum) {
+			continue
+		}
+		song.N = len(filtered) + 1
+		filtered = append(filtered, song)
+	}
+	return filtered
+}
+
+// probeWordLyrics 并发获取每首歌的歌词，标记是否有逐字歌词
+func probeWordLyrics(ctx context.Context, songs []SearchSongItemSimplified) {
+	forEachConcurrent(len(songs), config.Search.ProbeConcurrency, func(i int) {
+		data, _, err := fetchLyricData(ctx, "", songs[i].MID)
+		if err != nil {
+			logError(ctx, "探测逐字歌词失败", "mid", songs[i].MID, "error", err)
+			return
+		}
+		hasWord := data.Code == 200 && strings.TrimSpace(data.Data.Yrc) != ""
+		songs[i].HasWordLyrics = &hasWord
+	})
+}
+
+func fetchLyricData(ctx context.Context, id, mid string) (data *LyricData, body []byte, err error) {
+	ctx, span := startSpan(ctx, "fetchLyricData", spanKindInternal)
+	span.setAttr("id", id)
+	span.setAttr("mid", mid)
+	defer func() { span.finish(err) }()
+
+	var requestURL string
+	if id != "" {
+		requestURL = fmt.Sprintf("%s/lyric?id=%s", config.Upstream.BaseURL, id)
+	} else if mid != "" {
+		requestURL = fmt.Sprintf("%s/lyric?mid=%s", config.Upstream.BaseURL, mid)
+	} else {
+		return nil, nil, fmt.Errorf("ID 和 MID 均为空")
+	}
+
+	resp, err := upstreamGet(ctx, "lyric", requestURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("上游歌词API请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("上游歌词API返回状态: %s", resp.Status)
+	}
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取歌词响应体失败: %w", err)
+	}
+
+	var lyricData LyricData
+	if err := json.Unmarshal(body, &lyricData); err != nil {
+		return nil, nil, fmt.Errorf("解析上游歌词JSON失败: %w", err)
+	}
+
+	return &lyricData, body, nil
+}
+
+// --- HTTP 处理函数 ---
+
+// --- 多语言消息 ---
+
+const (
+	langZhCN    = "zh-CN"
+	langEn      = "en"
+	defaultLang = langZhCN
+)
+
+// messageCatalog 响应消息的翻译，键为中文原文；缺少翻译时返回原文
+var messageCatalog = map[string]map[string]string{
+	langEn: {
+		"请求成功": "OK",
+		"请求成功，请通过 n 参数选择歌曲获取歌词": "OK, select a song with the n parameter to get its lyrics",
+		"缺少参数":       "Missing parameter",
+		"参数错误":       "Invalid parameter",
+		"请求方法不支持":    "Method not allowed",
+		"请求体格式错误":    "Malformed request body",
+		"批量请求过大":     "Batch request too large",
+		"搜索歌曲失败":     "Song search failed",
+		"获取歌词失败":     "Failed to fetch lyrics",
+		"获取上游数据失败":   "Failed to fetch upstream data",
+		"上游返回错误":     "Upstream returned an error",
+		"未找到匹配的歌曲":   "No matching song found",
+		"未找到可信的匹配结果": "No confident match found",
+		"歌曲索引超出范围":   "Song index out of range",
+		"未找到歌词":      "Lyrics not found",
+		"不支持流式响应":    "Streaming is not supported",
+		"管理接口未启用":    "Admin API is disabled",
+		"未授权":        "Unauthorized",
+		"请求过于频繁":     "Too many requests",
+		"配额已用完":      "Quota exceeded",
+		"服务配置无效":     "Service configuration is invalid",
+	},
+}
+
+// requestLang 按 lang 参数或 Accept-Language 选择响应语言，不支持的语言使用中文
+func requestLang(r *http.Request) string {
+	if lang := matchLang(r.URL.Query().Get("lang")); lang != "" {
+		return lang
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			// 不合法的权重按 0 处理，即不接受该语言
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || !(parsed >= 0 && parsed <= 1) {
+				parsed = 0
+			}
+			q = parsed
+		}
+		if lang := matchLang(tag); lang != "" && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return defaultLang
+}
+
+// matchLang 将语言标签映射到支持的语言，如 en-US -> en、zh-Hans -> zh-CN
+func matchLang(tag string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	switch primary {
+	case "zh":
+		return langZhCN
+	case "en":
+		return langEn
+	}
+	return ""
+}
+
+// localize 返回消息在 lang 下的翻译
+func localize(lang, message string) string {
+	if translated, ok := messageCatalog[lang][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// renderJSON 辅助函数：设置 Content-Type 并禁用 HTML 转义
+func renderJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
+
+// --- HTTP 缓存 ---
+
+// header 生成 Cache-Control。启用 API Key 时响应只允许私有缓存，避免 CDN 把结果返回给未认证的请求
+func (p CachePolicy) header() string {
+	if p.MaxAge == 0 && p.SMaxAge == 0 && p.StaleWhileRevalidate == 0 {
+		return "no-cache"
+	}
+	private := len(config.Auth.Keys) > 0
+	parts := []string{"public"}
+	if private {
+		parts[0] = "private"
+	}
+	parts = append(parts, fmt.Sprintf("max-age=%d", int(p.MaxAge.Duration().Seconds())))
+	if p.SMaxAge > 0 && !private {
+		parts = append(parts, fmt.Sprintf("s-maxage=%d", int(p.SMaxAge.Duration().Seconds())))
+	}
+	if p.StaleWhileRevalidate > 0 {
+		parts = append(parts, fmt.Sprintf("stale-while-revalidate=%d", int(p.StaleWhileRevalidate.Duration().Seconds())))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// etagMatches 判断 If-None-Match 是否包含 etag (弱比较)
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = stripETagEncoding(strings.TrimPrefix(strings.TrimSpace(candidate), "W/"))
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified 按 If-None-Match 判断客户端缓存是否仍然有效。
+// 上游不提供歌词的修改时间，因此不输出 Last-Modified，也不处理 If-Modified-Since
+func notModified(r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	return false
+}
+
+// writeCacheable 输出可缓存的 200 响应，附带内容哈希 ETag 和 Cache-Control，
+// 条件请求命中时返回 304
+func writeCacheable(w http.ResponseWriter, r *http.Request, policy CachePolicy, contentType string, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", policy.header())
+
+	conditional := r.Header.Get("If-None-Match") != ""
+	if notModified(r, etag) {
+		cacheRequests.inc("http", "hit")
+		// 304 没有响应体，ETag 需与同一请求的 200 响应 (可能经过压缩) 一致
+		if cw, ok := w.(*compressWriter); ok && len(body) >= config.Compression.MinSize {
+			w.Header().Set("ETag", encodedETag(etag, cw.encoding))
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if conditional {
+		cacheRequests.inc("http", "miss")
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// renderCachedJSON 与 renderJSON 输出相同的内容，并按 policy 设置缓存头
+func renderCachedJSON(w http.ResponseWriter, r *http.Request, policy CachePolicy, v interface{}) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		renderJSON(w, http.StatusOK, v)
+		return
+	}
+	writeCacheable(w, r, policy, "application/json; charset=utf-8", buf.Bytes())
+}
+
+// --- 响应压缩 ---
+
+// encoderFactory 按压缩级别创建编码器
+type encoderFactory func(w io.Writer, level int) (io.WriteCloser, error)
+
+// responseEncoders 已注册的 Content-Encoding，level 使用 compress/flate 的取值 (-2~9)
+var responseEncoders = map[string]encoderFactory{
+	"zstd": func(w io.Writer, level int) (io.WriteCloser, error) {
+		zl := zstd.SpeedDefault
+		if level > 0 {
+			zl = zstd.EncoderLevelFromZstd(level)
+		}
+		// 单个响应体积不大，不需要并发编码；窗口限制在 8 MB 以内，浏览器才能解码
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zl), zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+	},
+	"br": func(w io.Writer, level int) (io.WriteCloser, error) {
+		switch {
+		case level == flate.DefaultCompression:
+			level = brotli.DefaultCompression
+		case level < 0:
+			level = brotli.BestSpeed
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	},
+	"gzip": func(w io.Writer, level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	},
+	"deflate": func(w io.Writer, level int) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	},
+}
+
+// negotiateEncoding 按 Accept-Encoding 的 q 值选择编码，q 值相同时按 compression.encodings 的顺序
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsed, err := strconv.ParseFloat(params[2:], 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range config.Compression.Encodings {
+		q, ok := accepted[name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// stripETagEncoding 去掉压缩时附加在 ETag 上的编码后缀，用于比较 If-None-Match
+func stripETagEncoding(etag string) string {
+	for name := range responseEncoders {
+		if suffix := "-" + name + `"`; strings.HasSuffix(etag, suffix) {
+			return strings.TrimSuffix(etag, suffix) + `"`
+		}
+	}
+	return etag
+}
+
+// encodedETag 为压缩后的响应附加编码后缀，不同编码的响应体不同，ETag 也需要区分
+func encodedETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// compressWriter 缓冲响应开头的 min_size 字节后决定是否压缩。
+// 已设置 Content-Encoding、SSE 以及没有响应体的状态码不压缩
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	enc      io.WriteCloser
+}
+
+// newCompressWriter 按请求协商编码，无法压缩时返回原始的 ResponseWriter
+func newCompressWriter(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if !config.Compression.Enabled || len(config.Compression.Encodings) == 0 {
+		return w, func() {}
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" || r.Method == http.MethodHead {
+		return w, func() {}
+	}
+	cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+	return cw, cw.close
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= config.Compression.MinSize {
+			cw.start(true)
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// start 写出响应头和已缓冲的内容，之后的写入直接经过编码器
+func (cw *compressWriter) start(compress bool) {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" || strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		compress = false
+	}
+	if compress {
+		enc, err := responseEncoders[cw.encoding](cw.ResponseWriter, config.Compression.Level)
+		if err == nil {
+			cw.enc = enc
+			header.Set("Content-Encoding", cw.encoding)
+			header.Del("Content-Length")
+			if etag := header.Get("ETag"); etag != "" {
+				header.Set("ETag", encodedETag(etag, cw.encoding))
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) > 0 {
+		if cw.enc != nil {
+			cw.enc.Write(cw.buf)
+		} else {
+			cw.ResponseWriter.Write(cw.buf)
+		}
+		cw.buf = nil
+	}
+}
+
+// Flush 流式响应在达到 min_size 之前刷新时按未压缩输出
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.start(false)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 找到底层连接 (WebSocket 接管连接时不经过压缩)
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	if cw.status == 0 {
+		return
+	}
+	if !cw.decided {
+		cw.start(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+	}
+}
+
+func writeErrorJSON(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string, details string) {
+	writeLyricError(w, r, &lyricError{Status: status, Code: code, Message: message, Details: details})
+}
+
+// writeLyricError 输出 lyricError，上游错误会附带原始响应
+func writeLyricError(w http.ResponseWriter, r *http.Request, lerr *lyricError) {
+	if lerr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lerr.RetryAfter.Seconds()))))
+	}
+	renderJSON(w, lerr.Status, lerr.response(requestLang(r)))
+	logWarn(r.Context(), "返回错误响应", "status", lerr.Status, "error_code", lerr.Code, "message", lerr.Message, "details", lerr.Details)
+}
+
+// responseOptions 歌词响应的可选输出项，来自查询参数
+type responseOptions struct {
+	HTML               bool
+	Lines              bool
+	Lint               string // "" | "1" | "fix"
+	InterludeThreshold int
+	Lang               string // 响应消息的语言
+}
+
+func parseResponseOptions(r *http.Request) responseOptions {
+	query := r.URL.Query()
+	opts := responseOptions{
+		Lang:               requestLang(r),
+		HTML:               query.Get("html") == "1" || query.Get("html") == "true",
+		Lines:              query.Get("lines") == "1" || query.Get("lines") == "true",
+		InterludeThreshold: parseInterludeThreshold(query.Get("interlude")),
+	}
+	switch query.Get("lint") {
+	case "1", "true":
+		opts.Lint = "1"
+	case "fix":
+		opts.Lint = "fix"
+	}
+	return opts
+}
+
+// buildLyricResponse 构建统一的歌词响应
+func buildLyricResponse(ctx context.Context, song, singer, album string, data *LyricData, opts responseOptions) UnifiedLyricResponse {
+	resp := UnifiedLyricResponse{
+		Code:    200,
+		Message: localize(opts.Lang, "请求成功"),
+	}
+	resp.Data.Song = song
+	resp.Data.Singer = singer
+	resp.Data.Album = album
+
+	_, parseSpan := startSpan(ctx, "parseLyricData", spanKindInternal)
+	parsed := parseLyricData(ctx, data)
+	parseSpan.setAttr("lines", len(parsed.Lines))
+	parseSpan.setAttr("estimated", parsed.Estimated)
+	parseSpan.finish(nil)
+	if opts.Lint != "" {
+		report := lintLyric(parsed, opts.Lint == "fix")
+		resp.Data.Lint = &report
+	}
+	// 注音在检查之后添加，检查报告只针对上游原始的解析结果
+	if kana := parsed.Meta["kana"]; kana != "" {
+		applyKanaRuby(ctx, parsed.Lines, kana)
+	}
+	if opts.InterludeThreshold > 0 {
+		parsed.Interludes = detectInterludes(parsed.Lines, opts.InterludeThreshold)
+		logDebug(ctx, "检测到间奏", "count", len(parsed.Interludes), "threshold_ms", opts.InterludeThreshold)
+	}
+
+	// 1. 原始 LRC (合并翻译)
+	resp.Data.LRC = insertLrcInterludes(mergeLrcWithTranslation(data.Data.Lrc, data.Data.Trans), parsed.Interludes)
+
+	// 2. 增强型 LRC (ESLRC) 和 TTML，无 YRC 时使用估算的逐字时间
+	if len(parsed.Lines) > 0 {
+		resp.Data.Estimated = parsed.Estimated
+		resp.Data.Sections = labelSongStructure(parsed.Lines)
+		logDebug(ctx, "识别歌曲段落", "count", len(resp.Data.Sections))
+
+		_, span := startSpan(ctx, "convertYrcToTtml", spanKindInternal)
+		ttml, err := convertYrcToTtml(parsed)
+		span.setAttr("bytes", len(ttml))
+		span.finish(err)
+		if err == nil {
+			resp.Data.TTML = ttml
+		} else {
+			logError(ctx, "TTML转换失败", "error", err)
+			conversionFailures.inc("ttml")
+		}
+
+		_, span = startSpan(ctx, "convertYrcToEnhancedLrc", spanKindInternal)
+		eslrc, err := convertYrcToEnhancedLrc(parsed)
+		span.setAttr("bytes", len(eslrc))
+		span.finish(err)
+		if err == nil {
+			resp.Data.ESLRC = eslrc
+		} else {
+			logError(ctx, "增强LRC转换失败", "error", err)
+			conversionFailures.inc("eslrc")
+		}
+
+		// 3. 可选的网页片段和结构化逐字歌词 (含假名注音)
+		if opts.HTML {
+			resp.Data.HTML = convertLinesToHtml(parsed)
+		}
+		if opts.Lines {
+			resp.Data.Lines = convertLinesToJSON(parsed)
+		}
+	}
+
+	return resp
+}
+
+// lyricError 带 HTTP 状态码和错误码的错误，用于在辅助函数和批量接口中传递错误响应
+type lyricError struct {
+	Status     int
+	Code       ErrorCode
+	Message    string
+	Details    string
+	Upstream   json.RawMessage
+	RetryAfter time.Duration // 大于 0 时设置 Retry-After 响应头
+}
+
+func (e *lyricError) Error() string {
+	return fmt.Sprintf("[%d %s] %s: %s", e.Status, e.Code, e.Message, e.Details)
+}
+
+// response 转换为错误响应，message 按 lang 本地化
+func (e *lyricError) response(lang string) *ErrorResponse {
+	return &ErrorResponse{Code: e.Status, ErrorCode: e.Code, Message: localize(lang, e.Message), Details: e.Details, Upstream: e.Upstream}
+}
+
+// upstreamFailure 将上游请求错误分类为限额不足 (429)、熔断 (503)、超时 (504) 或不可用 (502)
+func upstreamFailure(message string, err error) *lyricError {
+	lerr := &lyricError{Status: http.StatusBadGateway, Code: ErrCodeUpstreamUnavailable, Message: message, Details: err.Error()}
+	var netErr net.Error
+	var circuitErr *circuitOpenError
+	var limitErr *rateLimitError
+	if errors.As(err, &limitErr) {
+		lerr.Status = http.StatusTooManyRequests
+		lerr.Code = limitErr.Code
+		lerr.RetryAfter = limitErr.RetryAfter
+	} else if errors.As(err, &circuitErr) {
+		lerr.Status = http.StatusServiceUnavailable
+		lerr.Code = ErrCodeUpstreamCircuitOpen
+		lerr.RetryAfter = circuitErr.RetryAfter
+	} else if errors.As(err, &netErr) && netErr.Timeout() {
+		lerr.Status = http.StatusGatewayTimeout
+		lerr.Code = ErrCodeUpstreamTimeout
+	}
+	return lerr
+}
+
+// lookupLyricByID 按 ID/MID 获取歌词，歌曲信息取自 LRC 元数据
+func lookupLyricByID(ctx context.Context, id, mid string, opts responseOptions) (UnifiedLyricResponse, *lyricError) {
+	data, rawJSON, err := fetchLyricData(ctx, id, mid)
+	if err != nil {
+		return UnifiedLyricResponse{}, upstreamFailure("获取上游数据失败", err)
+	}
+	if data.Code != 200 {
+		return UnifiedLyricResponse{}, &lyricError{Status: http.StatusFailedDependency, Code: ErrCodeUpstreamError, Message: "上游返回错误", Details: data.Message, Upstream: rawJSON}
+	}
+
+	meta := parseLrcMeta(data.Data.Lrc)
+	return buildLyricResponse(ctx, meta["ti"], meta["ar"], meta["al"], data, opts), nil
+}
+
+// lookupLyricByMatch 按元数据匹配歌曲并获取歌词，匹配度低于 minScore 时返回 404
+func lookupLyricByMatch(ctx context.Context, q MatchQuery, minScore float64, opts responseOptions) (UnifiedLyricResponse, *lyricError) {
+	best, ok, err := matchSong(ctx, q)
+	if err != nil {
+		return UnifiedLyricResponse{}, upstreamFailure("搜索歌曲失败", err)
+	}
+	if !ok {
+		return UnifiedLyricResponse{}, &lyricError{Status: http.StatusNotFound, Code: ErrCodeSongNotFound, Message: "未找到匹配的歌曲", Details: fmt.Sprintf("搜索 '%s' 没有结果", q.Title)}
+	}
+	if best.Score < minScore {
+		return UnifiedLyricResponse{}, &lyricError{Status: http.StatusNotFound, Code: ErrCodeLowMatchConfidence, Message: "未找到可信的匹配结果", Details: fmt.Sprintf("最佳候选 %s - %s 的匹配度 %.2f 低于 %.2f", best.Song.Song, best.Song.Singer, best.Score, minScore)}
+	}
+
+	song := best.Song
+	logInfo(ctx, "匹配到歌曲", "song", song.Song, "singer", song.Singer, "mid", song.MID, "score", best.Score)
+
+	data, _, err := fetchLyricData(ctx, "", song.MID)
+	if err != nil {
+		return UnifiedLyricResponse{}, upstreamFailure("获取歌词失败", err)
+	}
+	if data.Code != 200 {
+		return UnifiedLyricResponse{}, &lyricError{Status: http.StatusNotFound, Code: ErrCodeLyricNotFound, Message: "未找到歌词", Details: data.Message}
+	}
+
+	resp := buildLyricResponse(ctx, song.Song, song.Singer, song.Album, data, opts)
+	resp.Data.Match = &MatchResult{
+		Score:    math.Round(best.Score*1000) / 1000,
+		N:        song.N,
+		ID:       song.ID,
+		MID:      song.MID,
+		Duration: song.Duration,
+	}
+	return resp, nil
+}
+
+func lyricHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	id := query.Get("id")
+	mid := query.Get("mid")
+	word := query.Get("word")
+	nStr := query.Get("n")
+
+	ctx := withLogAttrs(r.Context(), "id", id, "mid", mid, "word", word, "n", nStr)
+	r = r.WithContext(ctx)
+	logInfo(ctx, "收到歌词请求")
+
+	opts := parseResponseOptions(r)
+
+	// --- 逻辑分支 1: 按关键字搜索 ---
+	if word != "" {
+		n, _ := strconv.Atoi(nStr)
+		page, _ := strconv.Atoi(query.Get("page"))
+		if page <= 0 {
+			page = 1
+		}
+		num, _ := strconv.Atoi(query.Get("num"))
+		if num <= 0 {
+			num = config.Search.DefaultNum
+		} else if num > config.Search.MaxNum {
+			num = config.Search.MaxNum
+		}
+
+		// Step 1: 搜索歌曲
+		songs, err := searchSongs(ctx, word, page, num)
+		if err != nil {
+			writeLyricError(w, r, upstreamFailure("搜索歌曲失败", err))
+			return
+		}
+		songs = filterSongs(songs, query.Get("singer"), query.Get("album"))
+
+		// Case 1: 仅搜索，不选择 (n=0 或 n 未提供)
+		if n <= 0 {
+			if query.Get("probe") == "1" || query.Get("probe") == "true" {
+				probeWordLyrics(ctx, songs)
+			}
+			logInfo(ctx, "返回精简搜索结果", "results", len(songs))
+			resp := SearchResponse{
+				Code:    200,
+				Message: localize(opts.Lang, "请求成功，请通过 n 参数选择歌曲获取歌词"),
+				Page:    page,
+				Num:     num,
+				Data:    songs,
+			}
+			renderCachedJSON(w, r, config.HTTPCache.Search, resp)
+			return
+		}
+
+		// Case 2: 搜索并选择第 n 首歌
+		if len(songs) < n {
+			writeErrorJSON(w, r, http.StatusNotFound, ErrCodeIndexOutOfRange, "歌曲索引超出范围", fmt.Sprintf("搜索 '%s' 只找到 %d 首歌", word, len(songs)))
+			return
+		}
+
+		song := songs[n-1]
+		logInfo(ctx, "已选择歌曲", "song", song.Song, "singer", song.Singer, "selected_mid", song.MID)
+
+		// Step 2: 获取歌词数据
+		data, _, err := fetchLyricData(ctx, "", song.MID)
+		if err != nil {
+			writeLyricError(w, r, upstreamFailure("获取歌词失败", err))
+			return
+		}
+
+		if data.Code != 200 {
+			writeErrorJSON(w, r, http.StatusNotFound, ErrCodeLyricNotFound, "未找到歌词", data.Message)
+			return
+		}
+
+		// Step 3: 构建并发送响应
+		resp := buildLyricResponse(ctx, song.Song, song.Singer, song.Album, data, opts)
+		renderCachedJSON(w, r, config.HTTPCache.Lyric, resp)
+		return
+	}
+
+	// --- 逻辑分支 2: 按元数据匹配 ---
+	if title := query.Get("title"); title != "" {
+		q := MatchQuery{
+			Title:  title,
+			Artist: query.Get("artist"),
+			Album:  query.Get("album"),
+		}
+		q.Duration, _ = strconv.Atoi(strings.Split(query.Get("duration"), ".")[0])
+		minScore := config.Match.MinScore
+		if v := query.Get("min_score"); v != "" {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil || !validMinScore(parsed) {
+				writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "参数错误", "'min_score' 必须是 0 到 1 之间的数")
+				return
+			}
+			minScore = parsed
+		}
+
+		resp, lerr := lookupLyricByMatch(ctx, q, minScore, opts)
+		if lerr != nil {
+			writeLyricError(w, r, lerr)
+			return
+		}
+		renderCachedJSON(w, r, config.HTTPCache.Lyric, resp)
+		return
+	}
+
+	// --- 逻辑分支 3: 按 ID/MID 获取 ---
+	if id != "" || mid != "" {
+		// 歌曲信息取自 LRC 元数据；上游返回错误时原始响应放在 upstream 字段中
+		resp, lerr := lookupLyricByID(ctx, id, mid, opts)
+		if lerr != nil {
+			writeLyricError(w, r, lerr)
+			return
+		}
+		renderCachedJSON(w, r, config.HTTPCache.Lyric, resp)
+		return
+	}
+
+	// --- 逻辑分支 4: 参数错误 ---
+	writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeMissingParameter, "缺少参数", "请提供 'id', 'mid', 'word' 或 'title' 参数")
+}
+
+// --- 元数据匹配 ---
+
+const (
+	matchWeightTitle    = 0.5
+	matchWeightArtist   = 0.3
+	matchWeightAlbum    = 0.1
+	matchWeightDuration = 0.1
+
+	matchDurationExact = 2  // 时长差在该秒数内视为完全一致
+	matchDurationMax   = 20 // 时长差超过该秒数得分为 0
+)
+
+var (
+	titleBracketSuffixRe = regexp.MustCompile(`(?i)\s*[(\[（【][^)\]）】]*(feat|ft\.|with |remaster|live|version|ver\.|mix|edit|demo|伴奏|现场|live版)[^)\]）】]*[)\]）】]`)
+	titleDashSuffixRe    = regexp.MustCompile(`(?i)\s+-\s+.*(remaster|live|version|mix|edit|demo).*$`)
+	titleFeatRe          = regexp.MustCompile(`(?i)\s+(feat\.?|ft\.)\s+.*$`)
+	artistSplitRe        = regexp.MustCompile(`(?i)\s*(/|、|&|,|，|;|；|\sx\s|\sfeat\.?\s|\sft\.\s)\s*`)
+)
+
+// MatchQuery 元数据匹配的查询条件，Duration 单位为秒
+type MatchQuery struct {
+	Title    string
+	Artist   string
+	Album    string
+	Duration int
+}
+
+type scoredSong struct {
+	Song  SearchSongItemSimplified
+	Score float64
+}
+
+// toHalfWidth 将全角字符转换为半角
+func toHalfWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == 0x3000:
+			return ' '
+		case r >= 0xFF01 && r <= 0xFF5E:
+			return r - 0xFEE0
+		}
+		return r
+	}, s)
+}
+
+// normalizeTitle 去除 feat./remaster/live 等后缀和标点，用于标题比较
+func normalizeTitle(title string) string {
+	title = toHalfWidth(title)
+	title = titleBracketSuffixRe.ReplaceAllString(title, "")
+	title = titleDashSuffixRe.ReplaceAllString(title, "")
+	title = titleFeatRe.ReplaceAllString(title, "")
+	return string(normalizeLineText(title))
+}
+
+// splitArtists 拆分多位歌手
+func splitArtists(artist string) []string {
+	var artists []string
+	for _, part := range artistSplitRe.Split(toHalfWidth(artist), -1) {
+		if norm := string(normalizeLineText(part)); norm != "" {
+			artists = append(artists, norm)
+		}
+	}
+	return artists
+}
+
+// stringSimilarity 基于编辑距离的相似度 (0~1)
+func stringSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j] + 1
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+			if prev[j-1]+cost < curr[j] {
+				curr[j] = prev[j-1] + cost
+			}
+		}
+		prev, curr = curr, prev
+	}
+
+	maxLen := len(ra)
+	if len(rb) > maxLen {
+		maxLen = len(rb)
+	}
+	return 1 - float64(prev[len(rb)])/float64(maxLen)
+}
+
+// artistSimilarity 取两组歌手之间的最高相似度
+func artistSimilarity(a, b string) float64 {
+	best := 0.0
+	for _, x := range splitArtists(a) {
+		for _, y := range splitArtists(b) {
+			if sim := stringSimilarity(x, y); sim > best {
+				best = sim
+			}
+		}
+	}
+	return best
+}
+
+// durationCloseness 时长差在 matchDurationExact 内为 1，超过 matchDurationMax 为 0，中间线性递减
+func durationCloseness(a, b int) float64 {
+	diff := abs(a - b)
+	if diff <= matchDurationExact {
+		return 1
+	}
+	if diff >= matchDurationMax {
+		return 0
+	}
+	return 1 - float64(diff-matchDurationExact)/float64(matchDurationMax-matchDurationExact)
+}
+
+// validMinScore 判断匹配度阈值是否在 [0, 1] 内，与 match.min_score 的校验一致 (NaN 不合法)
+func validMinScore(v float64) bool {
+	return v >= 0 && v <= 1
+}
+
+// scoreSong 按标题、歌手、专辑和时长加权计算匹配度，查询中缺失的条件不参与计算
+func scoreSong(song SearchSongItemSimplified, q MatchQuery) float64 {
+	var score, weight float64
+
+	score += matchWeightTitle * stringSimilarity(normalizeTitle(q.Title), normalizeTitle(song.Song))
+	weight += matchWeightTitle
+
+	if q.Artist != "" {
+		score += matchWeightArtist * artistSimilarity(q.Artist, song.Singer)
+		weight += matchWeightArtist
+	}
+	if q.Album != "" {
+		score += matchWeightAlbum * stringSimilarity(normalizeTitle(q.Album), normalizeTitle(song.Album))
+		weight += matchWeightAlbum
+	}
+	if q.Duration > 0 {
+		closeness := 0.5 // 上游未提供时长时取中间值
+		if song.Duration > 0 {
+			closeness = durationCloseness(q.Duration, song.Duration)
+		}
+		score += matchWeightDuration * closeness
+		weight += matchWeightDuration
+	}
+	return score / weight
+}
+
+// rankSongs 按匹配度从高到低排序搜索结果
+func rankSongs(songs []SearchSongItemSimplified, q MatchQuery) []scoredSong {
+	ranked := make([]scoredSong, 0, len(songs))
+	for _, song := range songs {
+		ranked = append(ranked, scoredSong{Song: song, Score: scoreSong(song, q)})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
+
+// matchSong 搜索并返回最匹配的歌曲；没有结果时 ok 为 false
+func matchSong(ctx context.Context, q MatchQuery) (best scoredSong, ok bool, err error) {
+	keyword := strings.TrimSpace(q.Title + " " + q.Artist)
+	songs, err := searchSongs(ctx, keyword, 1, config.Search.DefaultNum)
+	if err != nil {
+		return scoredSong{}, false, err
+	}
+	ranked := rankSongs(songs, q)
+	if len(ranked) == 0 {
+		return scoredSong{}, false, nil
+	}
+	logDebug(ctx, "匹配最佳候选", "keyword", keyword, "song", ranked[0].Song.Song, "singer", ranked[0].Song.Singer, "score", ranked[0].Score)
+	return ranked[0], true, nil
+}
+
+// --- 批量歌词接口 ---
+
+// BatchRequest 批量获取歌词的请求体
+type BatchRequest struct {
+	Items       []BatchItem `json:"items"`
+	Concurrency int         `json:"concurrency,omitempty"`
+}
+
+// BatchItem 单个歌词请求：提供 id/mid，或提供 title 等元数据进行匹配
+type BatchItem struct {
+	ID       json.Number `json:"id,omitempty"`
+	MID      string      `json:"mid,omitempty"`
+	Title    string      `json:"title,omitempty"`
+	Artist   string      `json:"artist,omitempty"`
+	Album    string      `json:"album,omitempty"`
+	Duration int         `json:"duration,omitempty"` // 秒
+	MinScore float64     `json:"min_score,omitempty"`
+}
+
+// BatchItemResult 单项结果，成功时 Data 有值，失败时 Error 有值
+type BatchItemResult struct {
+	Index int               `json:"index"`
+	Code  int               `json:"code"`
+	Data  *UnifiedLyricData `json:"data,omitempty"`
+	Error *ErrorResponse    `json:"error,omitempty"`
+}
+
+// BatchResponse 非流式批量响应，结果按请求顺序排列
+type BatchResponse struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Data    []BatchItemResult `json:"data"`
+}
+
+func resolveBatchItem(ctx context.Context, index int, item BatchItem, opts responseOptions) BatchItemResult {
+	var resp UnifiedLyricResponse
+	var lerr *lyricError
+
+	ctx = withLogAttrs(ctx, "batch_index", index, "id", item.ID.String(), "mid", item.MID, "title", item.Title)
+	switch {
+	case item.ID != "" || item.MID != "":
+		resp, lerr = lookupLyricByID(ctx, item.ID.String(), item.MID, opts)
+	case item.Title != "":
+		minScore := item.MinScore
+		if minScore == 0 {
+			minScore = config.Match.MinScore
+		}
+		if !validMinScore(minScore) {
+			lerr = &lyricError{Status: http.StatusBadRequest, Code: ErrCodeInvalidParameter, Message: "参数错误", Details: "'min_score' 必须是 0 到 1 之间的数"}
+			break
+		}
+		q := MatchQuery{Title: item.Title, Artist: item.Artist, Album: item.Album, Duration: item.Duration}
+		resp, lerr = lookupLyricByMatch(ctx, q, minScore, opts)
+	default:
+		lerr = &lyricError{Status: http.StatusBadRequest, Code: ErrCodeMissingParameter, Message: "缺少参数", Details: "请提供 'id', 'mid' 或 'title'"}
+	}
+
+	if lerr != nil {
+		logWarn(ctx, "批量请求项失败", "status", lerr.Status, "error_code", lerr.Code, "details", lerr.Details)
+		return BatchItemResult{
+			Index: index,
+			Code:  lerr.Status,
+			Error: lerr.response(opts.Lang),
+		}
+	}
+	return BatchItemResult{Index: index, Code: http.StatusOK, Data: &resp.Data}
+}
+
+// batchHandler 批量获取歌词，stream=1 或 Accept: application/x-ndjson 时按完成顺序逐行输出 NDJSON
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorJSON(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "请求方法不支持", "批量接口仅支持 POST")
+		return
+	}
+
+	var req BatchRequest
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	if err := decoder.Decode(&req); err != nil {
+		writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeInvalidBody, "请求体格式错误", err.Error())
+		return
+	}
+	if len(req.Items) == 0 {
+		writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeMissingParameter, "缺少参数", "items 不能为空")
+		return
+	}
+	if len(req.Items) > config.Batch.MaxItems {
+		writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeBatchTooLarge, "批量请求过大", fmt.Sprintf("items 最多 %d 项", config.Batch.MaxItems))
+		return
+	}
+
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = config.Batch.DefaultConcurrency
+	} else if concurrency > config.Batch.MaxConcurrency {
+		concurrency = config.Batch.MaxConcurrency
+	}
+
+	query := r.URL.Query()
+	opts := parseResponseOptions(r)
+	stream := query.Get("stream") == "1" || query.Get("stream") == "true" ||
+		strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+
+	ctx := r.Context()
+	logInfo(ctx, "收到批量请求", "items", len(req.Items), "concurrency", concurrency, "stream", stream)
+
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+
+		var mu sync.Mutex
+		forEachConcurrent(len(req.Items), concurrency, func(i int) {
+			result := resolveBatchItem(ctx, i, req.Items[i], opts)
+			mu.Lock()
+			defer mu.Unlock()
+			encoder := json.NewEncoder(w)
+			encoder.SetEscapeHTML(false)
+			encoder.Encode(result)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		})
+		return
+	}
+
+	results := make([]BatchItemResult, len(req.Items))
+	forEachConcurrent(len(req.Items), concurrency, func(i int) {
+		results[i] = resolveBatchItem(ctx, i, req.Items[i], opts)
+	})
+
+	renderJSON(w, http.StatusOK, BatchResponse{
+		Code:    200,
+		Message: localize(opts.Lang, "请求成功"),
+		Data:    results,
+	})
+}
+
+// --- 播放时间轴 ---
+
+// lyricTimeline 按开始时间排序的歌词时间轴，支持按播放位置二分查找
+type lyricTimeline struct {
+	Lines        []*LineInfo
+	Translations []MetaLine
+}
+
+func newLyricTimeline(parsed *ParsedLyric) *lyricTimeline {
+	lines := make([]*LineInfo, len(parsed.Lines))
+	copy(lines, parsed.Lines)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].StartTime < lines[j].StartTime
+	})
+	return &lyricTimeline{Lines: lines, Translations: parsed.Translations}
+}
+
+// lastStartedLine 返回开始时间不晚于 pos 的最后一行的下标，没有时为 -1
+func (t *lyricTimeline) lastStartedLine(pos int) int {
+	return sort.Search(len(t.Lines), func(i int) bool {
+		return t.Lines[i].StartTime > pos
+	}) - 1
+}
+
+// lineAt 返回 pos 时正在演唱的行，行间空白时为 -1
+func (t *lyricTimeline) lineAt(pos int) int {
+	idx := t.lastStartedLine(pos)
+	if idx < 0 || pos >= lineContentEndTime(t.Lines[idx]) {
+		return -1
+	}
+	return idx
+}
+
+// wordAt 返回第 lineIdx 行中 pos 时正在演唱的字，没有时为 -1
+func (t *lyricTimeline) wordAt(lineIdx, pos int) int {
+	if lineIdx < 0 {
+		return -1
+	}
+	words := t.Lines[lineIdx].Words
+	idx := sort.Search(len(words), func(i int) bool {
+		return words[i].StartTime > pos
+	}) - 1
+	if idx < 0 || pos >= words[idx].StartTime+words[idx].Duration {
+		return -1
+	}
+	return idx
+}
+
+// nextChange 返回 pos 之后活动行或活动字发生变化的最早时间，之后不再变化时为 -1
+func (t *lyricTimeline) nextChange(pos int) int {
+	next := -1
+	consider := func(ts int) {
+		if ts > pos && (next == -1 || ts < next) {
+			next = ts
+		}
+	}
+
+	idx := t.lastStartedLine(pos)
+	if idx+1 < len(t.Lines) {
+		consider(t.Lines[idx+1].StartTime)
+	}
+	if idx >= 0 {
+		line := t.Lines[idx]
+		consider(lineContentEndTime(line))
+		for _, word := range line.Words {
+			consider(word.StartTime)
+			consider(word.StartTime + word.Duration)
+		}
+	}
+	return next
+}
+
+// TimelineLine 时间轴中的一行
+type TimelineLine struct {
+	Index       int    `json:"index"`
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+	Text        string `json:"text"`
+	Translation string `json:"translation,omitempty"`
+}
+
+// TimelineWord 时间轴中的一个字/词
+type TimelineWord struct {
+	Line  int    `json:"line"`
+	Index int    `json:"index"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+func (t *lyricTimeline) line(idx int) TimelineLine {
+	line := t.Lines[idx]
+	return TimelineLine{
+		Index:       idx,
+		Start:       line.StartTime,
+		End:         lineContentEndTime(line),
+		Text:        lineText(line),
+		Translation: findClosestLine(line.StartTime, t.Translations),
+	}
+}
+
+func (t *lyricTimeline) word(lineIdx, wordIdx int) TimelineWord {
+	word := t.Lines[lineIdx].Words[wordIdx]
+	return TimelineWord{
+		Line:  lineIdx,
+		Index: wordIdx,
+		Start: word.StartTime,
+		End:   word.StartTime + word.Duration,
+		Text:  word.Text,
+	}
+}
+
+// endTime 返回最后一行的结束时间
+func (t *lyricTimeline) endTime() int {
+	end := 0
+	for _, line := range t.Lines {
+		if e := lineContentEndTime(line); e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// --- 时间轴缓存 ---
+
+type timelineCacheEntry struct {
+	timeline  *lyricTimeline
+	expiresAt time.Time
+}
+
+var (
+	timelineCache   = make(map[string]timelineCacheEntry)
+	timelineCacheMu sync.Mutex
+)
+
+// getTimeline 获取歌曲的解析后时间轴，结果在进程内缓存 cache.timeline_ttl
+func getTimeline(ctx context.Context, id, mid string) (*lyricTimeline, *lyricError) {
+	key := "mid:" + mid
+	if id != "" {
+		key = "id:" + id
+	}
+
+	now := time.Now()
+	timelineCacheMu.Lock()
+	entry, ok := timelineCache[key]
+	timelineCacheMu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		logDebug(ctx, "时间轴缓存命中", "key", key)
+		cacheRequests.inc("timeline", "hit")
+		return entry.timeline, nil
+	}
+	cacheRequests.inc("timeline", "miss")
+
+	data, _, err := fetchLyricData(ctx, id, mid)
+	if err != nil {
+		return nil, upstreamFailure("获取上游数据失败", err)
+	}
+	if data.Code != 200 {
+		return nil, &lyricError{Status: http.StatusNotFound, Code: ErrCodeLyricNotFound, Message: "未找到歌词", Details: data.Message}
+	}
+	timeline := newLyricTimeline(parseLyricData(ctx, data))
+	if len(timeline.Lines) == 0 {
+		return nil, &lyricError{Status: http.StatusNotFound, Code: ErrCodeNoWordTiming, Message: "未找到歌词", Details: "歌词没有可用的时间轴"}
+	}
+
+	timelineCacheMu.Lock()
+	defer timelineCacheMu.Unlock()
+	if len(timelineCache) >= config.Cache.TimelineMaxSize {
+		// 先清理过期条目，仍然已满时淘汰最早过期的一条
+		oldestKey := ""
+		for k, e := range timelineCache {
+			if now.After(e.expiresAt) {
+				delete(timelineCache, k)
+			} else if oldestKey == "" || e.expiresAt.Before(timelineCache[oldestKey].expiresAt) {
+				oldestKey = k
+			}
+		}
+		if len(timelineCache) >= config.Cache.TimelineMaxSize {
+			delete(timelineCache, oldestKey)
+		}
+	}
+	timelineCache[key] = timelineCacheEntry{timeline: timeline, expiresAt: now.Add(config.Cache.TimelineTTL.Duration())}
+	return timeline, nil
+}
+
+// --- 按播放位置查询 ---
+
+// PositionData 某一播放位置的歌词状态
+type PositionData struct {
+	Position    int           `json:"position"`
+	Line        *TimelineLine `json:"line"`         // 当前行，行间空白时为 null
+	Word        *TimelineWord `json:"word"`         // 当前字，没有时为 null
+	NextLine    *TimelineLine `json:"next_line"`    // 下一行，已是最后一行时为 null
+	NextChange  *int          `json:"next_change"`  // 下一次行/字变化的时间 (ms)，之后不再变化时为 null
+	UntilChange *int          `json:"until_change"` // 距下一次变化的时长 (ms)
+	Duration    int           `json:"duration"`     // 歌词结束时间 (ms)
+}
+
+// PositionResponse 按播放位置查询的响应
+type PositionResponse struct {
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Data    PositionData `json:"data"`
+}
+
+func lookupPosition(timeline *lyricTimeline, pos int) PositionData {
+	data := PositionData{Position: pos, Duration: timeline.endTime()}
+
+	lineIdx := timeline.lineAt(pos)
+	if lineIdx >= 0 {
+		line := timeline.line(lineIdx)
+		data.Line = &line
+		if wordIdx := timeline.wordAt(lineIdx, pos); wordIdx >= 0 {
+			word := timeline.word(lineIdx, wordIdx)
+			data.Word = &word
+		}
+	}
+
+	if nextIdx := timeline.lastStartedLine(pos) + 1; nextIdx < len(timeline.Lines) {
+		next := timeline.line(nextIdx)
+		data.NextLine = &next
+	}
+
+	if next := timeline.nextChange(pos); next >= 0 {
+		until := next - pos
+		data.NextChange = &next
+		data.UntilChange = &until
+	}
+	return data
+}
+
+// positionHandler 返回播放位置 t (ms) 处的当前行、当前字和下一行
+func positionHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	id := query.Get("id")
+	mid := query.Get("mid")
+	if id == "" && mid == "" {
+		writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeMissingParameter, "缺少参数", "请提供 'id' 或 'mid' 参数")
+		return
+	}
+	pos, err := strconv.Atoi(query.Get("t"))
+	if err != nil || pos < 0 {
+		writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "参数错误", "'t' 必须是非负整数 (毫秒)")
+		return
+	}
+
+	ctx := withLogAttrs(r.Context(), "id", id, "mid", mid)
+	r = r.WithContext(ctx)
+	timeline, lerr := getTimeline(ctx, id, mid)
+	if lerr != nil {
+		writeLyricError(w, r, lerr)
+		return
+	}
+
+	renderJSON(w, http.StatusOK, PositionResponse{
+		Code:    200,
+		Message: localize(requestLang(r), "请求成功"),
+		Data:    lookupPosition(timeline, pos),
+	})
+}
+
+// --- 实时歌词同步 (SSE / WebSocket) ---
+
+// 实时同步有两种传输方式，共用 /api/live：
+//   - SSE 不保存任何会话状态：seek、暂停、变速时客户端断开连接，用新的 pos/at/rate/paused
+//     重新连接即可，因此可以运行在任意实例上。连接在 live.max_duration 后以 end 事件结束，
+//     事件中带有续接所需的 position 和 at；暂停时不会再有变化，推送当前行后立即结束。
+//   - 请求带 Upgrade: websocket 时升级为 WebSocket，在同一连接上接收 seek/pause/resume/rate
+//     控制消息，不受 live.max_duration 限制。需要支持长连接的部署环境，Vercel 函数不支持。
+
+const liveKeepAliveInterval = 15 * time.Second
+
+// playbackClock 根据参考点推算当前播放位置
+type playbackClock struct {
+	basePos  float64 // 参考点的播放位置 (ms)
+	baseTime time.Time
+	rate     float64
+	paused   bool
+}
+
+func (c *playbackClock) position(now time.Time) int {
+	if c.paused {
+		return int(c.basePos)
+	}
+	return int(c.basePos + float64(now.Sub(c.baseTime).Milliseconds())*c.rate)
+}
+
+// timeUntil 返回播放到 pos 还需的真实时间，暂停或无法到达时 ok 为 false
+func (c *playbackClock) timeUntil(pos int, now time.Time) (time.Duration, bool) {
+	if c.paused || c.rate <= 0 {
+		return 0, false
+	}
+	remaining := float64(pos - c.position(now))
+	if remaining < 0 {
+		remaining = 0
+	}
+	return time.Duration(remaining/c.rate*float64(time.Millisecond)) + time.Millisecond, true
+}
+
+// LiveStartEvent 连接建立后的第一个事件
+type LiveStartEvent struct {
+	Position int `json:"position"`
+	Duration int `json:"duration"` // 歌词总时长 (ms)
+	Expires  int `json:"expires"`  // 本次连接的最长时间 (ms)，到期后发送 end 事件；WebSocket 为 0 (不限)
+}
+
+// LiveLineEvent 行切换事件
+type LiveLineEvent struct {
+	Position int `json:"position"`
+	TimelineLine
+}
+
+// LiveWordEvent 字切换事件
+type LiveWordEvent struct {
+	Position int `json:"position"`
+	TimelineWord
+}
+
+// LiveClearEvent 进入行间空白
+type LiveClearEvent struct {
+	Position int `json:"position"`
+}
+
+// LiveEndEvent 播放到结尾或连接结束。SSE 的 reason 为 timeout 时客户端应以 pos=position&at=at
+// 重新连接，为 paused 时在恢复播放后重新连接；WebSocket 只发送 finished，之后仍可 seek
+type LiveEndEvent struct {
+	Position int    `json:"position"`
+	At       int64  `json:"at"`     // 服务端采样 position 时的 Unix 毫秒时间戳
+	Reason   string `json:"reason"` // finished | timeout | paused
+}
+
+// LiveStateEvent 控制消息生效后的播放状态 (仅 WebSocket)
+type LiveStateEvent struct {
+	Position int     `json:"position"`
+	Rate     float64 `json:"rate"`
+	Paused   bool    `json:"paused"`
+}
+
+// LiveControl WebSocket 客户端发送的控制消息
+type LiveControl struct {
+	Type     string  `json:"type"`               // seek | pause | resume | rate
+	Position *int    `json:"position,omitempty"` // 新的播放位置 (ms)，seek 必填；其他类型缺省时使用服务端推算的当前位置
+	At       int64   `json:"at,omitempty"`       // 采样 position 时的 Unix 毫秒时间戳
+	Rate     float64 `json:"rate,omitempty"`     // rate 必填，必须大于 0
+}
+
+// LiveMessage WebSocket 下发的消息，event 与 SSE 的事件名相同，另有 state 和 error (ErrorResponse)
+type LiveMessage struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+func newRandomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// applyClockReference 根据客户端的采样时间戳补偿网络延迟
+func applyClockReference(clock *playbackClock, pos int, at int64, now time.Time) {
+	clock.basePos = float64(pos)
+	clock.baseTime = now
+	if at > 0 && !clock.paused {
+		elapsed := now.UnixMilli() - at
+		if elapsed > 0 && elapsed < int64(time.Minute/time.Millisecond) {
+			clock.basePos += float64(elapsed) * clock.rate
+		}
+	}
+}
+
+// applyLiveControl 把控制消息应用到播放时钟，消息无效时不做任何修改。
+// 消息带 position 时播放位置发生跳变，moved 为 true
+func applyLiveControl(clock *playbackClock, ctl LiveControl, now time.Time) (moved bool, err error) {
+	pos, at := clock.position(now), int64(0)
+	if ctl.Position != nil {
+		if *ctl.Position < 0 {
+			return false, errors.New("'position' 必须是非负整数 (毫秒)")
+		}
+		pos, at, moved = *ctl.Position, ctl.At, true
+	}
+
+	switch ctl.Type {
+	case "seek":
+		if ctl.Position == nil {
+			return false, errors.New("seek 需要 'position'")
+		}
+	case "pause":
+		clock.paused = true
+	case "resume":
+		clock.paused = false
+	case "rate":
+		if !(ctl.Rate > 0) {
+			return false, errors.New("'rate' 必须大于 0")
+		}
+		clock.rate = ctl.Rate
+	default:
+		return false, fmt.Errorf("未知的控制消息类型 '%s'，应为 seek、pause、resume 或 rate", ctl.Type)
+	}
+	applyClockReference(clock, pos, at, now)
+	return moved, nil
+}
+
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, event string, v interface{}) {
+	payload, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	flusher.Flush()
+}
+
+// liveStream 按播放时钟推送行/字的变化，SSE 和 WebSocket 共用
+type liveStream struct {
+	timeline         *lyricTimeline
+	clock            *playbackClock
+	send             func(event string, v interface{}) error
+	curLine, curWord int
+}
+
+func newLiveStream(timeline *lyricTimeline, clock *playbackClock, send func(event string, v interface{}) error) *liveStream {
+	s := &liveStream{timeline: timeline, clock: clock, send: send}
+	s.reset()
+	return s
+}
+
+// reset 使下一次 sync 重新推送当前行和字，用于连接开始和播放位置跳变之后
+func (s *liveStream) reset() {
+	s.curLine, s.curWord = -2, -2
+}
+
+// sync 推送 now 时与上次推送不同的行/字，返回当前播放位置
+func (s *liveStream) sync(now time.Time) (int, error) {
+	pos := s.clock.position(now)
+
+	lineIdx := s.timeline.lineAt(pos)
+	if lineIdx != s.curLine {
+		var err error
+		if lineIdx >= 0 {
+			err = s.send("line", LiveLineEvent{Position: pos, TimelineLine: s.timeline.line(lineIdx)})
+		} else {
+			err = s.send("clear", LiveClearEvent{Position: pos})
+		}
+		if err != nil {
+			return pos, err
+		}
+		s.curLine, s.curWord = lineIdx, -2
+	}
+
+	wordIdx := s.timeline.wordAt(lineIdx, pos)
+	if wordIdx != s.curWord {
+		if wordIdx >= 0 {
+			if err := s.send("word", LiveWordEvent{Position: pos, TimelineWord: s.timeline.word(lineIdx, wordIdx)}); err != nil {
+				return pos, err
+			}
+		}
+		s.curWord = wordIdx
+	}
+	return pos, nil
+}
+
+// wake 返回在下一次行/字变化时触发的定时器，暂停或之后不再变化时为 nil
+func (s *liveStream) wake(pos int, now time.Time) *time.Timer {
+	if next := s.timeline.nextChange(pos); next >= 0 {
+		if wait, ok := s.clock.timeUntil(next, now); ok {
+			return time.NewTimer(wait)
+		}
+	}
+	return nil
+}
+
+// liveHandler 推送当前行/字，参数 pos 为播放位置 (ms)，at 为采样 pos 时的 Unix 毫秒时间戳。
+// 请求带 Upgrade: websocket 时升级为 WebSocket，否则以 SSE 输出
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	id := query.Get("id")
+	mid := query.Get("mid")
+	if id == "" && mid == "" {
+		writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeMissingParameter, "缺少参数", "请提供 'id' 或 'mid' 参数")
+		return
+	}
+
+	websocket := isWebSocketUpgrade(r)
+	flusher, ok := w.(http.Flusher)
+	if !websocket && !ok {
+		writeErrorJSON(w, r, http.StatusInternalServerError, ErrCodeStreamingUnsupported, "不支持流式响应", "当前运行环境不支持 SSE")
+		return
+	}
+
+	ctx := withLogAttrs(r.Context(), "id", id, "mid", mid)
+	r = r.WithContext(ctx)
+	timeline, lerr := getTimeline(ctx, id, mid)
+	if lerr != nil {
+		writeLyricError(w, r, lerr)
+		return
+	}
+
+	clock := &playbackClock{rate: 1}
+	if rate, err := strconv.ParseFloat(query.Get("rate"), 64); err == nil && rate > 0 {
+		clock.rate = rate
+	}
+	clock.paused = query.Get("paused") == "1" || query.Get("paused") == "true"
+	pos, _ := strconv.Atoi(query.Get("pos"))
+	at, _ := strconv.ParseInt(query.Get("at"), 10, 64)
+	applyClockReference(clock, pos, at, time.Now())
+
+	if websocket {
+		liveWebSocket(w, r, timeline, clock)
+		return
+	}
+	liveSSE(w, flusher, r, timeline, clock)
+}
+
+func liveSSE(w http.ResponseWriter, flusher http.Flusher, r *http.Request, timeline *lyricTimeline, clock *playbackClock) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	now := time.Now()
+	maxDuration := config.Live.MaxDuration.Duration()
+	logInfo(ctx, "实时同步开始", "transport", "sse", "position", clock.position(now))
+	writeSSE(w, flusher, "start", LiveStartEvent{
+		Position: clock.position(now),
+		Duration: timeline.endTime(),
+		Expires:  int(maxDuration.Milliseconds()),
+	})
+
+	deadline := time.NewTimer(maxDuration)
+	defer deadline.Stop()
+	keepAlive := time.NewTicker(liveKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	stream := newLiveStream(timeline, clock, func(event string, v interface{}) error {
+		writeSSE(w, flusher, event, v)
+		return nil
+	})
+	for {
+		now = time.Now()
+		pos, _ := stream.sync(now)
+
+		// 暂停后不会再有变化，保持连接只会占用函数执行时间；恢复播放时客户端重新连接
+		if clock.paused {
+			writeSSE(w, flusher, "end", LiveEndEvent{Position: pos, At: now.UnixMilli(), Reason: "paused"})
+			logInfo(ctx, "实时同步结束", "reason", "paused")
+			return
+		}
+		if pos >= timeline.endTime() {
+			writeSSE(w, flusher, "end", LiveEndEvent{Position: pos, At: now.UnixMilli(), Reason: "finished"})
+			logInfo(ctx, "实时同步结束", "reason", "finished")
+			return
+		}
+
+		var wake <-chan time.Time
+		timer := stream.wake(pos, now)
+		if timer != nil {
+			wake = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			logInfo(ctx, "实时同步断开")
+			return
+		case <-deadline.C:
+			now = time.Now()
+			writeSSE(w, flusher, "end", LiveEndEvent{Position: clock.position(now), At: now.UnixMilli(), Reason: "timeout"})
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-wake:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// liveWebSocket 在 WebSocket 上推送行/字，并按客户端的控制消息调整播放时钟。
+// 播放到结尾后连接保持打开，直到客户端关闭
+func liveWebSocket(w http.ResponseWriter, r *http.Request, timeline *lyricTimeline, clock *playbackClock) {
+	ctx := r.Context()
+	conn := upgradeWebSocket(w, r)
+	if conn == nil {
+		return
+	}
+	defer conn.close()
+
+	lang := requestLang(r)
+	send := func(event string, v interface{}) error {
+		return conn.writeJSON(LiveMessage{Event: event, Data: v})
+	}
+	sendError := func(status int, code ErrorCode, message, details string) error {
+		return send("error", (&lyricError{Status: status, Code: code, Message: message, Details: details}).response(lang))
+	}
+
+	now := time.Now()
+	logInfo(ctx, "实时同步开始", "transport", "websocket", "position", clock.position(now))
+	if err := send("start", LiveStartEvent{Position: clock.position(now), Duration: timeline.endTime()}); err != nil {
+		return
+	}
+
+	// 读取控制消息，客户端关闭连接或出错时关闭 controls
+	controls := make(chan LiveControl)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(controls)
+		for {
+			message, err := conn.readMessage()
+			if err != nil {
+				return
+			}
+			var ctl LiveControl
+			if err := json.Unmarshal(message, &ctl); err != nil {
+				sendError(http.StatusBadRequest, ErrCodeInvalidBody, "请求体格式错误", err.Error())
+				continue
+			}
+			select {
+			case controls <- ctl:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(liveKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	stream := newLiveStream(timeline, clock, send)
+	finished := false
+	for {
+		now = time.Now()
+		pos, err := stream.sync(now)
+		if err != nil {
+			logInfo(ctx, "实时同步断开", "error", err)
+			return
+		}
+		atEnd := pos >= timeline.endTime()
+		if atEnd && !finished {
+			if err := send("end", LiveEndEvent{Position: pos, At: now.UnixMilli(), Reason: "finished"}); err != nil {
+				return
+			}
+		}
+		finished = atEnd
+
+		var wake <-chan time.Time
+		timer := stream.wake(pos, now)
+		if timer != nil {
+			wake = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case ctl, ok := <-controls:
+			if !ok {
+				logInfo(ctx, "实时同步断开")
+				return
+			}
+			now = time.Now()
+			moved, err := applyLiveControl(clock, ctl, now)
+			if err != nil {
+				sendError(http.StatusBadRequest, ErrCodeInvalidParameter, "参数错误", err.Error())
+				break
+			}
+			if moved {
+				stream.reset()
+			}
+			logDebug(ctx, "实时同步控制", "type", ctl.Type, "position", clock.position(now), "rate", clock.rate, "paused", clock.paused)
+			send("state", LiveStateEvent{Position: clock.position(now), Rate: clock.rate, Paused: clock.paused})
+		case <-keepAlive.C:
+			if err := conn.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case <-wake:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// --- WebSocket ---
+
+// 只实现实时同步需要的 RFC 6455 子集：文本消息、分片、ping/pong 和关闭握手，不支持扩展和子协议
+
+const (
+	websocketGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketMaxMessageSize = 4096 // 控制消息很短，超过时关闭连接
+	websocketWriteTimeout   = 10 * time.Second
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseUnsupported   = 1003
+	wsCloseTooBig        = 1009
+)
+
+// wsCloseError 需要以 code 关闭连接的协议错误
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket %d: %s", e.code, e.reason)
+}
+
+// wsConn 升级后的 WebSocket 连接。读取只在一个 goroutine 中进行，写入加锁
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	mu        sync.Mutex
+	closeSent bool
+}
+
+// isWebSocketUpgrade 判断请求是否要求升级为 WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, token := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// websocketAccept 计算握手响应的 Sec-WebSocket-Accept
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// upgradeWebSocket 完成握手并接管连接，已设置的响应头 (X-Request-ID、CORS 等) 随 101 响应发出。
+// 失败时已写出错误响应，返回 nil
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) *wsConn {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		writeErrorJSON(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "参数错误", "WebSocket 握手需要 GET、Sec-WebSocket-Version: 13 和 Sec-WebSocket-Key")
+		return nil
+	}
+
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeErrorJSON(w, r, http.StatusInternalServerError, ErrCodeStreamingUnsupported, "不支持流式响应", "当前运行环境不支持 WebSocket: "+err.Error())
+		return nil
+	}
+	// 接管后的连接不再受 http.Server 的超时控制，写入时各自设置期限
+	netConn.SetDeadline(time.Time{})
+
+	header := w.Header().Clone()
+	header.Del("Content-Type")
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", websocketAccept(key))
+	var buf bytes.Buffer
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+
+	netConn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := netConn.Write(buf.Bytes()); err != nil {
+		netConn.Close()
+		logWarn(r.Context(), "WebSocket 握手失败", "error", err)
+		return nil
+	}
+	return &wsConn{conn: netConn, br: rw.Reader}
+}
+
+// writeFrame 写出一个不分片的帧，服务端发出的帧不加掩码
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 2, 10+len(payload))
+	frame[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xFFFF:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	if op == wsOpClose {
+		c.closeSent = true
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) writeJSON(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, payload)
+}
+
+// writeClose 发送关闭帧，每个连接只发送一次
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// close 未发送过关闭帧时先正常关闭，再断开底层连接
+func (c *wsConn) close() {
+	c.writeClose(wsCloseNormal, "")
+	c.conn.Close()
+}
+
+// readFrame 读取一个客户端帧并去掉掩码
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0F
+	if head[0]&0x70 != 0 {
+		return fin, op, nil, &wsCloseError{wsCloseProtocolError, "不支持扩展"}
+	}
+	if head[1]&0x80 == 0 {
+		return fin, op, nil, &wsCloseError{wsCloseProtocolError, "客户端帧必须加掩码"}
+	}
+
+	size := uint64(head[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return fin, op, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return fin, op, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsOpClose && (!fin || size > 125) {
+		return fin, op, nil, &wsCloseError{wsCloseProtocolError, "控制帧不能分片或超过 125 字节"}
+	}
+	if size > websocketMaxMessageSize {
+		return fin, op, nil, &wsCloseError{wsCloseTooBig, "消息过大"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return fin, op, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return fin, op, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// readMessage 读取下一条文本消息，期间回复 ping。客户端关闭连接时回复关闭帧并返回 io.EOF，
+// 协议错误时以相应的状态码关闭连接
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err == nil {
+			switch op {
+			case wsOpPing:
+				err = c.writeFrame(wsOpPong, payload)
+				if err == nil {
+					continue
+				}
+			case wsOpPong:
+				continue
+			case wsOpClose:
+				c.writeClose(wsCloseNormal, "")
+				return nil, io.EOF
+			case wsOpText:
+				if fragmented {
+					err = &wsCloseError{wsCloseProtocolError, "上一条消息尚未结束"}
+				}
+				message = payload
+			case wsOpContinuation:
+				if !fragmented {
+					err = &wsCloseError{wsCloseProtocolError, "没有待续的消息"}
+				} else if len(message)+len(payload) > websocketMaxMessageSize {
+					err = &wsCloseError{wsCloseTooBig, "消息过大"}
+				}
+				message = append(message, payload...)
+			case wsOpBinary:
+				err = &wsCloseError{wsCloseUnsupported, "只接受文本消息"}
+			default:
+				err = &wsCloseError{wsCloseProtocolError, "未知的操作码"}
+			}
+		}
+		if err != nil {
+			var closeErr *wsCloseError
+			if errors.As(err, &closeErr) {
+				c.writeClose(closeErr.code, closeErr.reason)
+			}
+			return nil, err
+		}
+		if fin {
+			return message, nil
+		}
+		fragmented = true
+	}
+}
+
+// --- LRCLIB 兼容接口 ---
+
+const (
+	lrclibDurationTolerance = 2 // LRCLIB 按时长匹配时允许的误差 (秒)
+	lrclibSearchConcurrency = 4
+	lrclibSearchMaxResults  = 5 // /api/search 最多返回的结果数，每条结果需要一次上游歌词请求
+)
+
+// LrclibRecord LRCLIB 协议的歌词记录
+type LrclibRecord struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	TrackName    string  `json:"trackName"`
+	ArtistName   string  `json:"artistName"`
+	AlbumName    string  `json:"albumName"`
+	Duration     float64 `json:"duration"`
+	Instrumental bool    `json:"instrumental"`
+	PlainLyrics  *string `json:"plainLyrics"`
+	SyncedLyrics *string `json:"syncedLyrics"`
+}
+
+// LrclibError LRCLIB 协议的错误响应
+type LrclibError struct {
+	Code    int    `json:"code"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+func writeLrclibError(w http.ResponseWriter, r *http.Request, code int, name, message string) {
+	renderJSON(w, code, LrclibError{Code: code, Name: name, Message: message})
+	logWarn(r.Context(), "返回 LRCLIB 错误响应", "status", code, "name", name, "message", message)
+}
+
+// forEachConcurrent 以最多 limit 个并发执行 fn(0..n-1)
+func forEachConcurrent(n, limit int, fn func(i int)) {
+	if limit <= 0 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// buildLrclibRecord 将歌曲和歌词转换为 LRCLIB 记录，syncedLyrics 只保留原文歌词行
+func buildLrclibRecord(song SearchSongItemSimplified, data *LyricData) LrclibRecord {
+	record := LrclibRecord{
+		ID:         song.ID,
+		Name:       song.Song,
+		TrackName:  song.Song,
+		ArtistName: song.Singer,
+		AlbumName:  song.Album,
+		Duration:   float64(song.Duration),
+	}
+	if data == nil || data.Code != 200 {
+		return record
+	}
+
+	timedLines := parseLrcTimedLines(data.Data.Lrc)
+	if len(timedLines) == 0 {
+		return record
+	}
+	if len(timedLines) == 1 && strings.Contains(timedLines[0].Content, "纯音乐") {
+		record.Instrumental = true
+		return record
+	}
+
+	var synced, plain strings.Builder
+	for i, line := range timedLines {
+		if i > 0 {
+			plain.WriteString("\n")
+		}
+		synced.WriteString(msToLrcTime(line.Time) + " " + line.Content + "\n")
+		plain.WriteString(line.Content)
+	}
+	syncedText := synced.String()
+	plainText := plain.String()
+	record.SyncedLyrics = &syncedText
+	record.PlainLyrics = &plainText
+	return record
+}
+
+// pickLrclibCandidate 选择匹配度最高且时长在 LRCLIB 容差内的歌曲
+func pickLrclibCandidate(songs []SearchSongItemSimplified, q MatchQuery) (SearchSongItemSimplified, bool) {
+	for _, candidate := range rankSongs(songs, q) {
+		if candidate.Score < config.Match.MinScore {
+			break
+		}
+		if q.Duration > 0 && candidate.Song.Duration > 0 && abs(candidate.Song.Duration-q.Duration) > lrclibDurationTolerance {
+			continue
+		}
+		return candidate.Song, true
+	}
+	return SearchSongItemSimplified{}, false
+}
+
+// lrclibGetHandler 实现 LRCLIB 的 GET /api/get
+func lrclibGetHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	track := query.Get("track_name")
+	artist := query.Get("artist_name")
+	album := query.Get("album_name")
+	duration, _ := strconv.Atoi(strings.Split(query.Get("duration"), ".")[0])
+
+	ctx := withLogAttrs(r.Context(), "track", track, "artist", artist, "album", album, "duration", duration)
+	r = r.WithContext(ctx)
+	logInfo(ctx, "收到 LRCLIB 请求")
+
+	if track == "" || artist == "" {
+		writeLrclibError(w, r, http.StatusBadRequest, "MissingParameter", "track_name and artist_name are required")
+		return
+	}
+
+	songs, err := searchSongs(ctx, track+" "+artist, 1, config.Search.DefaultNum)
+	if err != nil {
+		writeLrclibError(w, r, http.StatusBadGateway, "UpstreamError", err.Error())
+		return
+	}
+
+	song, ok := pickLrclibCandidate(songs, MatchQuery{Title: track, Artist: artist, Album: album, Duration: duration})
+	if !ok {
+		writeLrclibError(w, r, http.StatusNotFound, "TrackNotFound", "Failed to find specified track")
+		return
+	}
+
+	data, _, err := fetchLyricData(ctx, "", song.MID)
+	if err != nil {
+		writeLrclibError(w, r, http.StatusBadGateway, "UpstreamError", err.Error())
+		return
+	}
+	if data.Code != 200 {
+		writeLrclibError(w, r, http.StatusNotFound, "TrackNotFound", "Failed to find specified track")
+		return
+	}
+
+	record := buildLrclibRecord(song, data)
+	if record.Duration == 0 {
+		record.Duration = float64(duration)
+	}
+	renderCachedJSON(w, r, config.HTTPCache.Lyric, record)
+	logInfo(ctx, "LRCLIB 匹配完成", "song", song.Song, "singer", song.Singer, "mid", song.MID)
+}
+
+// lrclibSearchResults 选出 /api/search 返回的歌曲：指定了 track_name 时按匹配度排序，
+// 否则保持上游顺序，最多 lrclibSearchMaxResults 条
+func lrclibSearchResults(songs []SearchSongItemSimplified, q MatchQuery) []SearchSongItemSimplified {
+	if q.Title != "" {
+		ranked := rankSongs(songs, q)
+		songs = make([]SearchSongItemSimplified, len(ranked))
+		for i, candidate := range ranked {
+			songs[i] = candidate.Song
+		}
+	}
+	if len(songs) > lrclibSearchMaxResults {
+		songs = songs[:lrclibSearchMaxResults]
+	}
+	return songs
+}
+
+// lrclibSearchHandler 实现 LRCLIB 的 GET /api/search，并发获取前 lrclibSearchMaxResults 条结果的歌词
+func lrclibSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	keyword := query.Get("q")
+	match := MatchQuery{Title: query.Get("track_name"), Artist: query.Get("artist_name"), Album: query.Get("album_name")}
+	if keyword == "" {
+		keyword = strings.TrimSpace(match.Title + " " + match.Artist + " " + match.Album)
+	}
+
+	ctx := withLogAttrs(r.Context(), "word", keyword)
+	r = r.WithContext(ctx)
+	logInfo(ctx, "收到 LRCLIB 搜索请求")
+
+	if keyword == "" {
+		writeLrclibError(w, r, http.StatusBadRequest, "MissingParameter", "q or track_name is required")
+		return
+	}
+
+	songs, err := searchSongs(ctx, keyword, 1, config.Search.DefaultNum)
+	if err != nil {
+		writeLrclibError(w, r, http.StatusBadGateway, "UpstreamError", err.Error())
+		return
+	}
+	songs = lrclibSearchResults(songs, match)
+
+	records := make([]LrclibRecord, len(songs))
+	errs := make([]error, len(songs))
+	forEachConcurrent(len(songs), lrclibSearchConcurrency, func(i int) {
+		data, _, err := fetchLyricData(ctx, "", songs[i].MID)
+		if err != nil {
+			logError(ctx, "LRCLIB 搜索获取歌词失败", "mid", songs[i].MID, "error", err)
+			errs[i] = err
+		}
+		records[i] = buildLrclibRecord(songs[i], data)
+	})
+
+	// 限额不足时不返回 (且不缓存) 缺少歌词的结果
+	for _, err := range errs {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+			writeLrclibError(w, r, http.StatusTooManyRequests, "TooManyRequests", err.Error())
+			return
+		}
+	}
+
+	renderCachedJSON(w, r, config.HTTPCache.Search, records)
+}
+
+// --- OpenSubsonic 兼容接口 ---
+
+const (
+	subsonicAPIVersion = "1.16.1"
+	subsonicXmlns      = "http://subsonic.org/restapi"
+	subsonicServerType = "lyric-api"
+
+	subsonicErrGeneric  = 0
+	subsonicErrMissing  = 10
+	subsonicErrNotFound = 70
+)
+
+// SubsonicResponse OpenSubsonic 的响应外层结构，JSON 时包在 "subsonic-response" 中
+type SubsonicResponse struct {
+	XMLName      xml.Name            `xml:"subsonic-response" json:"-"`
+	Xmlns        string              `xml:"xmlns,attr" json:"-"`
+	Status       string              `xml:"status,attr" json:"status"`
+	Version      string              `xml:"version,attr" json:"version"`
+	Type         string              `xml:"type,attr" json:"type"`
+	OpenSubsonic bool                `xml:"openSubsonic,attr" json:"openSubsonic"`
+	LyricsList   *SubsonicLyricsList `xml:"lyricsList,omitempty" json:"lyricsList,omitempty"`
+	Extensions   []SubsonicExtension `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+	Error        *SubsonicError      `xml:"error,omitempty" json:"error,omitempty"`
+}
+
+// SubsonicLyricsList getLyricsBySongId 的结果，每种语言一条 structuredLyrics
+type SubsonicLyricsList struct {
+	StructuredLyrics []SubsonicStructuredLyrics `xml:"structuredLyrics" json:"structuredLyrics"`
+}
+
+// SubsonicStructuredLyrics 一种语言的结构化歌词
+type SubsonicStructuredLyrics struct {
+	DisplayArtist string              `xml:"displayArtist,attr,omitempty" json:"displayArtist,omitempty"`
+	DisplayTitle  string              `xml:"displayTitle,attr,omitempty" json:"displayTitle,omitempty"`
+	Lang          string              `xml:"lang,attr" json:"lang"`
+	Offset        int                 `xml:"offset,attr" json:"offset"`
+	Synced        bool                `xml:"synced,attr" json:"synced"`
+	Line          []SubsonicLyricLine `xml:"line" json:"line"`
+}
+
+// SubsonicLyricLine 一行歌词，Start 为毫秒
+type SubsonicLyricLine struct {
+	Start int    `xml:"start,attr" json:"start"`
+	Value string `xml:",chardata" json:"value"`
+}
+
+// SubsonicExtension OpenSubsonic 扩展声明
+type SubsonicExtension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions" json:"versions"`
+}
+
+// SubsonicError OpenSubsonic 错误信息
+type SubsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+func newSubsonicResponse() SubsonicResponse {
+	return SubsonicResponse{
+		Xmlns:        subsonicXmlns,
+		Status:       "ok",
+		Version:      subsonicAPIVersion,
+		Type:         subsonicServerType,
+		OpenSubsonic: true,
+	}
+}
+
+// renderSubsonic 按 f 参数输出 JSON 或 XML (默认 XML)；Subsonic 协议的错误也使用 HTTP 200，
+// 因此只有成功的响应带缓存头
+func renderSubsonic(w http.ResponseWriter, r *http.Request, resp SubsonicResponse) {
+	if r.URL.Query().Get("f") == "json" {
+		body := map[string]SubsonicResponse{"subsonic-response": resp}
+		if resp.Status == "ok" {
+			renderCachedJSON(w, r, config.HTTPCache.Lyric, body)
+		} else {
+			renderJSON(w, http.StatusOK, body)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	xml.NewEncoder(&buf).Encode(resp)
+	if resp.Status == "ok" {
+		writeCacheable(w, r, config.HTTPCache.Lyric, "application/xml; charset=utf-8", buf.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func writeSubsonicError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	resp := newSubsonicResponse()
+	resp.Status = "failed"
+	resp.Error = &SubsonicError{Code: code, Message: message}
+	renderSubsonic(w, r, resp)
+	logWarn(r.Context(), "返回 Subsonic 错误响应", "subsonic_code", code, "message", message)
+}
+
+// detectLyricLanguage 根据文字推断 ISO 639-2 语言代码，无法判断时返回 "und"
+func detectLyricLanguage(lines []*LineInfo) string {
+	var han, kana, hangul int
+	for _, line := range lines {
+		for _, r := range lineText(line) {
+			switch {
+			case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+				kana++
+			case unicode.Is(unicode.Hangul, r):
+				hangul++
+			case unicode.Is(unicode.Han, r):
+				han++
+			}
+		}
+	}
+
+	switch {
+	case kana > 0:
+		return "jpn"
+	case hangul > 0:
+		return "kor"
+	case han > 0:
+		return "zho"
+	}
+	return "und"
+}
+
+// buildSubsonicLyrics 将解析后的歌词按语言拆分为原文、翻译、罗马音三条 structuredLyrics
+func buildSubsonicLyrics(parsed *ParsedLyric) []SubsonicStructuredLyrics {
+	base := SubsonicStructuredLyrics{
+		DisplayArtist: parsed.Meta["ar"],
+		DisplayTitle:  parsed.Meta["ti"],
+		Synced:        true,
+	}
+	base.Offset, _ = strconv.Atoi(parsed.Meta["offset"])
+
+	var result []SubsonicStructuredLyrics
+	if len(parsed.Lines) > 0 {
+		original := base
+		original.Lang = detectLyricLanguage(parsed.Lines)
+		for _, line := range parsed.Lines {
+			original.Line = append(original.Line, SubsonicLyricLine{Start: line.StartTime, Value: lineText(line)})
+		}
+		result = append(result, original)
+	}
+
+	if len(parsed.Translations) > 0 {
+		translation := base
+		translation.Lang = "zho"
+		for _, line := range parsed.Translations {
+			translation.Line = append(translation.Line, SubsonicLyricLine{Start: line.Time, Value: line.Content})
+		}
+		result = append(result, translation)
+	}
+
+	if len(parsed.Romaji) > 0 {
+		romaji := base
+		romaji.Lang = "jpn-Latn"
+		for _, line := range parsed.Romaji {
+			if text := strings.TrimSpace(lineText(line)); text != "" {
+				romaji.Line = append(romaji.Line, SubsonicLyricLine{Start: line.StartTime, Value: text})
+			}
+		}
+		if len(romaji.Line) > 0 {
+			result = append(result, romaji)
+		}
+	}
+	return result
+}
+
+// subsonicLyricsHandler 实现 OpenSubsonic 的 getLyricsBySongId，id 可以是歌曲 ID 或 MID
+func subsonicLyricsHandler(w http.ResponseWriter, r *http.Request) {
+	songID := r.URL.Query().Get("id")
+	ctx := withLogAttrs(r.Context(), "id", songID)
+	r = r.WithContext(ctx)
+	logInfo(ctx, "收到 Subsonic 歌词请求")
+
+	if songID == "" {
+		writeSubsonicError(w, r, subsonicErrMissing, "Required parameter is missing: id")
+		return
+	}
+
+	var data *LyricData
+	var err error
+	if _, convErr := strconv.Atoi(songID); convErr == nil {
+		data, _, err = fetchLyricData(ctx, songID, "")
+	} else {
+		data, _, err = fetchLyricData(ctx, "", songID)
+	}
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	if data.Code != 200 {
+		writeSubsonicError(w, r, subsonicErrNotFound, "Lyrics not found")
+		return
+	}
+
+	resp := newSubsonicResponse()
+	resp.LyricsList = &SubsonicLyricsList{
+		StructuredLyrics: buildSubsonicLyrics(parseLyricData(ctx, data)),
+	}
+	if resp.LyricsList.StructuredLyrics == nil {
+		resp.LyricsList.StructuredLyrics = []SubsonicStructuredLyrics{}
+	}
+	renderSubsonic(w, r, resp)
+}
+
+// subsonicExtensionsHandler 声明支持的 OpenSubsonic 扩展
+func subsonicExtensionsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := newSubsonicResponse()
+	resp.Extensions = []SubsonicExtension{{Name: "songLyrics", Versions: []int{1}}}
+	renderSubsonic(w, r, resp)
+}
+
+// --- 监控指标 ---
+
+// 默认的耗时分桶 (秒)
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricCounter 带标签的计数器
+type metricCounter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newCounter(name, help string, labels ...string) *metricCounter {
+	c := &metricCounter{name: name, help: help, labels: labels, values: make(map[string]float64), keys: make(map[string][]string)}
+	registerMetric(c)
+	return c
+}
+
+func (c *metricCounter) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *metricCounter) add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keys[key]; !ok {
+		c.keys[key] = labelValues
+	}
+	c.values[key] += v
+}
+
+func (c *metricCounter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedMetricKeys(c.keys) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatMetricLabels(c.labels, c.keys[key], "", ""), formatMetricValue(c.values[key]))
+	}
+}
+
+// metricHistogram 带标签的直方图
+type metricHistogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+	keys   map[string][]string
+}
+
+type histogramSeries struct {
+	counts []uint64 // 与 buckets 一一对应，非累计
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricHistogram {
+	h := &metricHistogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries), keys: make(map[string][]string)}
+	registerMetric(h)
+	return h
+}
+
+func (h *metricHistogram) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+		h.keys[key] = labelValues
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *metricHistogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedMetricKeys(h.keys) {
+		s, values := h.series[key], h.keys[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatMetricLabels(h.labels, values, "le", formatMetricValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatMetricLabels(h.labels, values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatMetricLabels(h.labels, values, "", ""), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatMetricLabels(h.labels, values, "", ""), s.count)
+	}
+}
+
+type metricWriter interface {
+	write(w io.Writer)
+}
+
+var metricRegistry []metricWriter
+
+func registerMetric(m metricWriter) {
+	metricRegistry = append(metricRegistry, m)
+}
+
+func sortedMetricKeys(keys map[string][]string) []string {
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// formatMetricLabels 生成 {a="x",b="y"}，extraName 非空时追加一个标签 (直方图的 le)
+func formatMetricLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	httpRequests = newCounter("lyric_api_requests_total",
+		"按路由和状态码统计的请求数", "route", "status")
+	httpDuration = newHistogram("lyric_api_request_duration_seconds",
+		"按路由统计的请求耗时", defaultLatencyBuckets, "route")
+	upstreamRequests = newCounter("lyric_api_upstream_requests_total",
+		"按上游接口和结果 (ok/timeout/error/http_<状态码>) 统计的上游请求数", "endpoint", "result")
+	upstreamDuration = newHistogram("lyric_api_upstream_duration_seconds",
+		"按上游接口统计的请求耗时", defaultLatencyBuckets, "endpoint")
+	conversionFailures = newCounter("lyric_api_conversion_failures_total",
+		"按格式统计的歌词转换失败次数 (yrc 为上游逐字歌词无法解析，ttml/eslrc 为生成失败)", "format")
+	upstreamRetries = newCounter("lyric_api_upstream_retries_total",
+		"按上游接口统计的重试次数", "endpoint")
+	cacheRequests = newCounter("lyric_api_cache_requests_total",
+		"按缓存和结果 (hit/miss) 统计的缓存查询次数", "cache", "result")
+	apiKeyRequests = newCounter("lyric_api_key_requests_total",
+		"按 API Key 名称和结果 (allowed/rate_limited/quota_exceeded/unauthorized) 统计的请求数", "key", "result")
+	traceSpans = newCounter("lyric_api_trace_spans_total",
+		"按结果 (exported/failed/dropped) 统计的追踪 span 数", "result")
+)
+
+// requestRoute 请求的路由名，歌词接口按逻辑分支细分
+func requestRoute(r *http.Request) string {
+	if route := matchRoute(r.URL.Path); route != lyricRoute {
+		return route.Name
+	}
+
+	query := r.URL.Query()
+	switch {
+	case query.Get("word") != "":
+		if n, _ := strconv.Atoi(query.Get("n")); n > 0 {
+			return "search_select"
 		}
+		return "search"
+	case query.Get("title") != "":
+		return "match"
+	case query.Get("id") != "" || query.Get("mid") != "":
+		return "id"
 	}
+	return "invalid"
+}
 
-	return result.String()
+// statusRecorder 记录响应状态码，同时保留 SSE 需要的 Flush 和 WebSocket 需要的 Hijack
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
 }
 
-func groupLinesIntoDivs(lines []*LineInfo, maxGap int) []DivInfo {
-	if len(lines) == 0 {
-		return nil
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
+}
 
-	var divs []DivInfo
-	currentDiv := DivInfo{
-		StartTime: lines[0].StartTime,
-		Lines:     []*LineInfo{lines[0]},
+// Hijack 接管连接，成功时按 101 记录
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
 	}
+	return conn, rw, err
+}
 
-	for i := 1; i < len(lines); i++ {
-		prevLine := lines[i-1]
-		var prevContentEndTime int
-		if len(prevLine.Words) > 0 {
-			lastWord := prevLine.Words[len(prevLine.Words)-1]
-			prevContentEndTime = lastWord.StartTime + lastWord.Duration
-		} else {
-			prevContentEndTime = prevLine.EndTime
-		}
+// metricsHandler 以 Prometheus 文本格式输出当前实例的指标。
+// 配置了 API Key 时指标包含各 key 的用量，需要管理令牌
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if len(config.Auth.Keys) > 0 && !authorizeAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	for _, m := range metricRegistry {
+		m.write(w)
+	}
+}
 
-		gap := lines[i].StartTime - prevContentEndTime
+// --- API Key 认证 ---
 
-		if gap > maxGap {
-			lastLineInCurrentDiv := currentDiv.Lines[len(currentDiv.Lines)-1]
-			if len(lastLineInCurrentDiv.Words) > 0 {
-				lastWord := lastLineInCurrentDiv.Words[len(lastLineInCurrentDiv.Words)-1]
-				currentDiv.EndTime = lastWord.StartTime + lastWord.Duration
-			} else {
-				currentDiv.EndTime = lastLineInCurrentDiv.EndTime
-			}
-			divs = append(divs, currentDiv)
+// configExemptRoutes 配置无效时仍然响应的路由，其余路由返回 503 CONFIG_INVALID，
+// 以免按默认配置运行时绕过 auth.keys 等限制
+var configExemptRoutes = map[string]bool{
+	"healthz": true,
+	"readyz":  true,
+}
 
-			currentDiv = DivInfo{
-				StartTime: lines[i].StartTime,
-				Lines:     []*LineInfo{lines[i]},
-			}
-		} else {
-			currentDiv.Lines = append(currentDiv.Lines, lines[i])
-		}
+// rateLimitHeaders 限流响应头的 OpenAPI 描述
+var rateLimitHeaders = map[string]interface{}{
+	"X-RateLimit-Limit": map[string]interface{}{
+		"description": "令牌桶容量 (burst)。限额按实例计算，多实例部署时每个实例各有一个令牌桶",
+		"schema":      map[string]interface{}{"type": "integer"},
+	},
+	"X-RateLimit-Remaining": map[string]interface{}{
+		"description": "请求开始时当前实例令牌桶的剩余令牌数，不反映其他实例的用量",
+		"schema":      map[string]interface{}{"type": "integer"},
+	},
+}
+
+// authExemptRoutes 不需要 API Key 的路由，管理接口使用单独的令牌
+var authExemptRoutes = map[string]bool{
+	"healthz":      true,
+	"readyz":       true,
+	"metrics":      true,
+	"openapi":      true,
+	"admin_config": true,
+}
+
+// keyLimiter 单个 API Key 的令牌桶和当日用量。
+// 状态只保存在当前进程内，多实例部署 (包括 Serverless) 时每个实例分别限流和计算配额，
+// 整体可用的速率和配额最多为配置值乘以实例数
+type keyLimiter struct {
+	tokens  float64
+	updated time.Time
+	day     string
+	used    int
+}
+
+var (
+	keyLimitersMu sync.Mutex
+	keyLimiters   = make(map[string]*keyLimiter)
+)
+
+// requestAPIKey 从 X-API-Key 请求头或 api_key 参数读取 API Key
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
 	}
+	return r.URL.Query().Get("api_key")
+}
 
-	if len(currentDiv.Lines) > 0 {
-		lastLineInCurrentDiv := currentDiv.Lines[len(currentDiv.Lines)-1]
-		if len(lastLineInCurrentDiv.Words) > 0 {
-			lastWord := lastLineInCurrentDiv.Words[len(lastLineInCurrentDiv.Words)-1]
-			currentDiv.EndTime = lastWord.StartTime + lastWord.Duration
-		} else {
-			currentDiv.EndTime = lastLineInCurrentDiv.EndTime
+// findAPIKey 按常量时间比较查找配置中的 API Key
+func findAPIKey(key string) (APIKeyConfig, bool) {
+	var found APIKeyConfig
+	ok := false
+	for _, k := range config.Auth.Keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k.Key)) == 1 {
+			found, ok = k, true
 		}
-		divs = append(divs, currentDiv)
 	}
-
-	return divs
+	return found, ok
 }
 
-func calculateSongDuration(lines []*LineInfo) int {
-	if len(lines) == 0 {
-		return 0
+// take 消耗一个令牌，失败时返回错误码和建议的重试等待时间
+func (k APIKeyConfig) take(now time.Time) (remaining int, code ErrorCode, retryAfter time.Duration) {
+	keyLimitersMu.Lock()
+	defer keyLimitersMu.Unlock()
+
+	l := keyLimiters[k.Name]
+	if l == nil {
+		l = &keyLimiter{tokens: float64(k.Burst), updated: now}
+		keyLimiters[k.Name] = l
 	}
+	l.tokens = math.Min(float64(k.Burst), l.tokens+now.Sub(l.updated).Seconds()*k.Rate)
+	l.updated = now
 
-	maxEndTime := 0
-	for _, line := range lines {
-		if len(line.Words) > 0 {
-			lastWord := line.Words[len(line.Words)-1]
-			lineContentEndTime := lastWord.StartTime + lastWord.Duration
-			if lineContentEndTime > maxEndTime {
-				maxEndTime = lineContentEndTime
-			}
-		} else {
-			if line.EndTime > maxEndTime {
-				maxEndTime = line.EndTime
-			}
-		}
+	day := now.UTC().Format("2006-01-02")
+	if l.day != day {
+		l.day, l.used = day, 0
 	}
-	return maxEndTime + 1000
+	if k.DailyQuota > 0 && l.used >= k.DailyQuota {
+		tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return int(l.tokens), ErrCodeQuotaExceeded, tomorrow.Sub(now)
+	}
+	if l.tokens < 1 {
+		wait := time.Duration((1 - l.tokens) / k.Rate * float64(time.Second))
+		return 0, ErrCodeTooManyRequests, wait
+	}
+	l.tokens--
+	l.used++
+	return int(l.tokens), "", 0
 }
 
-func matchRomajiLine(mainLineTime int, romajiLines []*LineInfo) *LineInfo {
-	const maxTimeDiff = 100
-	for _, romaLine := range romajiLines {
-		timeDiff := abs(romaLine.StartTime-mainLineTime)
-		if timeDiff <= maxTimeDiff {
-			return romaLine
+// upstreamCharge 一个已认证请求的上游调用计费。请求本身消耗一个令牌并抵扣第一次上游调用，
+// 之后每次上游调用 (批量中的每次搜索和获取、probe 的每首歌) 再各消耗一个
+type upstreamCharge struct {
+	key     APIKeyConfig
+	prepaid int32
+}
+
+type upstreamChargeContextKey struct{}
+
+// rateLimitError 上游调用因 API Key 限额不足被拒绝
+type rateLimitError struct {
+	Key        string
+	Code       ErrorCode
+	RetryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	if e.Code == ErrCodeQuotaExceeded {
+		return fmt.Sprintf("API Key '%s' 当日配额已用完", e.Key)
+	}
+	return fmt.Sprintf("API Key '%s' 请求过于频繁", e.Key)
+}
+
+// chargeUpstream 为一次上游调用扣减请求所属 API Key 的令牌，未认证的请求不计费
+func chargeUpstream(ctx context.Context) error {
+	charge, ok := ctx.Value(upstreamChargeContextKey{}).(*upstreamCharge)
+	if !ok {
+		return nil
+	}
+	if atomic.AddInt32(&charge.prepaid, -1) >= 0 {
+		return nil
+	}
+	if _, code, retryAfter := charge.key.take(time.Now()); code != "" {
+		if code == ErrCodeQuotaExceeded {
+			apiKeyRequests.inc(charge.key.Name, "quota_exceeded")
+		} else {
+			apiKeyRequests.inc(charge.key.Name, "rate_limited")
 		}
+		return &rateLimitError{Key: charge.key.Name, Code: code, RetryAfter: retryAfter}
 	}
 	return nil
 }
 
-func convertYrcToTtml(data *LyricData) (string, error) {
-	sb := getTTMLBuilder()
-	defer putTTMLBuilder(sb)
+// authorizeRequest 校验 API Key 并扣减限额，未通过时写入错误响应并返回 false。
+// 通过时返回的请求携带上游调用计费，未配置任何 key 时直接放行。
+func authorizeRequest(w http.ResponseWriter, r *http.Request, route string) (*http.Request, bool) {
+	if len(config.Auth.Keys) == 0 || authExemptRoutes[route] {
+		return r, true
+	}
 
-	translations := parseLrcTimedLines(data.Data.Trans)
-	parsedLines := parseYrcToLines(data.Data.Yrc)
-	parsedRomaji := parseYrcToLines(data.Data.Roma)
+	key, ok := findAPIKey(requestAPIKey(r))
+	if !ok {
+		apiKeyRequests.inc("unknown", "unauthorized")
+		writeErrorJSON(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "未授权", "需要有效的 API Key (X-API-Key 请求头或 api_key 参数)")
+		return r, false
+	}
 
-	if len(parsedLines) == 0 {
-		return "", fmt.Errorf("未找到有效的YRC歌词行")
+	remaining, code, retryAfter := key.take(time.Now())
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(key.Burst))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+	switch code {
+	case ErrCodeTooManyRequests:
+		apiKeyRequests.inc(key.Name, "rate_limited")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeErrorJSON(w, r, http.StatusTooManyRequests, code, "请求过于频繁",
+			fmt.Sprintf("API Key '%s' 限制为每秒 %g 次、突发 %d 次", key.Name, key.Rate, key.Burst))
+		return r, false
+	case ErrCodeQuotaExceeded:
+		apiKeyRequests.inc(key.Name, "quota_exceeded")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeErrorJSON(w, r, http.StatusTooManyRequests, code, "配额已用完",
+			fmt.Sprintf("API Key '%s' 每日限 %d 次，UTC 零点重置", key.Name, key.DailyQuota))
+		return r, false
 	}
 
-	sb.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
-	sb.WriteString("<tt xmlns=\"http://www.w3.org/ns/ttml\" xmlns:ttm=\"http://www.w3.org/ns/ttml#metadata\" xmlns:itunes=\"http://music.apple.com/lyric-ttml-internal\" itunes:timing=\"Word\">\n")
-	sb.WriteString("    <head>\n        <metadata>\n")
-	sb.WriteString("            <ttm:agent type=\"person\" xml:id=\"v1\"/>\n")
-	sb.WriteString("        </metadata>\n    </head>\n")
+	apiKeyRequests.inc(key.Name, "allowed")
+	logDebug(r.Context(), "API Key 认证通过", "api_key", key.Name, "remaining", remaining)
+	ctx := context.WithValue(r.Context(), upstreamChargeContextKey{}, &upstreamCharge{key: key, prepaid: 1})
+	return r.WithContext(ctx), true
+}
 
-	songDuration := calculateSongDuration(parsedLines)
-	songDurationStr := msToTtmlTime(songDuration)
+// --- 健康检查 ---
 
-	divs := groupLinesIntoDivs(parsedLines, 1000)
+const (
+	upstreamProvider     = "tencent"
+	upstreamStatsWindow  = 5 * time.Minute
+	upstreamStatsMaxSize = 1000 // 每个接口最多保留的样本数
+	readyProbeKeyword    = "test"
+)
 
-	sb.WriteString(fmt.Sprintf("    <body dur=\"%s\">\n", songDurationStr))
+var processStartTime = time.Now()
 
-	lineCounter := 1
-	for divIdx, div := range divs {
-		divBegin := msToTtmlTime(div.StartTime)
-		divEnd := msToTtmlTime(div.EndTime)
+// HealthResponse /healthz 和 /readyz 的响应
+type HealthResponse struct {
+	Status  string `json:"status"` // ok | unavailable
+	Uptime  int64  `json:"uptime"` // 进程运行时长 (秒)
+	Checked string `json:"checked,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
 
-		sb.WriteString(fmt.Sprintf("        <div begin=\"%s\" end=\"%s\">\n", divBegin, divEnd))
+// UpstreamEndpointStatus 一个上游接口在统计窗口内的错误率和耗时
+type UpstreamEndpointStatus struct {
+	Provider    string  `json:"provider"`
+	Endpoint    string  `json:"endpoint"`
+	Requests    int     `json:"requests"`
+	Errors      int     `json:"errors"`
+	ErrorRate   float64 `json:"error_rate"`
+	LatencyAvg  int64   `json:"latency_avg_ms"`
+	LatencyP50  int64   `json:"latency_p50_ms"`
+	LatencyP95  int64   `json:"latency_p95_ms"`
+	LastError   string  `json:"last_error,omitempty"`
+	LastErrorAt string  `json:"last_error_at,omitempty"`
+}
 
-		for _, line := range div.Lines {
-			lineBegin := msToTtmlTime(line.StartTime)
+// UpstreamStatusResponse 上游状态接口的响应
+type UpstreamStatusResponse struct {
+	Code     int                      `json:"code"`
+	Message  string                   `json:"message"`
+	Window   int                      `json:"window"` // 统计窗口 (秒)
+	Data     []UpstreamEndpointStatus `json:"data"`
+	Circuits []CircuitStatus          `json:"circuits"` // 各上游主机的熔断状态
+}
 
-			var pTagEndTime int
-			if len(line.Words) > 0 {
-				lastWord := line.Words[len(line.Words)-1]
-				pTagEndTime = lastWord.StartTime + lastWord.Duration
-			} else {
-				pTagEndTime = line.EndTime
-			}
-			pTagEndTimeStr := msToTtmlTime(pTagEndTime)
+type upstreamSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
 
-			sb.WriteString(fmt.Sprintf("            <p begin=\"%s\" end=\"%s\" ttm:agent=\"v1\" itunes:key=\"L%d\">\n", lineBegin, pTagEndTimeStr, lineCounter))
+// upstreamStats 按接口保存最近 upstreamStatsWindow 内的上游请求样本
+type upstreamStats struct {
+	mu          sync.Mutex
+	samples     map[string][]upstreamSample
+	lastError   map[string]string
+	lastErrorAt map[string]time.Time
+}
 
-			for _, word := range line.Words {
-				wordBegin := msToTtmlTime(word.StartTime)
-				wordEnd := msToTtmlTime(word.StartTime + word.Duration)
-				sb.WriteString(fmt.Sprintf("                <span begin=\"%s\" end=\"%s\">%s</span>\n", wordBegin, wordEnd, word.Text))
-			}
+var upstreamHealth = &upstreamStats{
+	samples:     make(map[string][]upstreamSample),
+	lastError:   make(map[string]string),
+	lastErrorAt: make(map[string]time.Time),
+}
 
-			transText := findClosestLine(line.StartTime, translations)
-			if transText != "" {
-				sb.WriteString(fmt.Sprintf("                <span ttm:role=\"x-translation\" xml:lang=\"zh-CN\">%s</span>\n", transText))
-			}
+func (s *upstreamStats) record(endpoint string, at time.Time, latency time.Duration, result string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	samples := append(s.samples[endpoint], upstreamSample{at: at, latency: latency, failed: result != "ok"})
+	if len(samples) > upstreamStatsMaxSize {
+		samples = samples[len(samples)-upstreamStatsMaxSize:]
+	}
+	s.samples[endpoint] = samples
+	if result != "ok" {
+		if err != nil {
+			s.lastError[endpoint] = err.Error()
+		} else {
+			s.lastError[endpoint] = result
+		}
+		s.lastErrorAt[endpoint] = at
+	}
+}
 
-			romaLine := matchRomajiLine(line.StartTime, parsedRomaji)
-			if romaLine != nil {
-				var romaBuilder strings.Builder
-				hasContent := false
-				for _, word := range romaLine.Words {
-					trimmed := strings.TrimSpace(word.Text)
-					if trimmed != "" {
-						hasContent = true
-						romaBuilder.WriteString(word.Text)
-					}
-				}
-				if hasContent {
-					romaText := strings.TrimSpace(romaBuilder.String())
-					if romaText != "" {
-						sb.WriteString(fmt.Sprintf("                <span ttm:role=\"x-roman\">%s</span>\n", romaText))
-					}
+func (s *upstreamStats) snapshot(now time.Time) []UpstreamEndpointStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := make([]string, 0, len(s.samples))
+	for endpoint := range s.samples {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	result := make([]UpstreamEndpointStatus, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		// 丢弃窗口外的样本
+		samples := s.samples[endpoint]
+		first := sort.Search(len(samples), func(i int) bool { return now.Sub(samples[i].at) <= upstreamStatsWindow })
+		samples = samples[first:]
+		s.samples[endpoint] = samples
+
+		status := UpstreamEndpointStatus{Provider: upstreamProvider, Endpoint: endpoint, Requests: len(samples)}
+		if len(samples) > 0 {
+			latencies := make([]time.Duration, len(samples))
+			var total time.Duration
+			for i, sample := range samples {
+				latencies[i] = sample.latency
+				total += sample.latency
+				if sample.failed {
+					status.Errors++
 				}
 			}
-
-			sb.WriteString("            </p>\n")
-			lineCounter++
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			status.ErrorRate = math.Round(float64(status.Errors)/float64(len(samples))*1000) / 1000
+			status.LatencyAvg = (total / time.Duration(len(samples))).Milliseconds()
+			status.LatencyP50 = latencies[(len(latencies)-1)*50/100].Milliseconds()
+			status.LatencyP95 = latencies[(len(latencies)-1)*95/100].Milliseconds()
 		}
-
-		sb.WriteString("        </div>\n")
-		if divIdx < len(divs)-1 {
-			sb.WriteString("\n")
+		if at, ok := s.lastErrorAt[endpoint]; ok {
+			status.LastError = s.lastError[endpoint]
+			status.LastErrorAt = at.UTC().Format(time.RFC3339)
 		}
+		result = append(result, status)
 	}
+	return result
+}
 
-	sb.WriteString("    </body>\n</tt>\n")
-	return sb.String(), nil
+var (
+	readyMu      sync.Mutex
+	readyChecked time.Time
+	readyErr     error
+	readyProbing chan struct{} // 正在进行的探测，结束时关闭
+)
+
+// checkReady 探测上游搜索接口是否可用，结果缓存 upstream.ready_probe_ttl。
+// 同一时间只有一个探测，在后台使用独立的 context 运行，所有调用方 (包括发起探测的)
+// 都等待它的结果或自己的 ctx 结束；调用方断开不会中止探测，也不会让失败结果被缓存
+func checkReady(ctx context.Context) (time.Time, error) {
+	readyMu.Lock()
+	if !readyChecked.IsZero() && time.Since(readyChecked) < config.Upstream.ReadyProbeTTL.Duration() {
+		defer readyMu.Unlock()
+		return readyChecked, readyErr
+	}
+	probing := readyProbing
+	if probing == nil {
+		probing = make(chan struct{})
+		readyProbing = probing
+		go runReadyProbe(probing)
+	}
+	readyMu.Unlock()
+
+	select {
+	case <-probing:
+	case <-ctx.Done():
+		return time.Now(), ctx.Err()
+	}
+	readyMu.Lock()
+	defer readyMu.Unlock()
+	return readyChecked, readyErr
 }
 
-func convertYrcToEnhancedLrc(yrcContent, lrcContent, transContent, romaContent string) (string, error) {
-	var result strings.Builder
+// runReadyProbe 执行一次探测并保存结果，不持有 readyMu 访问上游
+func runReadyProbe(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Upstream.Timeout.Duration())
+	defer cancel()
+	_, err := searchSongs(ctx, readyProbeKeyword, 1, 1)
+	if err != nil {
+		logWarn(ctx, "上游就绪探测失败", "error", err)
+	}
 
-	meta := parseLrcMeta(lrcContent)
+	readyMu.Lock()
+	readyChecked, readyErr, readyProbing = time.Now(), err, nil
+	readyMu.Unlock()
+	close(done)
+}
 
-	for key, value := range meta {
-		if key != "kana" {
-			result.WriteString(fmt.Sprintf("[%s:%s]\n", key, value))
-		}
+// healthzHandler 进程存活检查，不访问上游
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	renderJSON(w, http.StatusOK, HealthResponse{Status: "ok", Uptime: int64(time.Since(processStartTime).Seconds())})
+}
+
+// readyzHandler 就绪检查，配置无效或上游不可用时返回 503
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if configErr != nil {
+		renderJSON(w, http.StatusServiceUnavailable, HealthResponse{
+			Status: "unavailable",
+			Uptime: int64(time.Since(processStartTime).Seconds()),
+			Error:  "配置无效: " + configErr.Error(),
+		})
+		return
 	}
 
-	translations := parseLrcTimedLines(transContent)
-	hasTranslation := len(translations) > 0
+	checked, err := checkReady(r.Context())
+	resp := HealthResponse{Status: "ok", Uptime: int64(time.Since(processStartTime).Seconds()), Checked: checked.UTC().Format(time.RFC3339)}
+	if err != nil {
+		resp.Status = "unavailable"
+		resp.Error = err.Error()
+		renderJSON(w, http.StatusServiceUnavailable, resp)
+		return
+	}
+	renderJSON(w, http.StatusOK, resp)
+}
 
-	rawLines := strings.Split(yrcContent, "\n")
+// upstreamStatusHandler 返回每个上游接口最近的错误率和耗时
+func upstreamStatusHandler(w http.ResponseWriter, r *http.Request) {
+	renderJSON(w, http.StatusOK, UpstreamStatusResponse{
+		Code:     200,
+		Message:  localize(requestLang(r), "请求成功"),
+		Window:   int(upstreamStatsWindow.Seconds()),
+		Data:     upstreamHealth.snapshot(time.Now()),
+		Circuits: circuitSnapshot(),
+	})
+}
 
-	for _, line := range rawLines {
-		line = strings.TrimSpace(line)
-		if line == "" || isMetadataLine(line) {
-			continue
-		}
+// --- 链路追踪 ---
 
-		lineInfo, err := parseYrcLine(line)
-		if err != nil || len(lineInfo.Words) == 0 {
-			continue
-		}
+// 仅依赖标准库的最小实现：解析和传播 W3C traceparent，记录请求内的 span，
+// 请求结束时放入批量处理队列，由后台 goroutine 异步导出，不阻塞响应。
+// OTEL_TRACES_EXPORTER 为 none (默认) 时只传播不记录。
 
-		mainTimestamp := msToLrcTime(lineInfo.StartTime)
-		result.WriteString(mainTimestamp)
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
 
-		for _, word := range lineInfo.Words {
-			wordTimestamp := msToEnhancedLrcTime(word.StartTime)
-			result.WriteString(wordTimestamp)
-			result.WriteString(word.Text)
-		}
+	spanStatusError = 2
+)
 
-		if len(lineInfo.Words) > 0 {
-			lastWord := lineInfo.Words[len(lineInfo.Words)-1]
-			finalTimestamp := msToEnhancedLrcTime(lastWord.StartTime + lastWord.Duration)
-			result.WriteString(finalTimestamp)
-		}
+// traceSpan 一个追踪区间
+type traceSpan struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Kind     int
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]interface{}
+	Status   int
+	Message  string
+	Sampled  bool
+
+	recorder *traceRecorder
+}
 
-		result.WriteString("\n")
+// traceRecorder 收集一个请求内结束的 span
+type traceRecorder struct {
+	mu    sync.Mutex
+	spans []*traceSpan
+}
 
-		if hasTranslation {
-			translation := findClosestLine(lineInfo.StartTime, translations)
-			if translation != "" {
-				result.WriteString(fmt.Sprintf("%s%s\n", mainTimestamp, translation))
-			}
-		}
-	}
+type spanContextKey struct{}
+type recorderContextKey struct{}
 
-	return result.String(), nil
+// spanExporter 导出一批 span，导出失败只记录日志
+type spanExporter interface {
+	export(ctx context.Context, spans []*traceSpan) error
 }
 
-func findClosestLine(time int, lines []MetaLine) string {
-	const maxTimeDiff = 500
-	bestIndex := -1
-	minDiff := maxTimeDiff
+// 批量导出的默认参数，可用 OTEL_BSP_* 环境变量覆盖 (时间单位为毫秒)。
+// 导出超时比 OpenTelemetry 默认的 30 秒短得多，collector 不可用时尽快放弃
+const (
+	defaultTraceQueueSize     = 2048
+	defaultTraceBatchSize     = 512
+	defaultTraceScheduleDelay = time.Second
+	defaultTraceExportTimeout = time.Second
+)
 
-	for i, line := range lines {
-		timeDiff := abs(line.Time - time)
-		if timeDiff < minDiff {
-			minDiff = timeDiff
-			bestIndex = i
+// traceProcessor 为空时只传播 trace context，不记录 span
+var traceProcessor *batchSpanProcessor
+
+func init() {
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "lyric-api"
+	}
+
+	var exporter spanExporter
+	switch os.Getenv("OTEL_TRACES_EXPORTER") {
+	case "stdout", "console":
+		exporter = &stdoutExporter{w: os.Stdout}
+	case "otlp":
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+		if endpoint == "" {
+			endpoint = strings.TrimSuffix(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/") + "/v1/traces"
+		}
+		if !strings.HasPrefix(endpoint, "http") {
+			endpoint = "http://localhost:4318/v1/traces"
+		}
+		exporter = &otlpHTTPExporter{
+			endpoint:    endpoint,
+			headers:     parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+			serviceName: serviceName,
+			client:      &http.Client{},
 		}
 	}
-
-	if bestIndex != -1 {
-		return lines[bestIndex].Content
+	if exporter != nil {
+		traceProcessor = newBatchSpanProcessor(exporter,
+			envInt("OTEL_BSP_MAX_QUEUE_SIZE", defaultTraceQueueSize),
+			envInt("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", defaultTraceBatchSize),
+			time.Duration(envInt("OTEL_BSP_SCHEDULE_DELAY", int(defaultTraceScheduleDelay.Milliseconds())))*time.Millisecond,
+			time.Duration(envInt("OTEL_BSP_EXPORT_TIMEOUT", int(defaultTraceExportTimeout.Milliseconds())))*time.Millisecond)
 	}
-	return ""
 }
 
-func msToLrcTime(ms int) string {
-	seconds := ms / 1000
-	milliseconds := (ms % 1000) / 10
-	minutes := seconds / 60
-	seconds = seconds % 60
-	return fmt.Sprintf("[%02d:%02d.%02d]", minutes, seconds, milliseconds)
+// envInt 读取正整数环境变量，未设置或不合法时返回 def
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
 }
 
-func msToEnhancedLrcTime(ms int) string {
-	seconds := ms / 1000
-	milliseconds := (ms % 1000) / 10
-	minutes := seconds / 60
-	seconds = seconds % 60
-	return fmt.Sprintf("<%02d:%02d.%02d>", minutes, seconds, milliseconds)
+// batchSpanProcessor 在有界队列中收集结束的 span，由后台 goroutine 按批导出。
+// 队列满时丢弃新的 span，每次导出受 timeout 限制，请求处理不会等待导出
+type batchSpanProcessor struct {
+	exporter  spanExporter
+	queue     chan *traceSpan
+	batchSize int
+	delay     time.Duration
+	timeout   time.Duration
 }
 
-func msToTtmlTime(ms int) string {
-	hours := ms / 3600000
-	ms %= 3600000
-	minutes := ms / 60000
-	ms %= 60000
-	seconds := ms / 1000
-	milliseconds := ms % 1000
+func newBatchSpanProcessor(exporter spanExporter, queueSize, batchSize int, delay, timeout time.Duration) *batchSpanProcessor {
+	if batchSize > queueSize {
+		batchSize = queueSize
+	}
+	p := &batchSpanProcessor{
+		exporter:  exporter,
+		queue:     make(chan *traceSpan, queueSize),
+		batchSize: batchSize,
+		delay:     delay,
+		timeout:   timeout,
+	}
+	go p.run()
+	return p
+}
 
-	if hours > 0 {
-		return fmt.Sprintf("%02d:%02d:%02d.%03d", hours, minutes, seconds, milliseconds)
+// enqueue 把 span 放入队列，不阻塞
+func (p *batchSpanProcessor) enqueue(spans []*traceSpan) {
+	for _, s := range spans {
+		select {
+		case p.queue <- s:
+		default:
+			traceSpans.inc("dropped")
+		}
 	}
-	return fmt.Sprintf("%02d:%02d.%03d", minutes, seconds, milliseconds)
 }
 
-func abs(x int) int {
-	if x < 0 {
-		return -x
+// run 攒满 batchSize 或每隔 delay 导出一次
+func (p *batchSpanProcessor) run() {
+	ticker := time.NewTicker(p.delay)
+	defer ticker.Stop()
+	batch := make([]*traceSpan, 0, p.batchSize)
+	for {
+		select {
+		case s := <-p.queue:
+			batch = append(batch, s)
+			if len(batch) < p.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		p.exportBatch(batch)
+		batch = make([]*traceSpan, 0, p.batchSize)
 	}
-	return x
 }
 
-func isMetadataLine(line string) bool {
-	return strings.HasPrefix(line, "[ti:") ||
-		strings.HasPrefix(line, "[ar:") ||
-		strings.HasPrefix(line, "[al:") ||
-		strings.HasPrefix(line, "[by:") ||
-		strings.HasPrefix(line, "[offset:") ||
-		strings.HasPrefix(line, "[kana:") ||
-		strings.HasPrefix(line, "[re:") ||
-		strings.HasPrefix(line, "[ve:")
+func (p *batchSpanProcessor) exportBatch(batch []*traceSpan) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	if err := p.exporter.export(ctx, batch); err != nil {
+		traceSpans.add(float64(len(batch)), "failed")
+		logError(ctx, "导出追踪数据失败", "spans", len(batch), "error", err)
+		return
+	}
+	traceSpans.add(float64(len(batch)), "exported")
 }
 
-// --- API 客户端函数 ---
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
 
-const UPSTREAM_API_BASE = "https://api.vkeys.cn/v2/music/tencent"
-const UPSTREAM_LYRIC_API = UPSTREAM_API_BASE + "/lyric"
+// parseTraceparent 解析 "00-<trace-id>-<parent-id>-<flags>"，格式不合法时 ok 为 false
+func parseTraceparent(value string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	for _, part := range parts {
+		if _, err := hex.DecodeString(part); err != nil {
+			return "", "", false, false
+		}
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", "", false, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return parts[1], parts[2], flags[0]&1 == 1, true
+}
 
-// searchSongs 搜索歌曲
-func searchSongs(word string, num int) ([]SearchSongItemSimplified, error) {
-	searchURL := fmt.Sprintf("%s?word=%s&num=%d", UPSTREAM_API_BASE, url.QueryEscape(word), num)
-	logInfo("搜索歌曲: %s (num=%d)", word, num)
+func (s *traceSpan) traceparent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
 
-	client := http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(searchURL)
-	if err != nil {
-		return nil, fmt.Errorf("搜索请求失败: %w", err)
+// startRootSpan 开始请求的根 span，沿用请求头中的 traceparent
+func startRootSpan(r *http.Request, name string) (context.Context, *traceSpan) {
+	span := &traceSpan{SpanID: randomHex(8), Name: name, Kind: spanKindServer, Start: time.Now(), Sampled: true}
+	if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		span.TraceID, span.ParentID, span.Sampled = traceID, parentID, sampled
+	} else {
+		span.TraceID = randomHex(16)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("读取搜索响应失败: %w", err)
+	ctx := r.Context()
+	if traceProcessor != nil && span.Sampled {
+		span.recorder = &traceRecorder{}
+		ctx = context.WithValue(ctx, recorderContextKey{}, span.recorder)
 	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("搜索API返回状态: %s", resp.Status)
+// startSpan 在 ctx 当前 span 下开始子 span
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *traceSpan) {
+	span := &traceSpan{SpanID: randomHex(8), Name: name, Kind: kind, Start: time.Now()}
+	if parent, ok := ctx.Value(spanContextKey{}).(*traceSpan); ok {
+		span.TraceID, span.ParentID, span.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		span.TraceID = randomHex(16)
 	}
+	span.recorder, _ = ctx.Value(recorderContextKey{}).(*traceRecorder)
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
 
-	var rawResult struct {
-		Code    int                 `json:"code"`
-		Message string              `json:"message"`
-		Data    []SearchSongItemRaw `json:"data"`
+func (s *traceSpan) setAttr(key string, value interface{}) {
+	if s.Attrs == nil {
+		s.Attrs = make(map[string]interface{})
 	}
+	s.Attrs[key] = value
+}
 
-	if err := json.Unmarshal(body, &rawResult); err != nil {
-		return nil, fmt.Errorf("解析搜索结果失败: %w", err)
+// finish 结束 span，err 不为空时标记为错误
+func (s *traceSpan) finish(err error) {
+	if err != nil {
+		s.Status = spanStatusError
+		s.Message = err.Error()
 	}
+	s.End = time.Now()
+	if s.recorder != nil {
+		s.recorder.mu.Lock()
+		s.recorder.spans = append(s.recorder.spans, s)
+		s.recorder.mu.Unlock()
+	}
+}
 
-	if rawResult.Code != 200 {
-		return nil, fmt.Errorf("搜索API返回错误: %s", rawResult.Message)
+// flushTrace 把请求内记录的 span 交给批量处理队列，立即返回
+func flushTrace(root *traceSpan) {
+	if root.recorder == nil {
+		return
 	}
+	root.recorder.mu.Lock()
+	spans := root.recorder.spans
+	root.recorder.spans = nil
+	root.recorder.mu.Unlock()
+	traceProcessor.enqueue(spans)
+}
 
-	simplifiedSongs := make([]SearchSongItemSimplified, 0, len(rawResult.Data))
-	for i, item := range rawResult.Data {
-		simplifiedSongs = append(simplifiedSongs, SearchSongItemSimplified{
-			N:      i + 1,
-			Song:   item.Song,
-			Singer: item.Singer,
-			Album:  item.Album,
-			ID:     item.ID,
-			MID:    item.MID,
+// stdoutExporter 每个 span 输出一行 JSON，用于本地调试
+type stdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (e *stdoutExporter) export(ctx context.Context, spans []*traceSpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoder := json.NewEncoder(e.w)
+	encoder.SetEscapeHTML(false)
+	for _, s := range spans {
+		err := encoder.Encode(map[string]interface{}{
+			"trace_id":       s.TraceID,
+			"span_id":        s.SpanID,
+			"parent_span_id": s.ParentID,
+			"name":           s.Name,
+			"start":          s.Start.Format(time.RFC3339Nano),
+			"duration_ms":    float64(s.End.Sub(s.Start).Microseconds()) / 1000,
+			"attributes":     s.Attrs,
+			"error":          s.Message,
 		})
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return simplifiedSongs, nil
+// otlpHTTPExporter 以 OTLP/HTTP JSON 格式导出到 collector
+type otlpHTTPExporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
 }
 
-func fetchLyricData(id, mid string) (*LyricData, []byte, error) {
-	var requestURL string
-	if id != "" {
-		requestURL = fmt.Sprintf("%s?id=%s", UPSTREAM_LYRIC_API, id)
-	} else if mid != "" {
-		requestURL = fmt.Sprintf("%s?mid=%s", UPSTREAM_LYRIC_API, mid)
-	} else {
-		return nil, nil, fmt.Errorf("ID 和 MID 均为空")
+// parseOTLPHeaders 解析 "k1=v1,k2=v2"
+func parseOTLPHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(k) != "" {
+			unescaped, err := url.QueryUnescape(strings.TrimSpace(v))
+			if err != nil {
+				unescaped = strings.TrimSpace(v)
+			}
+			headers[strings.TrimSpace(k)] = unescaped
+		}
 	}
+	return headers
+}
 
-	client := http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(requestURL)
-	if err != nil {
-		return nil, nil, fmt.Errorf("上游歌词API请求失败: %w", err)
+func otlpAttributes(attrs map[string]interface{}) []map[string]interface{} {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
 	}
-	defer resp.Body.Close()
+	sort.Strings(keys)
+
+	result := make([]map[string]interface{}, 0, len(attrs))
+	for _, k := range keys {
+		var value map[string]interface{}
+		switch v := attrs[k].(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		result = append(result, map[string]interface{}{"key": k, "value": value})
+	}
+	return result
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("上游歌词API返回状态: %s", resp.Status)
+func (e *otlpHTTPExporter) export(ctx context.Context, spans []*traceSpan) error {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		span := map[string]interface{}{
+			"traceId":           s.TraceID,
+			"spanId":            s.SpanID,
+			"name":              s.Name,
+			"kind":              s.Kind,
+			"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.Attrs),
+		}
+		if s.ParentID != "" {
+			span["parentSpanId"] = s.ParentID
+		}
+		if s.Status != 0 {
+			span["status"] = map[string]interface{}{"code": s.Status, "message": s.Message}
+		}
+		otlpSpans = append(otlpSpans, span)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": e.serviceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "lyric-api"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return nil, nil, fmt.Errorf("读取歌词响应体失败: %w", err)
+		return err
 	}
 
-	var lyricData LyricData
-	if err := json.Unmarshal(body, &lyricData); err != nil {
-		return nil, nil, fmt.Errorf("解析上游歌词JSON失败: %w", err)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP collector 返回状态: %s", resp.Status)
+	}
+	return nil
+}
 
-	return &lyricData, body, nil
+// --- OpenAPI 规范 ---
+
+const openAPIVersion = "3.1.0"
+
+// apiParam 接口查询参数的描述
+type apiParam struct {
+	Name        string
+	Type        string // string | integer | number | boolean
+	Description string
+	Required    bool
 }
 
-// --- HTTP 处理函数 ---
+// apiOperation 接口描述，请求体和响应的结构由 Go 类型反射生成，保证与处理函数一致
+type apiOperation struct {
+	Method      string
+	Summary     string
+	Params      []apiParam
+	Body        interface{}
+	Responses   map[int][]interface{} // 状态码 -> 可能的响应类型 (多个时为 oneOf，nil 表示无响应体)
+	ContentType string                // 默认为 application/json
+}
 
-// renderJSON 辅助函数：设置 Content-Type 并禁用 HTML 转义
-func renderJSON(w http.ResponseWriter, statusCode int, v interface{}) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(statusCode)
-	encoder := json.NewEncoder(w)
-	encoder.SetEscapeHTML(false)
-	encoder.Encode(v)
+// apiRoute 对外路由。Handler 按此表分发，Name 用于指标和日志，OpenAPI 规范也由此生成，
+// 因此新增接口只需在这里登记一次
+type apiRoute struct {
+	Name      string
+	Paths     []string // 第一个写入 OpenAPI，其余为兼容别名
+	Handler   http.HandlerFunc
+	Operation apiOperation
+}
+
+var (
+	langParam = apiParam{Name: "lang", Type: "string", Description: "响应消息的语言 (zh-CN 或 en)，优先于 Accept-Language"}
+
+	lyricOutputParams = []apiParam{
+		{Name: "lines", Type: "boolean", Description: "返回结构化逐字歌词 data.lines"},
+		{Name: "html", Type: "boolean", Description: "返回带 <ruby> 注音的网页片段 data.html"},
+		{Name: "lint", Type: "string", Description: "1 返回歌词质量检查报告，fix 同时自动修复"},
+		{Name: "interlude", Type: "string", Description: "1 检测间奏 (默认阈值)，或以毫秒指定阈值"},
+		langParam,
+	}
+)
+
+// apiRoutes 全部路由，lyricRoute 同时处理未登记的路径
+var (
+	apiRoutes    []*apiRoute
+	routesByPath map[string]*apiRoute
+	lyricRoute   *apiRoute
+)
+
+// 路由表引用的处理函数 (如 openAPIHandler) 又会读取路由表，因此在 init 中赋值以避免初始化循环
+func init() {
+	lyricRoute = &apiRoute{
+		Name:    "lyric",
+		Paths:   []string{"/v2/music/tencent/lyric"},
+		Handler: lyricHandler,
+		Operation: apiOperation{
+			Method:  "GET",
+			Summary: "搜索歌曲、按 ID/MID 获取歌词，或按元数据匹配歌词",
+			Params: append([]apiParam{
+				{Name: "id", Type: "string", Description: "歌曲 ID"},
+				{Name: "mid", Type: "string", Description: "歌曲 MID"},
+				{Name: "word", Type: "string", Description: "搜索关键字"},
+				{Name: "n", Type: "integer", Description: "选择搜索结果中的第 n 首 (从 1 开始)"},
+				{Name: "page", Type: "integer", Description: "搜索结果页码"},
+				{Name: "num", Type: "integer", Description: "每页条数 (最多 60)"},
+				{Name: "singer", Type: "string", Description: "按歌手过滤搜索结果"},
+				{Name: "album", Type: "string", Description: "按专辑过滤搜索结果，或作为匹配条件"},
+				{Name: "probe", Type: "boolean", Description: "探测每条搜索结果是否有逐字歌词"},
+				{Name: "title", Type: "string", Description: "按元数据匹配时的标题"},
+				{Name: "artist", Type: "string", Description: "按元数据匹配时的歌手"},
+				{Name: "duration", Type: "integer", Description: "按元数据匹配时的时长 (秒)"},
+				{Name: "min_score", Type: "number", Description: "匹配度阈值，0 到 1 之间 (默认 0.6)"},
+			}, lyricOutputParams...),
+			Responses: map[int][]interface{}{
+				200: {UnifiedLyricResponse{}, SearchResponse{}},
+				304: nil,
+				400: {ErrorResponse{}},
+				404: {ErrorResponse{}},
+				424: {ErrorResponse{}},
+				502: {ErrorResponse{}},
+				503: {ErrorResponse{}},
+				504: {ErrorResponse{}},
+			},
+		},
+	}
+
+	apiRoutes = []*apiRoute{
+		lyricRoute,
+		{
+			Name:    "batch",
+			Paths:   []string{"/api/batch"},
+			Handler: batchHandler,
+			Operation: apiOperation{
+				Method:  "POST",
+				Summary: "批量获取歌词",
+				Params: append([]apiParam{
+					{Name: "stream", Type: "boolean", Description: "以 NDJSON 按完成顺序逐行输出 BatchItemResult"},
+				}, lyricOutputParams...),
+				Body: BatchRequest{},
+				Responses: map[int][]interface{}{
+					200: {BatchResponse{}},
+					400: {ErrorResponse{}},
+				},
+			},
+		},
+		{
+			Name:    "position",
+			Paths:   []string{"/api/position"},
+			Handler: positionHandler,
+			Operation: apiOperation{
+				Method:  "GET",
+				Summary: "查询播放位置处的当前行和当前字",
+				Params: []apiParam{
+					{Name: "id", Type: "string", Description: "歌曲 ID"},
+					{Name: "mid", Type: "string", Description: "歌曲 MID"},
+					{Name: "t", Type: "integer", Description: "播放位置 (毫秒)", Required: true},
+					langParam,
+				},
+				Responses: map[int][]interface{}{
+					200: {PositionResponse{}},
+					400: {ErrorResponse{}},
+					404: {ErrorResponse{}},
+					502: {ErrorResponse{}},
+					503: {ErrorResponse{}},
+					504: {ErrorResponse{}},
+				},
+			},
+		},
+		{
+			Name:    "live",
+			Paths:   []string{"/api/live"},
+			Handler: liveHandler,
+			Operation: apiOperation{
+				Method:  "GET",
+				Summary: "以 Server-Sent Events 推送实时歌词 (start/line/word/clear/end 事件)，seek、暂停、变速时以新参数重新连接；带 Upgrade: websocket 时升级为 WebSocket，下发 LiveMessage 并接收 seek/pause/resume/rate 控制消息 (LiveControl)",
+				Params: []apiParam{
+					{Name: "id", Type: "string", Description: "歌曲 ID"},
+					{Name: "mid", Type: "string", Description: "歌曲 MID"},
+					{Name: "pos", Type: "integer", Description: "播放位置 (毫秒)"},
+					{Name: "at", Type: "integer", Description: "采样 pos 时的 Unix 毫秒时间戳"},
+					{Name: "rate", Type: "number", Description: "播放速率"},
+					{Name: "paused", Type: "boolean", Description: "是否暂停"},
+				},
+				Responses: map[int][]interface{}{
+					101: nil,
+					200: {LiveStartEvent{}, LiveLineEvent{}, LiveWordEvent{}, LiveClearEvent{}, LiveEndEvent{}},
+					400: {ErrorResponse{}},
+					404: {ErrorResponse{}},
+					502: {ErrorResponse{}},
+					503: {ErrorResponse{}},
+					504: {ErrorResponse{}},
+				},
+				ContentType: "text/event-stream",
+			},
+		},
+		{
+			Name:    "healthz",
+			Paths:   []string{"/healthz"},
+			Handler: healthzHandler,
+			Operation: apiOperation{
+				Method:    "GET",
+				Summary:   "进程存活检查，不访问上游",
+				Responses: map[int][]interface{}{200: {HealthResponse{}}},
+			},
+		},
+		{
+			Name:    "readyz",
+			Paths:   []string{"/readyz"},
+			Handler: readyzHandler,
+			Operation: apiOperation{
+				Method:    "GET",
+				Summary:   "就绪检查，探测上游是否可用 (结果按 upstream.ready_probe_ttl 缓存)",
+				Responses: map[int][]interface{}{200: {HealthResponse{}}, 503: {HealthResponse{}}},
+			},
+		},
+		{
+			Name:    "upstream_status",
+			Paths:   []string{"/api/upstream/status"},
+			Handler: upstreamStatusHandler,
+			Operation: apiOperation{
+				Method:    "GET",
+				Summary:   "各上游接口最近 5 分钟的错误率和耗时",
+				Params:    []apiParam{langParam},
+				Responses: map[int][]interface{}{200: {UpstreamStatusResponse{}}},
+			},
+		},
+		{
+			Name:    "admin_config",
+			Paths:   []string{"/api/admin/config"},
+			Handler: adminConfigHandler,
+			Operation: apiOperation{
+				Method:  "GET",
+				Summary: "当前生效的配置 (密钥已脱敏)，需要 Authorization: Bearer <admin.token>",
+				Params:  []apiParam{langParam},
+				Responses: map[int][]interface{}{
+					200: {Config{}},
+					401: {ErrorResponse{}},
+					403: {ErrorResponse{}},
+				},
+			},
+		},
+		{
+			Name:    "metrics",
+			Paths:   []string{"/metrics"},
+			Handler: metricsHandler,
+			Operation: apiOperation{
+				Method:      "GET",
+				Summary:     "Prometheus 文本格式的指标；配置了 auth.keys 时需要 Authorization: Bearer <admin.token>",
+				Responses:   map[int][]interface{}{200: {""}, 401: {ErrorResponse{}}, 403: {ErrorResponse{}}},
+				ContentType: "text/plain",
+			},
+		},
+		{
+			Name:    "openapi",
+			Paths:   []string{"/openapi.json"},
+			Handler: openAPIHandler,
+			Operation: apiOperation{
+				Method:    "GET",
+				Summary:   "本规范",
+				Responses: map[int][]interface{}{200: {map[string]interface{}{}}},
+			},
+		},
+		{
+			Name:    "lrclib_get",
+			Paths:   []string{"/api/get"},
+			Handler: lrclibGetHandler,
+			Operation: apiOperation{
+				Method:  "GET",
+				Summary: "LRCLIB 兼容：按元数据获取歌词",
+				Params: []apiParam{
+					{Name: "track_name", Type: "string", Required: true},
+					{Name: "artist_name", Type: "string", Required: true},
+					{Name: "album_name", Type: "string"},
+					{Name: "duration", Type: "integer", Description: "时长 (秒)"},
+				},
+				Responses: map[int][]interface{}{
+					200: {LrclibRecord{}},
+					304: nil,
+					404: {LrclibError{}},
+				},
+			},
+		},
+		{
+			Name:    "lrclib_search",
+			Paths:   []string{"/api/search"},
+			Handler: lrclibSearchHandler,
+			Operation: apiOperation{
+				Method:  "GET",
+				Summary: "LRCLIB 兼容：搜索歌词 (最多 5 条结果)",
+				Params: []apiParam{
+					{Name: "q", Type: "string"},
+					{Name: "track_name", Type: "string"},
+					{Name: "artist_name", Type: "string"},
+					{Name: "album_name", Type: "string"},
+				},
+				Responses: map[int][]interface{}{
+					200: {[]LrclibRecord{}},
+					304: nil,
+					400: {LrclibError{}},
+					429: {LrclibError{}},
+				},
+			},
+		},
+		{
+			Name:    "subsonic_lyrics",
+			Paths:   []string{"/rest/getLyricsBySongId", "/rest/getLyricsBySongId.view"},
+			Handler: subsonicLyricsHandler,
+			Operation: apiOperation{
+				Method:  "GET",
+				Summary: "OpenSubsonic 兼容：结构化歌词 (f=json 时包在 subsonic-response 中)",
+				Params: []apiParam{
+					{Name: "id", Type: "string", Required: true, Description: "歌曲 ID 或 MID"},
+					{Name: "f", Type: "string", Description: "json 或 xml (默认)"},
+				},
+				Responses: map[int][]interface{}{
+					200: {SubsonicResponse{}},
+					304: nil,
+				},
+			},
+		},
+		{
+			Name:    "subsonic_extensions",
+			Paths:   []string{"/rest/getOpenSubsonicExtensions", "/rest/getOpenSubsonicExtensions.view"},
+			Handler: subsonicExtensionsHandler,
+			Operation: apiOperation{
+				Method:  "GET",
+				Summary: "OpenSubsonic 兼容：支持的扩展列表",
+				Params: []apiParam{
+					{Name: "f", Type: "string", Description: "json 或 xml (默认)"},
+				},
+				Responses: map[int][]interface{}{
+					200: {SubsonicResponse{}},
+				},
+			},
+		},
+	}
+
+	routesByPath = make(map[string]*apiRoute)
+	for _, route := range apiRoutes {
+		for _, path := range route.Paths {
+			routesByPath[path] = route
+		}
+	}
 }
 
-func writeErrorJSON(w http.ResponseWriter, code int, message string, details string) {
-	resp := ErrorResponse{
-		Code:    code,
-		Message: message,
-		Details: details,
+// matchRoute 按路径查找路由，未登记的路径由歌词接口处理
+func matchRoute(path string) *apiRoute {
+	if route, ok := routesByPath[strings.TrimSuffix(path, "/")]; ok {
+		return route
 	}
-	renderJSON(w, code, resp)
-	logError("返回错误响应: [%d] %s - %s", code, message, details)
+	return lyricRoute
 }
 
-func lyricHandler(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
-	query := r.URL.Query()
-	id := query.Get("id")
-	mid := query.Get("mid")
-	word := query.Get("word")
-	nStr := query.Get("n")
+// openAPISchemaBuilder 通过反射将 Go 类型转换为 JSON Schema，具名结构体放入 components.schemas
+type openAPISchemaBuilder struct {
+	schemas map[string]interface{}
+}
 
-	logInfo("收到请求: %s %s (ID=%s, MID=%s, Word=%s, n=%s)", r.Method, r.URL.Path, id, mid, word, nStr)
+func (b *openAPISchemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(json.Number("")):
+		return map[string]interface{}{"type": []string{"integer", "string"}}
+	case reflect.TypeOf(json.RawMessage(nil)):
+		return map[string]interface{}{}
+	case reflect.TypeOf(ErrorCode("")):
+		return map[string]interface{}{"type": "string", "enum": errorCodes}
+	case reflect.TypeOf(configDuration(0)):
+		return map[string]interface{}{"type": "string", "description": "时长，如 10s、5m"}
+	}
 
-	// --- 辅助函数：构建统一的响应 ---
-	buildResponse := func(song, singer, album string, data *LyricData) UnifiedLyricResponse {
-		resp := UnifiedLyricResponse{
-			Code:    200,
-			Message: "请求成功",
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schema(t.Elem())
+		if ref, ok := s["$ref"]; ok {
+			return map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"$ref": ref}, map[string]interface{}{"type": "null"}}}
+		}
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			b.schemas[t.Name()] = nil // 占位，防止递归类型无限展开
+			b.schemas[t.Name()] = b.structSchema(t)
 		}
-		resp.Data.Song = song
-		resp.Data.Singer = singer
-		resp.Data.Album = album
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
 
-		// 1. 原始 LRC (合并翻译)
-		resp.Data.LRC = mergeLrcWithTranslation(data.Data.Lrc, data.Data.Trans)
+func (b *openAPISchemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	b.collectFields(t, properties, &required)
 
-		// 2. 增强型 LRC (ESLRC) 和 TTML
-		if data.Data.Yrc != "" {
-			ttml, err := convertYrcToTtml(data)
-			if err == nil {
-				resp.Data.TTML = ttml
-			} else {
-				logError("TTML转换失败: %v", err)
-			}
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
 
-			eslrc, err := convertYrcToEnhancedLrc(data.Data.Yrc, data.Data.Lrc, data.Data.Trans, data.Data.Roma)
-			if err == nil {
-				resp.Data.ESLRC = eslrc
-			} else {
-				logError("增强LRC转换失败: %v", err)
-			}
+// collectFields 按 encoding/json 的规则收集字段，匿名嵌入的结构体字段展开到外层
+func (b *openAPISchemaBuilder) collectFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.collectFields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
 		}
+		properties[name] = b.schema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
 
-		return resp
+func (b *openAPISchemaBuilder) oneOf(types []interface{}) map[string]interface{} {
+	if len(types) == 1 {
+		return b.schema(reflect.TypeOf(types[0]))
+	}
+	var variants []interface{}
+	for _, t := range types {
+		variants = append(variants, b.schema(reflect.TypeOf(t)))
 	}
+	return map[string]interface{}{"oneOf": variants}
+}
 
-	// --- 逻辑分支 1: 按关键字搜索 ---
-	if word != "" {
-		n, _ := strconv.Atoi(nStr)
+// buildOpenAPISpec 生成 OpenAPI 3.1 规范
+func buildOpenAPISpec() map[string]interface{} {
+	builder := &openAPISchemaBuilder{schemas: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+
+	for _, route := range apiRoutes {
+		op := route.Operation
+		var params []interface{}
+		for _, p := range op.Params {
+			param := map[string]interface{}{
+				"name":     p.Name,
+				"in":       "query",
+				"required": p.Required,
+				"schema":   map[string]interface{}{"type": p.Type},
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
 
-		// Step 1: 搜索歌曲
-		songs, err := searchSongs(word, 10)
-		if err != nil {
-			writeErrorJSON(w, http.StatusBadGateway, "搜索歌曲失败", err.Error())
-			return
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		statuses := make(map[int][]interface{}, len(op.Responses)+3)
+		for status, types := range op.Responses {
+			statuses[status] = types
+		}
+		addError := func(status int) {
+			for _, t := range statuses[status] {
+				if _, ok := t.(ErrorResponse); ok {
+					return
+				}
+			}
+			statuses[status] = append(statuses[status], ErrorResponse{})
+		}
+		if !authExemptRoutes[route.Name] {
+			// 配置了 auth.keys 时所有非豁免路由都可能拒绝请求
+			addError(http.StatusUnauthorized)
+			addError(http.StatusTooManyRequests)
+		}
+		if !configExemptRoutes[route.Name] {
+			addError(http.StatusServiceUnavailable)
+		}
+		responses := make(map[string]interface{})
+		for status, types := range statuses {
+			ct := contentType
+			if status >= 400 {
+				ct = "application/json"
+			}
+			response := map[string]interface{}{"description": http.StatusText(status)}
+			if len(types) > 0 {
+				response["content"] = map[string]interface{}{
+					ct: map[string]interface{}{"schema": builder.oneOf(types)},
+				}
+			}
+			if !authExemptRoutes[route.Name] && status != http.StatusUnauthorized && status != http.StatusServiceUnavailable {
+				response["headers"] = rateLimitHeaders
+			}
+			responses[strconv.Itoa(status)] = response
 		}
 
-		// Case 1: 仅搜索，不选择 (n=0 或 n 未提供)
-		if n <= 0 {
-			logInfo("返回 '%s' 的精简搜索结果", word)
-			resp := SearchResponse{
-				Code:    200,
-				Message: "请求成功，请通过 n 参数选择歌曲获取歌词",
-				Data:    songs,
+		operation := map[string]interface{}{
+			"summary":   op.Summary,
+			"responses": responses,
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": builder.schema(reflect.TypeOf(op.Body))},
+				},
 			}
-			renderJSON(w, http.StatusOK, resp)
-			return
 		}
 
-		// Case 2: 搜索并选择第 n 首歌
-		if len(songs) < n {
-			writeErrorJSON(w, http.StatusNotFound, "歌曲索引超出范围", fmt.Sprintf("搜索 '%s' 只找到 %d 首歌", word, len(songs)))
-			return
+		item, _ := paths[route.Paths[0]].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[route.Paths[0]] = item
 		}
+		item[strings.ToLower(op.Method)] = operation
+	}
 
-		song := songs[n-1]
-		logInfo("已选择第 %d 首歌: %s - %s", n, song.Song, song.Singer)
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "Lyric API",
+			"description": "腾讯音乐歌词代理和转换服务，支持 LRC、ESLRC、TTML 格式",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": builder.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKeyHeader": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"apiKeyQuery":  map[string]interface{}{"type": "apiKey", "in": "query", "name": "api_key"},
+			},
+		},
+		// 是否需要 API Key 取决于部署配置
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"apiKeyHeader": []string{}},
+			map[string]interface{}{"apiKeyQuery": []string{}},
+		},
+	}
+}
 
-		// Step 2: 获取歌词数据
-		data, _, err := fetchLyricData("", song.MID)
-		if err != nil {
-			writeErrorJSON(w, http.StatusBadGateway, "获取歌词失败", err.Error())
-			return
-		}
+var (
+	openAPISpecOnce sync.Once
+	openAPISpec     map[string]interface{}
+)
 
-		if data.Code != 200 {
-			writeErrorJSON(w, http.StatusNotFound, "未找到歌词", data.Message)
-			return
-		}
+// openAPIHandler 输出 /openapi.json
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPISpecOnce.Do(func() {
+		openAPISpec = buildOpenAPISpec()
+	})
+	renderJSON(w, http.StatusOK, openAPISpec)
+}
 
-		// Step 3: 构建并发送响应
-		resp := buildResponse(song.Song, song.Singer, song.Album, data)
-		renderJSON(w, http.StatusOK, resp)
-		logInfo("请求处理完成 (搜索+转换), 耗时: %v", time.Since(startTime))
+// Handler 是 Vercel 的入口函数
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Language")
+	if applyCORS(w, r) {
 		return
 	}
 
-	// --- 逻辑分支 2: 按 ID/MID 获取 ---
-	if id != "" || mid != "" {
-		data, rawJSON, err := fetchLyricData(id, mid)
-		if err != nil {
-			writeErrorJSON(w, http.StatusBadGateway, "获取上游数据失败", err.Error())
-			return
-		}
+	route := requestRoute(r)
+	reqID := requestID(r)
+	w.Header().Set("X-Request-ID", reqID)
 
-		if data.Code != 200 {
-			logError("上游返回错误: Code=%d", data.Code)
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.WriteHeader(http.StatusFailedDependency)
-			w.Write(rawJSON)
-			return
+	ctx, span := startRootSpan(r, r.Method+" "+route)
+	span.setAttr("http.request.method", r.Method)
+	span.setAttr("http.route", route)
+	span.setAttr("url.path", r.URL.Path)
+	span.setAttr("request_id", reqID)
+	ctx = withLogAttrs(ctx, "request_id", reqID, "route", route, "trace_id", span.TraceID)
+	r = r.WithContext(ctx)
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	startTime := time.Now()
+	defer func() {
+		elapsed := time.Since(startTime)
+		httpRequests.inc(route, strconv.Itoa(rec.status))
+		httpDuration.observe(elapsed.Seconds(), route)
+		logInfo(ctx, "请求完成", "method", r.Method, "path", r.URL.Path, "status", rec.status, "duration_ms", elapsed.Milliseconds())
+
+		span.setAttr("http.response.status_code", rec.status)
+		if rec.status >= 500 {
+			span.Status = spanStatusError
 		}
+		span.finish(nil)
+		flushTrace(span)
+	}()
+	w = rec
 
-		// 解析元数据填充歌曲信息
-		meta := parseLrcMeta(data.Data.Lrc)
-		songTitle := meta["ti"]
-		singer := meta["ar"]
-		album := meta["al"]
+	w, closeCompression := newCompressWriter(w, r)
+	defer closeCompression()
 
-		resp := buildResponse(songTitle, singer, album, data)
-		renderJSON(w, http.StatusOK, resp)
-		logInfo("请求处理完成 (ID/MID转换), 耗时: %v", time.Since(startTime))
+	if configErr != nil && !configExemptRoutes[route] {
+		writeErrorJSON(w, r, http.StatusServiceUnavailable, ErrCodeConfigInvalid, "服务配置无效", configErr.Error())
 		return
 	}
 
-	// --- 逻辑分支 3: 参数错误 ---
-	writeErrorJSON(w, http.StatusBadRequest, "缺少参数", "请提供 'id', 'mid' 或 'word' 参数")
-}
-
-// Handler 是 Vercel 的入口函数
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// CORS 设置
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	r, ok := authorizeRequest(w, r, route)
+	if !ok {
 		return
 	}
 
-	lyricHandler(w, r)
+	matchRoute(r.URL.Path).Handler(w, r)
 }
@@ -0,0 +1,3118 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// testLrc 带多个元数据标签，用于检查输出顺序是否稳定
+const testLrc = "[ti:测试歌曲]\n[ar:歌手]\n[al:专辑]\n[by:制作]\n[offset:0]\n[re:tool]\n[ve:1.0]\n[kana:1い]\n[00:01.00]hello\n[00:05.00]world\n"
+
+const testYrc = "[1000,2000]hel(1000,1000)lo(2000,1000)\n[5000,2000]wor(5000,1000)ld(6000,1000)\n"
+
+// newTestUpstream 启动模拟的上游接口并把 config.Upstream.BaseURL 指向它，测试结束后恢复配置
+func newTestUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lyric", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id") + r.URL.Query().Get("mid")
+		if id == "404" {
+			fmt.Fprint(w, `{"code":404,"message":"not found"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"code":200,"message":"ok","data":{"lrc":%q,"trans":"","yrc":%q,"roma":""}}`, testLrc, testYrc)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":200,"message":"ok","data":[{"id":1,"mid":"m1","song":"Hello","singer":"Artist","album":"Alb","interval":215},{"id":2,"mid":"m2","song":"Other","singer":"X","album":"Y","interval":200}]}`)
+	})
+	srv := httptest.NewServer(mux)
+
+	saved := config
+	config.Upstream.BaseURL = srv.URL
+	t.Cleanup(func() {
+		srv.Close()
+		config = saved
+	})
+	return srv
+}
+
+// serve 直接调用 Handler，hdr 为成对的请求头名称和值
+func serve(method, target string, body string, hdr ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	w := httptest.NewRecorder()
+	Handler(w, req)
+	return w
+}
+
+// --- HTTP 缓存 ---
+
+func TestETagStableAcrossRequests(t *testing.T) {
+	newTestUpstream(t)
+
+	first := serve("GET", "/v2/music/tencent/lyric?id=1", "")
+	if first.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", first.Code, first.Body.String())
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("缺少 ETag")
+	}
+	for i := 0; i < 20; i++ {
+		if got := serve("GET", "/v2/music/tencent/lyric?id=1", "").Header().Get("ETag"); got != etag {
+			t.Fatalf("第 %d 次请求 ETag = %s，期望 %s", i+2, got, etag)
+		}
+	}
+
+	w := serve("GET", "/v2/music/tencent/lyric?id=1", "", "If-None-Match", etag)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("If-None-Match 命中时 status = %d，期望 304", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("304 响应不应有响应体: %q", w.Body.String())
+	}
+	if got := w.Header().Get("ETag"); got != etag {
+		t.Errorf("304 的 ETag = %s，期望 %s", got, etag)
+	}
+}
+
+func TestEnhancedLrcMetaOrder(t *testing.T) {
+	parsed := &ParsedLyric{Meta: map[string]string{"ve": "1", "al": "专辑", "ti": "歌名", "kana": "1い", "ar": "歌手", "re": "x"}}
+	out, err := convertYrcToEnhancedLrc(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[ti:歌名]\n[ar:歌手]\n[al:专辑]\n[re:x]\n[ve:1]\n"
+	if out != want {
+		t.Errorf("输出 = %q，期望 %q", out, want)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header []string
+		want   bool
+	}{
+		{"无条件请求", "GET", nil, false},
+		{"ETag 相同", "GET", []string{"If-None-Match", `"abc"`}, true},
+		{"弱 ETag", "GET", []string{"If-None-Match", `W/"abc"`}, true},
+		{"列表中包含", "GET", []string{"If-None-Match", `"x", "abc"`}, true},
+		{"通配", "GET", []string{"If-None-Match", "*"}, true},
+		{"ETag 不同", "GET", []string{"If-None-Match", `"other"`}, false},
+		{"忽略 If-Modified-Since", "GET", []string{"If-Modified-Since", "Sun, 01 Jan 2090 00:00:00 GMT"}, false},
+		{"POST 不适用", "POST", []string{"If-None-Match", `"abc"`}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for i := 0; i+1 < len(tt.header); i += 2 {
+				req.Header.Set(tt.header[i], tt.header[i+1])
+			}
+			if got := notModified(req, `"abc"`); got != tt.want {
+				t.Errorf("notModified = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachePolicyHeader(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	policy := CachePolicy{MaxAge: configDuration(60e9), SMaxAge: configDuration(600e9), StaleWhileRevalidate: configDuration(3600e9)}
+	if got, want := policy.header(), "public, max-age=60, s-maxage=600, stale-while-revalidate=3600"; got != want {
+		t.Errorf("header = %q，期望 %q", got, want)
+	}
+	if got := (CachePolicy{}).header(); got != "no-cache" {
+		t.Errorf("全部为 0 时 header = %q，期望 no-cache", got)
+	}
+
+	// 启用 API Key 后不允许 CDN 缓存
+	config.Auth.Keys = []APIKeyConfig{{Name: "a", Key: "k", Rate: 1, Burst: 1}}
+	if got, want := policy.header(), "private, max-age=60, stale-while-revalidate=3600"; got != want {
+		t.Errorf("启用认证时 header = %q，期望 %q", got, want)
+	}
+}
+
+// --- 播放时间轴 ---
+
+// describePosition 把 PositionData 压缩成 "line=行 word=行.字 next=下一行 change=下次变化" 便于比较，没有时为 -
+func describePosition(p PositionData) string {
+	line, word, next, change := "-", "-", "-", "-"
+	if p.Line != nil {
+		line = fmt.Sprint(p.Line.Index)
+	}
+	if p.Word != nil {
+		word = fmt.Sprintf("%d.%d", p.Word.Line, p.Word.Index)
+	}
+	if p.NextLine != nil {
+		next = fmt.Sprint(p.NextLine.Index)
+	}
+	if p.NextChange != nil {
+		change = fmt.Sprintf("%d+%d", *p.NextChange, *p.UntilChange)
+	}
+	return fmt.Sprintf("line=%s word=%s next=%s change=%s", line, word, next, change)
+}
+
+func TestLookupPosition(t *testing.T) {
+	// 输入顺序打乱，newLyricTimeline 按开始时间排序；第 0 行的 EndTime 晚于最后一个字，以字为准
+	timeline := newLyricTimeline(&ParsedLyric{
+		Lines: []*LineInfo{
+			{StartTime: 5000, EndTime: 6000, Words: lintWords("c@5000+1000")},
+			{StartTime: 1000, EndTime: 4000, Words: lintWords("a@1000+500", "b@1600+400")},
+		},
+		Translations: []MetaLine{{1000, "甲乙"}, {5000, "丙"}},
+	})
+
+	tests := []struct {
+		name string
+		pos  int
+		want string
+	}{
+		{"第一行之前", 0, "line=- word=- next=0 change=1000+1000"},
+		{"第一行之前 1ms", 999, "line=- word=- next=0 change=1000+1"},
+		{"行开始", 1000, "line=0 word=0.0 next=1 change=1500+500"},
+		{"字间空白", 1550, "line=0 word=- next=1 change=1600+50"},
+		{"最后一个字", 1999, "line=0 word=0.1 next=1 change=2000+1"},
+		{"行内容结束后进入行间空白", 2000, "line=- word=- next=1 change=5000+3000"},
+		{"行 EndTime 之前仍按空白处理", 3500, "line=- word=- next=1 change=5000+1500"},
+		{"最后一行", 5500, "line=1 word=1.0 next=- change=6000+500"},
+		{"歌词结束", 6000, "line=- word=- next=- change=-"},
+		{"结束之后", 99999, "line=- word=- next=- change=-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lookupPosition(timeline, tt.pos)
+			if desc := describePosition(got); desc != tt.want {
+				t.Errorf("t=%d: %s，期望 %s", tt.pos, desc, tt.want)
+			}
+			if got.Position != tt.pos || got.Duration != 6000 {
+				t.Errorf("t=%d: position=%d duration=%d", tt.pos, got.Position, got.Duration)
+			}
+		})
+	}
+
+	data := lookupPosition(timeline, 1000)
+	if data.Line.Text != "ab" || data.Line.End != 2000 || data.Line.Translation != "甲乙" || data.NextLine.Translation != "丙" {
+		t.Errorf("行内容不符: line=%+v next=%+v", data.Line, data.NextLine)
+	}
+	if data.Word.Text != "a" || data.Word.Start != 1000 || data.Word.End != 1500 {
+		t.Errorf("字内容不符: %+v", data.Word)
+	}
+}
+
+// resetTimelineCache 清空时间轴缓存，测试结束后恢复
+func resetTimelineCache(t *testing.T) {
+	timelineCacheMu.Lock()
+	saved := timelineCache
+	timelineCache = make(map[string]timelineCacheEntry)
+	timelineCacheMu.Unlock()
+	t.Cleanup(func() {
+		timelineCacheMu.Lock()
+		timelineCache = saved
+		timelineCacheMu.Unlock()
+	})
+}
+
+// expireTimeline 把缓存条目的过期时间设为 now+d
+func expireTimeline(key string, d time.Duration) {
+	timelineCacheMu.Lock()
+	defer timelineCacheMu.Unlock()
+	entry := timelineCache[key]
+	entry.expiresAt = time.Now().Add(d)
+	timelineCache[key] = entry
+}
+
+func cachedTimelineKeys() string {
+	timelineCacheMu.Lock()
+	defer timelineCacheMu.Unlock()
+	var keys []string
+	for key := range timelineCache {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
+
+func TestGetTimelineCache(t *testing.T) {
+	var fetches atomic.Int32
+	newBatchUpstream(t, func(r *http.Request) { fetches.Add(1) })
+	resetTimelineCache(t)
+	config.Cache.TimelineTTL = configDuration(time.Minute)
+	config.Cache.TimelineMaxSize = 2
+	ctx := context.Background()
+
+	hits, misses := counterValue(cacheRequests, "timeline", "hit"), counterValue(cacheRequests, "timeline", "miss")
+	first, lerr := getTimeline(ctx, "1", "")
+	if lerr != nil {
+		t.Fatal(lerr.Details)
+	}
+	second, _ := getTimeline(ctx, "1", "")
+	if first != second || fetches.Load() != 1 {
+		t.Errorf("TTL 内应命中缓存: 上游请求 %d 次", fetches.Load())
+	}
+	if counterValue(cacheRequests, "timeline", "hit")-hits != 1 || counterValue(cacheRequests, "timeline", "miss")-misses != 1 {
+		t.Errorf("缓存命中/未命中计数不符")
+	}
+
+	// id 和 mid 使用不同的键
+	getTimeline(ctx, "", "1")
+	if fetches.Load() != 2 || cachedTimelineKeys() != "id:1 mid:1" {
+		t.Errorf("mid 应单独缓存: 上游请求 %d 次，缓存 %s", fetches.Load(), cachedTimelineKeys())
+	}
+
+	// 过期后重新获取
+	expireTimeline("id:1", -time.Second)
+	if third, _ := getTimeline(ctx, "1", ""); third == first || fetches.Load() != 3 {
+		t.Errorf("过期后应重新获取: 上游请求 %d 次", fetches.Load())
+	}
+
+	// 已满时先清理过期条目
+	expireTimeline("mid:1", -time.Second)
+	getTimeline(ctx, "2", "")
+	if keys := cachedTimelineKeys(); keys != "id:1 id:2" {
+		t.Errorf("应淘汰已过期的 mid:1，缓存 %s", keys)
+	}
+
+	// 没有过期条目时淘汰最早过期的一条
+	expireTimeline("id:2", 10*time.Second)
+	getTimeline(ctx, "3", "")
+	if keys := cachedTimelineKeys(); keys != "id:1 id:3" {
+		t.Errorf("应淘汰最早过期的 id:2，缓存 %s", keys)
+	}
+
+	// 错误不缓存
+	before := fetches.Load()
+	for i := 0; i < 2; i++ {
+		if _, lerr := getTimeline(ctx, "404", ""); lerr == nil || lerr.Code != ErrCodeLyricNotFound {
+			t.Fatalf("id=404 应返回 LYRIC_NOT_FOUND: %+v", lerr)
+		}
+	}
+	if fetches.Load()-before != 2 || strings.Contains(cachedTimelineKeys(), "404") {
+		t.Errorf("错误结果不应缓存: 上游请求 %d 次，缓存 %s", fetches.Load()-before, cachedTimelineKeys())
+	}
+}
+
+// --- 实时歌词同步 ---
+
+// sseEvents 解析 SSE 响应体中的事件名和数据
+func sseEvents(body string) (names []string, data []string) {
+	for _, block := range strings.Split(body, "\n\n") {
+		var name, payload string
+		for _, line := range strings.Split(block, "\n") {
+			if strings.HasPrefix(line, "event: ") {
+				name = strings.TrimPrefix(line, "event: ")
+			} else if strings.HasPrefix(line, "data: ") {
+				payload = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		if name != "" {
+			names = append(names, name)
+			data = append(data, payload)
+		}
+	}
+	return names, data
+}
+
+func TestLiveStreamsToEnd(t *testing.T) {
+	newTestUpstream(t)
+
+	w := serve("GET", "/api/live?id=1&pos=6950", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	names, data := sseEvents(w.Body.String())
+	// 最后一个字结束后进入空白 (clear)，再到达歌词结尾 (end)
+	if got := strings.Join(names, ","); got != "start,line,word,clear,end" {
+		t.Fatalf("事件 = %s，期望 start,line,word,clear,end", got)
+	}
+	if !strings.Contains(data[len(data)-1], `"reason":"finished"`) {
+		t.Errorf("end 事件 = %s，期望 reason=finished", data[len(data)-1])
+	}
+}
+
+func TestLiveTimeoutCarriesResumePosition(t *testing.T) {
+	newTestUpstream(t)
+	config.Live.MaxDuration = configDuration(20 * time.Millisecond)
+
+	// 极慢的速率下位置几乎不变，到期后 end 事件应给出当前位置供重新连接
+	w := serve("GET", "/api/live?id=1&pos=5500&rate=0.001", "")
+	names, data := sseEvents(w.Body.String())
+	if len(names) == 0 || names[len(names)-1] != "end" {
+		t.Fatalf("事件 = %v，期望以 end 结束", names)
+	}
+	last := data[len(data)-1]
+	if !strings.Contains(last, `"position":5500`) || !strings.Contains(last, `"reason":"timeout"`) {
+		t.Errorf("end 事件 = %s，期望 position=5500、reason=timeout", last)
+	}
+}
+
+func TestLivePausedEndsImmediately(t *testing.T) {
+	newTestUpstream(t)
+	config.Live.MaxDuration = configDuration(time.Minute)
+
+	start := time.Now()
+	w := serve("GET", "/api/live?id=1&pos=5500&paused=1", "")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("暂停的连接持续了 %v，应立即结束", elapsed)
+	}
+	names, data := sseEvents(w.Body.String())
+	if got := strings.Join(names, ","); got != "start,line,word,end" {
+		t.Fatalf("事件 = %s，期望 start,line,word,end", got)
+	}
+	if last := data[len(data)-1]; !strings.Contains(last, `"position":5500`) || !strings.Contains(last, `"reason":"paused"`) {
+		t.Errorf("end 事件 = %s，期望 position=5500、reason=paused", last)
+	}
+}
+
+func TestApplyLiveControl(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+	pos := func(v int) *int { return &v }
+	tests := []struct {
+		name   string
+		paused bool
+		ctl    LiveControl
+		want   string // "位置 速率 暂停 跳变"，或 "error"
+	}{
+		{"seek", false, LiveControl{Type: "seek", Position: pos(5000)}, "5000 1 false true"},
+		{"seek 补偿延迟", false, LiveControl{Type: "seek", Position: pos(5000), At: now.UnixMilli() - 200}, "5200 1 false true"},
+		{"seek 缺少 position", false, LiveControl{Type: "seek"}, "error"},
+		{"position 为负", false, LiveControl{Type: "seek", Position: pos(-1)}, "error"},
+		{"暂停在当前位置", false, LiveControl{Type: "pause"}, "1000 1 true false"},
+		{"暂停在指定位置", false, LiveControl{Type: "pause", Position: pos(3000), At: now.UnixMilli() - 200}, "3000 1 true true"},
+		{"恢复播放", true, LiveControl{Type: "resume"}, "1000 1 false false"},
+		{"恢复到指定位置并补偿延迟", true, LiveControl{Type: "resume", Position: pos(3000), At: now.UnixMilli() - 200}, "3200 1 false true"},
+		{"变速", false, LiveControl{Type: "rate", Rate: 2}, "1000 2 false false"},
+		{"暂停时变速", true, LiveControl{Type: "rate", Rate: 0.5}, "1000 0.5 true false"},
+		{"速率为 0", false, LiveControl{Type: "rate"}, "error"},
+		{"未知类型", false, LiveControl{Type: "jump", Position: pos(1)}, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &playbackClock{basePos: 1000, baseTime: now, rate: 1, paused: tt.paused}
+			before := *clock
+			moved, err := applyLiveControl(clock, tt.ctl, now)
+			if tt.want == "error" {
+				if err == nil || *clock != before {
+					t.Errorf("应返回错误且不修改时钟: err=%v clock=%+v", err, *clock)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(clock.position(now), clock.rate, clock.paused, moved); got != tt.want {
+				t.Errorf("得到 %s，期望 %s", got, tt.want)
+			}
+			// 时钟以 now 为新的参考点，之后按新的速率推进
+			if !clock.paused {
+				if got, want := clock.position(now.Add(time.Second)), clock.position(now)+int(1000*clock.rate); got != want {
+					t.Errorf("1 秒后位置 %d，期望 %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+// wsTestClient 测试用的 WebSocket 客户端，发送的帧加掩码
+type wsTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialLive 以 WebSocket 连接 srv 上的 /api/live
+func dialLive(t *testing.T, srv *httptest.Server, query string) *wsTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET /api/live?%s HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", query)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 6455 第 1.3 节的示例
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("握手失败: %s %v", resp.Status, resp.Header)
+	}
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Errorf("101 响应应带上已设置的响应头: %v", resp.Header)
+	}
+	return &wsTestClient{t: t, conn: conn, br: br}
+}
+
+// sendFrame 发送一个加掩码的帧，fin 为 false 时表示消息未结束
+func (c *wsTestClient) sendFrame(fin bool, op byte, payload string) {
+	c.t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	head := []byte{op, 0x80 | byte(len(payload))}
+	if fin {
+		head[0] |= 0x80
+	}
+	frame := append(head, mask[:]...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// readFrame 读取服务端的一个帧
+func (c *wsTestClient) readFrame() (op byte, payload []byte) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		c.t.Fatalf("读取帧失败: %v", err)
+	}
+	if head[1]&0x80 != 0 {
+		c.t.Fatal("服务端帧不应加掩码")
+	}
+	size := int(head[1] & 0x7F)
+	if size == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		size = int(ext[0])<<8 | int(ext[1])
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatalf("读取帧失败: %v", err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+// next 读取下一条事件，返回 "事件名 数据"
+func (c *wsTestClient) next() string {
+	c.t.Helper()
+	op, payload := c.readFrame()
+	if op != wsOpText {
+		c.t.Fatalf("期望文本消息，得到操作码 %d: %q", op, payload)
+	}
+	var msg struct {
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		c.t.Fatalf("消息不是 JSON: %s", payload)
+	}
+	return msg.Event + " " + string(msg.Data)
+}
+
+// expect 依次读取事件，检查事件名并且数据包含 want 中的片段 ("事件名 片段")
+func (c *wsTestClient) expect(want ...string) {
+	c.t.Helper()
+	for _, w := range want {
+		name, fragment, _ := strings.Cut(w, " ")
+		got := c.next()
+		if !strings.HasPrefix(got, name+" ") || !strings.Contains(got, fragment) {
+			c.t.Fatalf("得到 %s，期望 %s", got, w)
+		}
+	}
+}
+
+func TestLiveWebSocketControl(t *testing.T) {
+	newTestUpstream(t)
+	config.Live.MaxDuration = configDuration(20 * time.Millisecond) // WebSocket 不受此限制
+	srv := httptest.NewServer(http.HandlerFunc(Handler))
+	defer srv.Close()
+
+	// 歌词: hello 1000-3000 (hel 1000-2000, lo 2000-3000)，world 5000-7000 (wor 5000-6000, ld 6000-7000)
+	c := dialLive(t, srv, "id=1&pos=1500&paused=1")
+	c.expect(`start "position":1500`, `line "text":"hello"`, `word "text":"hel"`)
+
+	// 暂停中 seek：位置跳变，重新推送当前行和字
+	c.sendFrame(true, wsOpText, `{"type":"seek","position":5500}`)
+	c.expect(`state {"position":5500,"rate":1,"paused":true}`, `line "text":"world"`, `word "text":"wor"`)
+
+	// 分片发送的消息和穿插其中的 ping
+	c.sendFrame(false, wsOpText, `{"type":"rate",`)
+	c.sendFrame(true, wsOpPing, "p")
+	c.sendFrame(true, wsOpContinuation, `"rate":4}`)
+	if op, payload := c.readFrame(); op != wsOpPong || string(payload) != "p" {
+		t.Fatalf("期望 pong，得到 %d %q", op, payload)
+	}
+	c.expect(`state {"position":5500,"rate":4,"paused":true}`)
+
+	// 恢复后以 4 倍速播放到结尾，连接保持打开
+	c.sendFrame(true, wsOpText, `{"type":"resume"}`)
+	c.expect(`state "paused":false`, `word "text":"ld"`, `clear`, `end "reason":"finished"`)
+
+	// 无效的控制消息返回 error 事件，不影响连接
+	c.sendFrame(true, wsOpText, `{"type":"rate","rate":0}`)
+	c.expect(`error "error_code":"INVALID_PARAMETER"`)
+	c.sendFrame(true, wsOpText, `not json`)
+	c.expect(`error "error_code":"INVALID_BODY"`)
+
+	// 结尾之后仍可 seek 回去
+	c.sendFrame(true, wsOpText, `{"type":"pause","position":1000}`)
+	c.expect(`state {"position":1000,"rate":4,"paused":true}`, `line "text":"hello"`, `word "text":"hel"`)
+
+	// 客户端关闭时服务端回复关闭帧
+	c.sendFrame(true, wsOpClose, "\x03\xe8")
+	if op, payload := c.readFrame(); op != wsOpClose || !bytes.HasPrefix(payload, []byte{0x03, 0xe8}) {
+		t.Errorf("期望关闭帧 1000，得到 %d %q", op, payload)
+	}
+}
+
+func TestLiveWebSocketProtocolErrors(t *testing.T) {
+	newTestUpstream(t)
+	srv := httptest.NewServer(http.HandlerFunc(Handler))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		send func(c *wsTestClient)
+		code uint16
+	}{
+		{"二进制消息", func(c *wsTestClient) { c.sendFrame(true, wsOpBinary, "x") }, wsCloseUnsupported},
+		{"没有待续的消息", func(c *wsTestClient) { c.sendFrame(true, wsOpContinuation, "x") }, wsCloseProtocolError},
+		{"未加掩码", func(c *wsTestClient) { c.conn.Write([]byte{0x81, 0x01, 'x'}) }, wsCloseProtocolError},
+		{"消息过大", func(c *wsTestClient) {
+			c.conn.Write([]byte{0x81, 0x80 | 126, 0xff, 0xff, 0, 0, 0, 0})
+		}, wsCloseTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialLive(t, srv, "id=1&paused=1")
+			c.expect("start", "clear")
+			tt.send(c)
+			op, payload := c.readFrame()
+			if op != wsOpClose || len(payload) < 2 || uint16(payload[0])<<8|uint16(payload[1]) != tt.code {
+				t.Errorf("期望关闭帧 %d，得到 %d %q", tt.code, op, payload)
+			}
+		})
+	}
+}
+
+func TestLiveWebSocketHandshakeErrors(t *testing.T) {
+	newTestUpstream(t)
+	upgrade := []string{"Upgrade", "websocket", "Connection", "Upgrade"}
+
+	w := serve("GET", "/api/live?id=1", "", upgrade...)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeInvalidParameter)) {
+		t.Errorf("缺少 Sec-WebSocket-Key: %d %s", w.Code, w.Body.String())
+	}
+
+	// httptest.ResponseRecorder 不支持接管连接，相当于不支持 WebSocket 的运行环境
+	w = serve("GET", "/api/live?id=1", "", append(upgrade, "Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==", "Sec-WebSocket-Version", "13")...)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), string(ErrCodeStreamingUnsupported)) {
+		t.Errorf("不支持接管连接: %d %s", w.Code, w.Body.String())
+	}
+
+	// 歌词错误在升级之前按普通 HTTP 响应返回
+	w = serve("GET", "/api/live?id=404", "", upgrade...)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("歌词不存在: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestApplyClockReference(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+	tests := []struct {
+		name   string
+		rate   float64
+		paused bool
+		at     int64
+		want   int
+	}{
+		{"无时间戳", 1, false, 0, 1000},
+		{"补偿 200ms 延迟", 1, false, now.UnixMilli() - 200, 1200},
+		{"按速率补偿", 2, false, now.UnixMilli() - 200, 1400},
+		{"暂停时不补偿", 1, true, now.UnixMilli() - 200, 1000},
+		{"时间戳在未来", 1, false, now.UnixMilli() + 500, 1000},
+		{"时间戳过旧", 1, false, now.UnixMilli() - 2*60*1000, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &playbackClock{rate: tt.rate, paused: tt.paused}
+			applyClockReference(clock, 1000, tt.at, now)
+			if got := clock.position(now); got != tt.want {
+				t.Errorf("position = %d，期望 %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// --- 路由和 OpenAPI ---
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	w := serve("GET", "/openapi.json", "")
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range apiRoutes {
+		item, ok := spec.Paths[route.Paths[0]]
+		if !ok {
+			t.Errorf("路由 %s 的路径 %s 不在 OpenAPI 规范中", route.Name, route.Paths[0])
+			continue
+		}
+		if _, ok := item[strings.ToLower(route.Operation.Method)]; !ok {
+			t.Errorf("路由 %s 缺少 %s 操作", route.Name, route.Operation.Method)
+		}
+	}
+	if len(spec.Paths) != len(apiRoutes) {
+		t.Errorf("规范中有 %d 个路径，路由表中有 %d 个", len(spec.Paths), len(apiRoutes))
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/healthz", "healthz"},
+		{"/healthz/", "healthz"},
+		{"/metrics", "metrics"},
+		{"/rest/getLyricsBySongId.view", "subsonic_lyrics"},
+		{"/rest/getOpenSubsonicExtensions", "subsonic_extensions"},
+		{"/v2/music/tencent/lyric", "lyric"},
+		{"/unknown", "lyric"},
+	}
+	for _, tt := range tests {
+		if got := matchRoute(tt.path).Name; got != tt.want {
+			t.Errorf("matchRoute(%q) = %s，期望 %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+// 每个路由都要在 vercel.json 中有对应的 rewrite，否则部署后无法访问
+func TestVercelRewritesCoverRoutes(t *testing.T) {
+	raw, err := os.ReadFile("../vercel.json")
+	if err != nil {
+		t.Skip("找不到 vercel.json:", err)
+	}
+	var cfg struct {
+		Rewrites []struct {
+			Source string `json:"source"`
+		} `json:"rewrites"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rewritten := func(path string) bool {
+		for _, rw := range cfg.Rewrites {
+			if i := strings.Index(rw.Source, ":"); i >= 0 {
+				if strings.HasPrefix(path, rw.Source[:i]) {
+					return true
+				}
+			} else if rw.Source == path {
+				return true
+			}
+		}
+		return false
+	}
+	for _, route := range apiRoutes {
+		for _, path := range route.Paths {
+			if !rewritten(path) {
+				t.Errorf("路由 %s 的路径 %s 没有 rewrite", route.Name, path)
+			}
+		}
+	}
+}
+
+// --- API Key 认证 ---
+
+func TestAPIKeyTake(t *testing.T) {
+	key := APIKeyConfig{Name: "take-test", Key: "k", Rate: 2, Burst: 3, DailyQuota: 5}
+	now := time.Date(2026, 1, 1, 23, 59, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		after     time.Duration // 相对上一次调用经过的时间
+		remaining int
+		code      ErrorCode
+		retry     time.Duration
+	}{
+		{"第一次消耗桶容量", 0, 2, "", 0},
+		{"突发", 0, 1, "", 0},
+		{"耗尽", 0, 0, "", 0},
+		{"桶空时限速", 0, 0, ErrCodeTooManyRequests, 500 * time.Millisecond},
+		{"按速率补充", 500 * time.Millisecond, 0, "", 0},
+		{"补充不超过容量", time.Second * 10, 2, "", 0},
+		{"当日配额用完", 0, 2, ErrCodeQuotaExceeded, 49500 * time.Millisecond},
+		{"UTC 零点重置配额", time.Minute, 2, "", 0},
+	}
+	for _, tt := range tests {
+		now = now.Add(tt.after)
+		remaining, code, retry := key.take(now)
+		if remaining != tt.remaining || code != tt.code || retry != tt.retry {
+			t.Errorf("%s: take = (%d, %q, %v)，期望 (%d, %q, %v)", tt.name, remaining, code, retry, tt.remaining, tt.code, tt.retry)
+		}
+	}
+}
+
+func TestBatchChargesPerUpstreamCall(t *testing.T) {
+	newTestUpstream(t)
+	// 请求本身消耗 1 个令牌并抵扣第一次上游调用，之后每次上游调用再消耗 1 个
+	config.Auth.Keys = []APIKeyConfig{{Name: "batch-charge-test", Key: "secret", Rate: 0.001, Burst: 3}}
+
+	w := serve("POST", "/api/batch", `{"items":[{"id":"4601"},{"id":"4602"},{"id":"4603"},{"id":"4604"}],"concurrency":1}`, "X-API-Key", "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var resp BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var codes []int
+	for _, item := range resp.Data {
+		codes = append(codes, item.Code)
+	}
+	if got := fmt.Sprint(codes); got != "[200 200 200 429]" {
+		t.Errorf("各项状态 = %s，期望 [200 200 200 429]", got)
+	}
+	if item := resp.Data[3]; item.Error == nil || item.Error.ErrorCode != ErrCodeTooManyRequests {
+		t.Errorf("第 4 项错误 = %+v，期望 TOO_MANY_REQUESTS", item.Error)
+	}
+
+	if w := serve("GET", "/v2/music/tencent/lyric?id=4605", "", "X-API-Key", "secret"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("令牌用完后 status = %d，期望 429", w.Code)
+	}
+}
+
+func TestMetricsRequiresAdminTokenWithAPIKeys(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	if w := serve("GET", "/metrics", ""); w.Code != http.StatusOK {
+		t.Errorf("未配置 API Key 时 status = %d，期望 200", w.Code)
+	}
+
+	config.Auth.Keys = []APIKeyConfig{{Name: "metrics-test", Key: "k", Rate: 1, Burst: 1}}
+	config.Admin.Token = "admin"
+	if w := serve("GET", "/metrics", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("无管理令牌时 status = %d，期望 401", w.Code)
+	}
+	if w := serve("GET", "/metrics", "", "Authorization", "Bearer admin"); w.Code != http.StatusOK {
+		t.Errorf("带管理令牌时 status = %d，期望 200", w.Code)
+	}
+}
+
+// --- 上游重试与熔断 ---
+
+func TestCircuitBreaker(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.Breaker = BreakerConfig{FailureThreshold: 2, OpenDuration: configDuration(10 * time.Second)}
+
+	start := time.Unix(1_000_000, 0)
+	steps := []struct {
+		name  string
+		at    time.Duration
+		op    string // allow | fail | ok | abandon
+		deny  bool   // allow 是否被拒绝
+		state string
+	}{
+		{"初始放行", 0, "allow", false, circuitClosed},
+		{"一次失败不熔断", 0, "fail", false, circuitClosed},
+		{"成功清零", 0, "ok", false, circuitClosed},
+		{"失败 1", 0, "fail", false, circuitClosed},
+		{"失败 2 熔断", time.Second, "fail", false, circuitOpen},
+		{"熔断期间拒绝", 5 * time.Second, "allow", true, circuitOpen},
+		{"到期后放行一个探测", 11 * time.Second, "allow", false, circuitHalfOpen},
+		{"探测期间拒绝其他请求", 11 * time.Second, "allow", true, circuitHalfOpen},
+		{"探测被调用方取消", 11 * time.Second, "abandon", false, circuitHalfOpen},
+		{"取消后允许新的探测", 11 * time.Second, "allow", false, circuitHalfOpen},
+		{"探测失败再次熔断", 12 * time.Second, "fail", false, circuitOpen},
+		{"重新计时", 21 * time.Second, "allow", true, circuitOpen},
+		{"再次探测", 22 * time.Second, "allow", false, circuitHalfOpen},
+		{"探测成功恢复", 22 * time.Second, "ok", false, circuitClosed},
+		{"恢复后放行", 22 * time.Second, "allow", false, circuitClosed},
+	}
+	b := &circuitBreaker{host: "breaker-test", state: circuitClosed}
+	for _, step := range steps {
+		now := start.Add(step.at)
+		switch step.op {
+		case "allow":
+			err := b.allow(now)
+			if (err != nil) != step.deny {
+				t.Fatalf("%s: allow = %v，期望拒绝 = %v", step.name, err, step.deny)
+			}
+		case "fail", "ok":
+			b.record(context.Background(), step.op == "fail", now)
+		case "abandon":
+			b.abandon()
+		}
+		if b.state != step.state {
+			t.Fatalf("%s: 状态 = %s，期望 %s", step.name, b.state, step.state)
+		}
+	}
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"无", "", 0, false},
+		{"秒数", "3", 3 * time.Second, true},
+		{"负数", "-1", 0, false},
+		{"HTTP 日期", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{"已过去的日期", now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"无法解析", "soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got, ok := retryAfterDelay(resp, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("retryAfterDelay = (%v, %v)，期望 (%v, %v)", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffBounds(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.Retry.InitialBackoff = configDuration(100 * time.Millisecond)
+	config.Upstream.Retry.MaxBackoff = configDuration(time.Second)
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{70, time.Second}, // 移位溢出时取上限
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := retryBackoff(tt.attempt); got < 0 || got > tt.max {
+				t.Fatalf("retryBackoff(%d) = %v，超出 [0, %v]", tt.attempt, got, tt.max)
+			}
+		}
+	}
+}
+
+func TestUpstreamGetCallerCancelNotCounted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.Breaker.FailureThreshold = 3
+	config.Upstream.Retry.MaxAttempts = 1
+
+	// 已有一次失败，调用方取消既不算失败也不算成功
+	breaker := upstreamBreaker(strings.TrimPrefix(srv.URL, "http://"))
+	breaker.record(context.Background(), true, time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := upstreamGet(ctx, "test", srv.URL); err == nil {
+		t.Fatal("期望请求因调用方超时失败")
+	}
+	if breaker.failures != 1 {
+		t.Errorf("调用方取消后连续失败次数 = %d，期望 1", breaker.failures)
+	}
+}
+
+func TestUpstreamGetAttemptBoundedByBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.Timeout = configDuration(10 * time.Second)
+	config.Upstream.Retry.Budget = configDuration(50 * time.Millisecond)
+
+	start := time.Now()
+	_, err := upstreamGet(context.Background(), "test", srv.URL)
+	if err == nil {
+		t.Fatal("期望请求超时失败")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("耗时 %v，单次尝试应受 retry.budget 限制", elapsed)
+	}
+	if lerr := upstreamFailure("x", err); lerr.Status != http.StatusGatewayTimeout {
+		t.Errorf("status = %d，期望 504", lerr.Status)
+	}
+}
+
+func TestUpstreamGetHonorsRetryAfter(t *testing.T) {
+	var calls []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, time.Now())
+		if len(calls) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.Retry.InitialBackoff = configDuration(time.Millisecond)
+	config.Upstream.Retry.MaxBackoff = configDuration(time.Millisecond)
+
+	resp, err := upstreamGet(context.Background(), "test", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(calls) != 2 {
+		t.Fatalf("status = %d，请求 %d 次，期望重试一次后成功", resp.StatusCode, len(calls))
+	}
+	if gap := calls[1].Sub(calls[0]); gap < time.Second {
+		t.Errorf("重试间隔 %v，期望不小于 Retry-After 的 1s", gap)
+	}
+
+	// Retry-After 超出预算时直接返回上游响应
+	calls = nil
+	config.Upstream.Retry.Budget = configDuration(500 * time.Millisecond)
+	resp, err = upstreamGet(context.Background(), "test", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || len(calls) != 1 {
+		t.Errorf("status = %d，请求 %d 次，期望不重试直接返回 503", resp.StatusCode, len(calls))
+	}
+}
+
+// --- 响应压缩 ---
+
+func TestNegotiateEncoding(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	tests := []struct {
+		encodings []string
+		accept    string
+		want      string
+	}{
+		{[]string{"gzip", "deflate"}, "", ""},
+		{[]string{"gzip", "deflate"}, "gzip", "gzip"},
+		{[]string{"gzip", "deflate"}, "deflate", "deflate"},
+		{[]string{"gzip", "deflate"}, "deflate, gzip", "gzip"},
+		{[]string{"gzip", "deflate"}, "gzip;q=0.5, deflate", "deflate"},
+		{[]string{"gzip", "deflate"}, "gzip;q=0", ""},
+		{[]string{"gzip", "deflate"}, "*", "gzip"},
+		{[]string{"gzip", "deflate"}, "*;q=0.1, deflate;q=0.5", "deflate"},
+		{[]string{"gzip", "deflate"}, "br", ""},
+		{[]string{"gzip", "deflate"}, "GZIP", "gzip"},
+		// 默认顺序：zstd > br > gzip > deflate
+		{defaultConfig().Compression.Encodings, "gzip, deflate, br, zstd", "zstd"},
+		{defaultConfig().Compression.Encodings, "gzip, deflate, br", "br"},
+		{defaultConfig().Compression.Encodings, "br;q=0.8, gzip", "gzip"},
+		{defaultConfig().Compression.Encodings, "zstd;q=0, br;q=0.5, gzip;q=0.4", "br"},
+	}
+	for _, tt := range tests {
+		config.Compression.Encodings = tt.encodings
+		if got := negotiateEncoding(tt.accept); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) 在 %v 下 = %q，期望 %q", tt.accept, tt.encodings, got, tt.want)
+		}
+	}
+}
+
+// decodeBody 按 Content-Encoding 解压响应体
+func decodeBody(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "":
+		r = body
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "deflate":
+		r = flate.NewReader(body)
+	case "br":
+		r = brotli.NewReader(body)
+	case "zstd":
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		t.Fatalf("未知的编码 %s", encoding)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("解压 %s 失败: %v", encoding, err)
+	}
+	return string(raw)
+}
+
+func TestCompressWriter(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Compression.MinSize = 16
+	config.Compression.Encodings = defaultConfig().Compression.Encodings
+
+	tests := []struct {
+		name        string
+		accept      string
+		level       int
+		contentType string
+		body        string
+		encoding    string // 期望的 Content-Encoding，空表示不压缩
+	}{
+		{"小于 min_size", "gzip", -1, "application/json", "{}", ""},
+		{"gzip", "gzip", -1, "application/json", strings.Repeat("a", 64), "gzip"},
+		{"deflate", "deflate", -1, "application/json", strings.Repeat("a", 64), "deflate"},
+		{"br", "br", -1, "application/json", strings.Repeat("a", 64), "br"},
+		{"br 最快", "br", 1, "application/json", strings.Repeat("a", 64), "br"},
+		{"br 仅 Huffman", "br", -2, "application/json", strings.Repeat("a", 64), "br"},
+		{"zstd", "zstd", -1, "application/json", strings.Repeat("a", 64), "zstd"},
+		{"zstd 最高级别", "zstd", 9, "application/json", strings.Repeat("a", 64), "zstd"},
+		{"SSE 不压缩", "zstd", -1, "text/event-stream", strings.Repeat("a", 64), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Compression.Level = tt.level
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			w, closeFn := newCompressWriter(rec, req)
+			w.Header().Set("Content-Type", tt.contentType)
+			w.Header().Set("ETag", `"abc"`)
+			io.WriteString(w, tt.body)
+			closeFn()
+
+			wantETag := `"abc"`
+			if tt.encoding != "" {
+				wantETag = `"abc-` + tt.encoding + `"`
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Content-Encoding = %q，期望 %q", got, tt.encoding)
+			}
+			if got := rec.Header().Get("ETag"); got != wantETag {
+				t.Errorf("ETag = %s，期望 %s", got, wantETag)
+			}
+			if !strings.Contains(strings.Join(rec.Header().Values("Vary"), ","), "Accept-Encoding") {
+				t.Error("缺少 Vary: Accept-Encoding")
+			}
+			if body := decodeBody(t, tt.encoding, rec.Body); body != tt.body {
+				t.Errorf("响应体 = %q，期望 %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestStripETagEncoding(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		if got := stripETagEncoding(`"abc-` + encoding + `"`); got != `"abc"` {
+			t.Errorf("stripETagEncoding(%s) = %s", encoding, got)
+		}
+	}
+	if got := stripETagEncoding(`"abc-other"`); got != `"abc-other"` {
+		t.Errorf("未注册的后缀不应去掉: %s", got)
+	}
+}
+
+func TestCompressedETagRevalidation(t *testing.T) {
+	newTestUpstream(t)
+	config.Compression.MinSize = 64
+
+	for _, encoding := range []string{"gzip", "br", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			first := serve("GET", "/v2/music/tencent/lyric?id=1", "", "Accept-Encoding", encoding)
+			if first.Code != http.StatusOK || first.Header().Get("Content-Encoding") != encoding {
+				t.Fatalf("status = %d，Content-Encoding = %q，期望 %s 压缩的 200", first.Code, first.Header().Get("Content-Encoding"), encoding)
+			}
+			if body := decodeBody(t, encoding, first.Body); !strings.Contains(body, `"code":200`) {
+				t.Errorf("解压后的响应体 = %.100s", body)
+			}
+			suffix := "-" + encoding + `"`
+			etag := first.Header().Get("ETag")
+			if !strings.HasSuffix(etag, suffix) {
+				t.Fatalf("ETag = %s，期望带 -%s 后缀", etag, encoding)
+			}
+
+			w := serve("GET", "/v2/music/tencent/lyric?id=1", "", "Accept-Encoding", encoding, "If-None-Match", etag)
+			if w.Code != http.StatusNotModified {
+				t.Fatalf("status = %d，期望 304", w.Code)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("304 的 ETag = %s，期望与 200 相同的 %s", got, etag)
+			}
+
+			// 不接受压缩的客户端用压缩版本的 ETag 重新验证，得到不带后缀的 ETag
+			w = serve("GET", "/v2/music/tencent/lyric?id=1", "", "If-None-Match", etag)
+			if w.Code != http.StatusNotModified {
+				t.Fatalf("status = %d，期望 304", w.Code)
+			}
+			if got, want := w.Header().Get("ETag"), strings.TrimSuffix(etag, suffix)+`"`; got != want {
+				t.Errorf("304 的 ETag = %s，期望 %s", got, want)
+			}
+		})
+	}
+}
+
+// --- 逐字时间估算 ---
+
+func TestTokenizeForTiming(t *testing.T) {
+	tests := []struct {
+		text string
+		want string // "文本:权重"，以 | 分隔
+	}{
+		{"你好世界", "你:1|好:1|世:1|界:1"},
+		{"hello world", "hello :2|world:1"},
+		{"恋はmagic!", "恋:1|は:1|magic!:2"},
+		{"「君」と", "「君」:1|と:1"},
+		{"사랑해", "사:1|랑:1|해:1"},
+		{"2024年", "2024:1|年:1"},
+		{"rock'n'roll", "rock'n'roll:2"},
+		{"…", "…:1"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, token := range tokenizeForTiming(tt.text) {
+			got = append(got, fmt.Sprintf("%s:%d", token.Text, token.Weight))
+		}
+		if strings.Join(got, "|") != tt.want {
+			t.Errorf("tokenizeForTiming(%q) = %s，期望 %s", tt.text, strings.Join(got, "|"), tt.want)
+		}
+	}
+}
+
+func TestSynthesizeWordTiming(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []MetaLine
+		want  string // 每行 "开始-结束:文本@开始+时长,..."，以 | 分隔
+	}{
+		{
+			name:  "CJK 按字平均分配，受下一行开始时间限制",
+			lines: []MetaLine{{0, "你好"}, {1000, "下"}},
+			want:  "0-1000:你@0+500,好@500+500|1000-1500:下@1000+500",
+		},
+		{
+			name:  "拉丁文按音节加权，每音节最长 800ms",
+			lines: []MetaLine{{1000, "hello world"}, {10000, "x"}},
+			want:  "1000-3400:hello @1000+1600,world@2600+800|10000-10500:x@10000+500",
+		},
+		{
+			name:  "中英混合",
+			lines: []MetaLine{{0, "爱love"}, {5000, "x"}},
+			want:  "0-2400:爱@0+800,love@800+1600|5000-5500:x@5000+500",
+		},
+		{
+			name:  "最后一行按每音节 500ms 估算结束时间",
+			lines: []MetaLine{{2000, "最后一行"}},
+			want:  "2000-4000:最@2000+500,后@2500+500,一@3000+500,行@3500+500",
+		},
+		{
+			name:  "空行不输出，但仍限制上一行的时长",
+			lines: []MetaLine{{0, "一二"}, {600, ""}, {3000, "三"}},
+			want:  "0-600:一@0+300,二@300+300|3000-3500:三@3000+500",
+		},
+		{
+			name:  "间隔过短时每个字至少 1ms",
+			lines: []MetaLine{{0, "一二三"}, {2, "四"}},
+			want:  "0-3:一@0+1,二@1+1,三@2+1|2-502:四@2+500",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, line := range synthesizeWordTiming(tt.lines) {
+				var words []string
+				for _, w := range line.Words {
+					words = append(words, fmt.Sprintf("%s@%d+%d", w.Text, w.StartTime, w.Duration))
+				}
+				got = append(got, fmt.Sprintf("%d-%d:%s", line.StartTime, line.EndTime, strings.Join(words, ",")))
+			}
+			if strings.Join(got, "|") != tt.want {
+				t.Errorf("结果 = %s\n期望 %s", strings.Join(got, "|"), tt.want)
+			}
+		})
+	}
+}
+
+// --- 假名注音 ---
+
+func TestApplyKanaRuby(t *testing.T) {
+	type word struct {
+		text  string
+		start int
+		dur   int
+	}
+	tests := []struct {
+		name  string
+		words []word
+		kana  string
+		want  string // 每个词的 "文本[注音起止(+延续词数):读音]@开始+时长"，以 | 分隔
+	}{
+		{
+			name:  "词内多个汉字",
+			words: []word{{"一緒に", 0, 300}},
+			kana:  "2いっしょ",
+			want:  "一緒に[0-2:いっしょ]@0+300",
+		},
+		{
+			name:  "逐字拆分的汉字共用一个注音，词保持不变",
+			words: []word{{"一", 0, 100}, {"緒", 100, 150}, {"に", 250, 50}},
+			kana:  "2いっしょ",
+			want:  "一[0-1+1:いっしょ]@0+100|緒@100+150|に@250+50",
+		},
+		{
+			name:  "跨词后继续消费后续条目",
+			words: []word{{"心", 0, 100}, {"一", 100, 100}, {"緒", 200, 100}, {"空", 300, 100}},
+			kana:  "1こころ2いっしょ1そら",
+			want:  "心[0-1:こころ]@0+100|一[0-1+1:いっしょ]@100+100|緒@200+100|空[0-1:そら]@300+100",
+		},
+		{
+			name:  "跨越多个词并结束在词中间",
+			words: []word{{"は一", 0, 100}, {"生", 100, 100}, {"懸命に", 200, 300}},
+			kana:  "4いっしょうけんめい",
+			want:  "は一[1-2+2:いっしょうけんめい]@0+100|生@100+100|懸命に@200+300",
+		},
+		{
+			name:  "注音结束的词中后续汉字继续消费条目",
+			words: []word{{"一", 0, 100}, {"緒空", 100, 200}},
+			kana:  "2いっしょ1そら",
+			want:  "一[0-1+1:いっしょ]@0+100|緒空[1-2:そら]@100+200",
+		},
+		{
+			name:  "中间有假名时不延续",
+			words: []word{{"一", 0, 100}, {"の", 100, 100}, {"緒", 200, 100}, {"空", 300, 100}},
+			kana:  "2いっしょ1そら",
+			want:  "一[0-1:いっしょ]@0+100|の@100+100|緒@200+100|空[0-1:そら]@300+100",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := &LineInfo{}
+			for _, w := range tt.words {
+				line.Words = append(line.Words, WordInfo{Text: w.text, StartTime: w.start, Duration: w.dur})
+			}
+			applyKanaRuby(context.Background(), []*LineInfo{line}, tt.kana)
+
+			var got []string
+			for _, w := range line.Words {
+				desc := w.Text
+				for _, r := range w.Ruby {
+					if r.Words > 0 {
+						desc += fmt.Sprintf("[%d-%d+%d:%s]", r.Start, r.End, r.Words, r.Reading)
+					} else {
+						desc += fmt.Sprintf("[%d-%d:%s]", r.Start, r.End, r.Reading)
+					}
+				}
+				got = append(got, fmt.Sprintf("%s@%d+%d", desc, w.StartTime, w.Duration))
+			}
+			if strings.Join(got, "|") != tt.want {
+				t.Errorf("结果 = %s，期望 %s", strings.Join(got, "|"), tt.want)
+			}
+		})
+	}
+}
+
+func TestRubyAcrossWordsOutput(t *testing.T) {
+	line := &LineInfo{StartTime: 0, EndTime: 500, Words: []WordInfo{
+		{Text: "は一", StartTime: 0, Duration: 100},
+		{Text: "生", StartTime: 100, Duration: 100},
+		{Text: "懸命に", StartTime: 200, Duration: 300},
+	}}
+	applyKanaRuby(context.Background(), []*LineInfo{line}, "4いっしょうけんめい")
+	parsed := &ParsedLyric{Lines: []*LineInfo{line}}
+
+	html := convertLinesToHtml(parsed)
+	wantHTML := `<p data-begin="0" data-end="500"><span data-begin="0" data-end="100">は</span>` +
+		`<ruby><span data-begin="0" data-end="100">一</span><span data-begin="100" data-end="200">生</span><span data-begin="200" data-end="500">懸命</span><rt>いっしょうけんめい</rt></ruby>` +
+		`<span data-begin="200" data-end="500">に</span></p>`
+	if !strings.Contains(html, wantHTML) {
+		t.Errorf("HTML = %s\n期望包含 %s", html, wantHTML)
+	}
+
+	ttml, err := convertYrcToTtml(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTTML := `<span tts:ruby="container"><span tts:ruby="base"><span begin="00:00.000" end="00:00.100">一</span><span begin="00:00.100" end="00:00.200">生</span><span begin="00:00.200" end="00:00.500">懸命</span></span><span tts:ruby="text">いっしょうけんめい</span></span>`
+	if !strings.Contains(ttml, wantTTML) {
+		t.Errorf("TTML = %s\n期望包含 %s", ttml, wantTTML)
+	}
+	if err := xml.Unmarshal([]byte(ttml), new(struct{})); err != nil {
+		t.Errorf("TTML 不是合法的 XML: %v", err)
+	}
+
+	lines := convertLinesToJSON(parsed)
+	if len(lines) != 1 || len(lines[0].Words) != 3 {
+		t.Fatalf("lines = %+v，期望 1 行 3 个词", lines)
+	}
+	if got := lines[0].Words[0].Ruby; len(got) != 1 || got[0] != (LyricRuby{Base: "一生懸命", Reading: "いっしょうけんめい", Offset: 1, Words: 2}) {
+		t.Errorf("ruby = %+v", got)
+	}
+	if lines[0].Words[1].Ruby != nil || lines[0].Words[2].Ruby != nil {
+		t.Errorf("后续词不应重复注音: %+v", lines[0].Words)
+	}
+}
+
+func TestTtmlEscapesText(t *testing.T) {
+	parsed := &ParsedLyric{
+		Lines: []*LineInfo{{
+			StartTime: 0,
+			EndTime:   1000,
+			Words: []WordInfo{
+				{Text: "R&B <", StartTime: 0, Duration: 500},
+				{Text: "愛>", StartTime: 500, Duration: 500, Ruby: []RubyInfo{{Start: 0, End: 1, Reading: "あ&い"}}},
+			},
+		}},
+		Translations: []MetaLine{{Time: 0, Content: "a < b"}},
+	}
+	out, err := convertYrcToTtml(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := xml.NewDecoder(strings.NewReader(out))
+	var text strings.Builder
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("TTML 不是合法的 XML: %v\n%s", err, out)
+		}
+		if data, ok := tok.(xml.CharData); ok {
+			text.WriteString(strings.TrimSpace(string(data)))
+		}
+	}
+	if got, want := text.String(), "R&B <愛あ&い>a < b"; got != want {
+		t.Errorf("文本 = %q，期望 %q", got, want)
+	}
+}
+
+// --- 配置 ---
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // JSON 编码后的结果
+		err   string
+	}{
+		{"键值", "a = 1\nb = 1.5\nc = true\nd = \"x # y\"\ne = 'raw\\n'", `{"a":1,"b":1.5,"c":true,"d":"x # y","e":"raw\\n"}`, ""},
+		{"注释和空行", "# 注释\n\na = 1 # 行尾注释\n", `{"a":1}`, ""},
+		{"数字下划线", "a = 1_000", `{"a":1000}`, ""},
+		{"表", "[upstream]\nbase_url = \"http://x\"\n[upstream.retry]\nmax_attempts = 2", `{"upstream":{"base_url":"http://x","retry":{"max_attempts":2}}}`, ""},
+		{"表数组", "[[auth.keys]]\nname = \"a\"\n[[auth.keys]]\nname = \"b\"", `{"auth":{"keys":[{"name":"a"},{"name":"b"}]}}`, ""},
+		{"数组", `a = ["x, y", 'z', [1, 2]]`, `{"a":["x, y","z",[1,2]]}`, ""},
+		{"多行数组", "a = [\n  \"x\", # 注释\n  \"y\",\n]", `{"a":["x","y"]}`, ""},
+		{"转义引号", `a = "say \"hi\" # not comment"`, `{"a":"say \"hi\" # not comment"}`, ""},
+		{"缺少等号", "a 1", "", "第 1 行: 缺少 '='"},
+		{"重复的键", "a = 1\na = 2", "", "第 2 行: 重复的键 'a'"},
+		{"无法解析的值", "a = nope", "", "无法解析的值"},
+		{"表与值冲突", "a = 1\n[a.b]", "", "'a' 不是表"},
+		{"表数组与表冲突", "[a]\n[[a]]", "", "'a' 不是表数组"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := parseTOML(tt.input)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v，期望包含 %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(tree)
+			if string(got) != tt.want {
+				t.Errorf("结果 = %s，期望 %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeConfigTOML(t *testing.T) {
+	cfg := defaultConfig()
+	err := decodeConfigTOML(`
+[upstream]
+timeout = "5s"
+ready_probe_ttl = 1500
+
+[[auth.keys]]
+name = "web"
+key = "secret"
+rate = 2.5
+burst = 10
+`, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Upstream.Timeout.Duration() != 5*time.Second || cfg.Upstream.ReadyProbeTTL.Duration() != 1500*time.Millisecond {
+		t.Errorf("upstream = %+v", cfg.Upstream)
+	}
+	if want := []APIKeyConfig{{Name: "web", Key: "secret", Rate: 2.5, Burst: 10}}; !reflect.DeepEqual(cfg.Auth.Keys, want) {
+		t.Errorf("auth.keys = %+v，期望 %+v", cfg.Auth.Keys, want)
+	}
+	if cfg.Search.DefaultNum != defaultConfig().Search.DefaultNum {
+		t.Error("未出现的键应保留默认值")
+	}
+
+	if err := decodeConfigTOML("[upstream]\ntimeuot = \"5s\"", &cfg); err == nil {
+		t.Error("未知的键应报错")
+	}
+}
+
+func TestApplyConfigEnv(t *testing.T) {
+	tests := []struct {
+		env   string
+		value string
+		check func(Config) bool
+		err   bool
+	}{
+		{"LYRIC_UPSTREAM_TIMEOUT", "500", func(c Config) bool { return c.Upstream.Timeout.Duration() == 500*time.Millisecond }, false},
+		{"LYRIC_UPSTREAM_TIMEOUT", "2s", func(c Config) bool { return c.Upstream.Timeout.Duration() == 2*time.Second }, false},
+		{"LYRIC_UPSTREAM_TIMEOUT", "soon", nil, true},
+		{"LYRIC_UPSTREAM_BASE_URL", "http://example.com", func(c Config) bool { return c.Upstream.BaseURL == "http://example.com" }, false},
+		{"LYRIC_UPSTREAM_RETRY_MAX_ATTEMPTS", "5", func(c Config) bool { return c.Upstream.Retry.MaxAttempts == 5 }, false},
+		{"LYRIC_SEARCH_DEFAULT_NUM", "ten", nil, true},
+		{"LYRIC_COMPRESSION_ENABLED", "false", func(c Config) bool { return !c.Compression.Enabled }, false},
+		{"LYRIC_MATCH_MIN_SCORE", "0.8", func(c Config) bool { return c.Match.MinScore == 0.8 }, false},
+		{"LYRIC_CORS_ALLOWED_ORIGINS", "https://a.com, https://*.b.com", func(c Config) bool {
+			return reflect.DeepEqual(c.CORS.AllowedOrigins, []string{"https://a.com", "https://*.b.com"})
+		}, false},
+		{"LYRIC_CORS_ALLOWED_ORIGINS", `["https://a.com"]`, func(c Config) bool {
+			return reflect.DeepEqual(c.CORS.AllowedOrigins, []string{"https://a.com"})
+		}, false},
+		{"LYRIC_AUTH_KEYS", `[{"name":"web","key":"k","rate":1,"burst":2}]`, func(c Config) bool {
+			return len(c.Auth.Keys) == 1 && c.Auth.Keys[0].Burst == 2
+		}, false},
+		{"LYRIC_AUTH_KEYS", `[{"nmae":"web"}]`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			cfg := defaultConfig()
+			err := applyConfigEnv(reflect.ValueOf(&cfg).Elem(), "LYRIC")
+			if tt.err {
+				if err == nil || !strings.Contains(err.Error(), tt.env) {
+					t.Fatalf("err = %v，期望指出 %s", err, tt.env)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("%s=%s 未生效", tt.env, tt.value)
+			}
+		})
+	}
+}
+
+func TestReadyzReportsConfigError(t *testing.T) {
+	saved := configErr
+	defer func() { configErr = saved }()
+	configErr = fmt.Errorf("upstream.timeout 必须大于 0")
+
+	w := serve("GET", "/readyz", "")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "upstream.timeout") {
+		t.Errorf("status = %d, body = %s，期望 503 和配置错误", w.Code, w.Body.String())
+	}
+}
+
+func TestInvalidConfigFailsClosed(t *testing.T) {
+	path := t.TempDir() + "/lyric.toml"
+	data := `
+[upstream]
+timeout = "-1s"
+
+[[auth.keys]]
+name = "web"
+key = "secret"
+rate = 5
+burst = 10
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LYRIC_CONFIG", path)
+
+	cfg, err := loadConfig()
+	if err == nil {
+		t.Fatal("期望配置校验失败")
+	}
+
+	// 与 init 相同：配置无效时 config 不含 auth.keys，只能依靠 configErr 拒绝请求
+	savedConfig, savedErr := config, configErr
+	defer func() { config, configErr = savedConfig, savedErr }()
+	config, configErr = cfg, err
+	config.Auth.Keys = nil
+
+	for _, tc := range []struct {
+		target string
+		hdr    []string
+	}{
+		{"/v2/music/tencent/lyric?id=1", nil},
+		{"/v2/music/tencent/lyric?id=1", []string{"X-API-Key", "secret"}},
+		{"/metrics", nil},
+		{"/api/admin/config", nil},
+	} {
+		w := serve("GET", tc.target, "", tc.hdr...)
+		var resp ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusServiceUnavailable || resp.ErrorCode != ErrCodeConfigInvalid || !strings.Contains(resp.Details, "timeout") {
+			t.Errorf("%s %v: status = %d, body = %s，期望 503 CONFIG_INVALID", tc.target, tc.hdr, w.Code, w.Body.String())
+		}
+	}
+
+	if w := serve("GET", "/healthz", ""); w.Code != http.StatusOK {
+		t.Errorf("/healthz status = %d，期望 200", w.Code)
+	}
+}
+
+// --- 多语言消息 ---
+
+func TestRequestLang(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		want           string
+	}{
+		{"默认中文", "", "", langZhCN},
+		{"lang 参数", "lang=en", "zh-CN", langEn},
+		{"lang 参数不支持时看请求头", "lang=fr", "en-US", langEn},
+		{"区域子标签", "", "en-GB", langEn},
+		{"汉字变体", "", "zh-Hans", langZhCN},
+		{"按权重选择", "", "zh-CN;q=0.5, en;q=0.8", langEn},
+		{"省略权重为 1", "", "en;q=0.9, zh", langZhCN},
+		{"权重相同取先出现的", "", "en;q=0.7, zh;q=0.7", langEn},
+		{"跳过不支持的语言", "", "fr-FR, de;q=0.9, en;q=0.1", langEn},
+		{"q=0 表示不接受", "", "en;q=0", langZhCN},
+		{"权重大小写和空格", "", "zh;q=0.2, en ; Q = 0.6", langEn},
+		{"其他参数", "", "en;level=1;q=0.9, zh;q=0.5", langEn},
+		{"不合法的权重", "", "en;q=abc, zh;q=0.1", langZhCN},
+		{"超出范围的权重", "", "en;q=2, zh;q=0.1", langZhCN},
+		{"NaN 权重", "", "en;q=NaN, zh;q=0.1", langZhCN},
+		{"只有不支持的语言", "", "fr, ja;q=0.9", langZhCN},
+		{"通配", "", "*", langZhCN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/?"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			if got := requestLang(req); got != tt.want {
+				t.Errorf("requestLang = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorMessageLocalizedDetailsUntranslated(t *testing.T) {
+	for _, tt := range []struct {
+		acceptLanguage string
+		message        string
+	}{
+		{"en-US,en;q=0.9", "Missing parameter"},
+		{"zh-CN", "缺少参数"},
+	} {
+		w := serve("GET", "/v2/music/tencent/lyric", "", "Accept-Language", tt.acceptLanguage)
+		var resp ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("解析错误响应失败: %v", err)
+		}
+		if resp.Message != tt.message {
+			t.Errorf("%s: message = %q，期望 %q", tt.acceptLanguage, resp.Message, tt.message)
+		}
+		// details 是不翻译的诊断文本
+		if want := "请提供 'id', 'mid', 'word' 或 'title' 参数"; resp.Details != want {
+			t.Errorf("%s: details = %q，期望 %q", tt.acceptLanguage, resp.Details, want)
+		}
+	}
+}
+
+// --- 搜索 ---
+
+func TestParseDurationText(t *testing.T) {
+	for text, want := range map[string]int{
+		"215":     215,
+		" 215 ":   215,
+		"03:35":   215,
+		"3:05":    185,
+		"3分35秒":   215,
+		"35秒":     35,
+		"":        0,
+		"3分":      0,
+		"1:02:03": 0,
+		"3:xx":    0,
+		"abc":     0,
+	} {
+		if got := parseDurationText(text); got != want {
+			t.Errorf("parseDurationText(%q) = %d，期望 %d", text, got, want)
+		}
+	}
+}
+
+func TestUpstreamSecondsUnmarshal(t *testing.T) {
+	for raw, want := range map[string]int{
+		`215`:     215,
+		`215.9`:   215,
+		`"03:35"`: 215,
+		`"3分5秒"`:  185,
+		`null`:    0,
+		`true`:    0,
+		`"x"`:     0,
+	} {
+		var got struct {
+			Interval upstreamSeconds `json:"interval"`
+		}
+		if err := json.Unmarshal([]byte(`{"interval":`+raw+`}`), &got); err != nil {
+			t.Errorf("%s: 不应返回错误: %v", raw, err)
+		}
+		if int(got.Interval) != want {
+			t.Errorf("%s: 得到 %d，期望 %d", raw, got.Interval, want)
+		}
+	}
+}
+
+func TestFilterSongs(t *testing.T) {
+	songs := []SearchSongItemSimplified{
+		{N: 1, Song: "晴天", Singer: "周杰伦", Album: "叶惠美"},
+		{N: 2, Song: "Love Story", Singer: "Taylor Swift", Album: "Fearless (Taylor's Version)"},
+		{N: 3, Song: "晴天", Singer: "周杰伦 / 五月天", Album: "Live"},
+		{N: 4, Song: "晴天", Singer: "翻唱者", Album: "叶惠美"},
+	}
+	tests := []struct {
+		name          string
+		singer, album string
+		want          string // 每首 "编号:歌手"，以 | 分隔
+	}{
+		{"不过滤时原样返回", "", "", "1:周杰伦|2:Taylor Swift|3:周杰伦 / 五月天|4:翻唱者"},
+		{"歌手包含匹配并重新编号", "周杰伦", "", "1:周杰伦|2:周杰伦 / 五月天"},
+		{"忽略大小写、全角和空白", "ＴＡＹＬＯＲ swift", "", "1:Taylor Swift"},
+		{"专辑忽略标点", "", "fearless taylors version", "1:Taylor Swift"},
+		{"歌手和专辑同时满足", "周杰伦", "叶惠美", "1:周杰伦"},
+		{"只有标点的过滤条件视为未提供", "（）", "", "1:周杰伦|2:Taylor Swift|3:周杰伦 / 五月天|4:翻唱者"},
+		{"没有匹配", "不存在", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, song := range filterSongs(songs, tt.singer, tt.album) {
+				got = append(got, fmt.Sprintf("%d:%s", song.N, song.Singer))
+			}
+			if strings.Join(got, "|") != tt.want {
+				t.Errorf("得到 %q，期望 %q", strings.Join(got, "|"), tt.want)
+			}
+		})
+	}
+	if songs[2].N != 3 {
+		t.Errorf("过滤不应修改原切片的编号: %+v", songs[2])
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	var upstreamQuery atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamQuery.Store(r.URL.RawQuery)
+		fmt.Fprint(w, `{"code":200,"message":"ok","data":[{"id":1,"mid":"m1","song":"Hello","singer":"Artist","album":"Alb","interval":"03:35"},{"id":2,"mid":"m2","song":"Hello","singer":"Other","album":"Y","interval":200}]}`)
+	}))
+	defer srv.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.BaseURL = srv.URL
+	config.Search.DefaultNum = 10
+	config.Search.MaxNum = 60
+
+	tests := []struct {
+		query     string
+		page, num int
+	}{
+		{"", 1, 10},
+		{"&page=0&num=0", 1, 10},
+		{"&page=-3&num=-1", 1, 10},
+		{"&page=x&num=y", 1, 10},
+		{"&page=3&num=20", 3, 20},
+		{"&page=2&num=61", 2, 60},
+	}
+	for _, tt := range tests {
+		w := serve(http.MethodGet, "/v2/music/tencent/lyric?word=hello"+tt.query, "")
+		var resp SearchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tt.query, w.Code, w.Body.String())
+		}
+		if resp.Page != tt.page || resp.Num != tt.num {
+			t.Errorf("%s: 响应 page=%d num=%d，期望 %d %d", tt.query, resp.Page, resp.Num, tt.page, tt.num)
+		}
+		if want := fmt.Sprintf("word=hello&page=%d&num=%d", tt.page, tt.num); upstreamQuery.Load() != want {
+			t.Errorf("%s: 上游查询 %v，期望 %s", tt.query, upstreamQuery.Load(), want)
+		}
+	}
+
+	// 过滤后重新编号，n 按过滤后的结果选择
+	w := serve(http.MethodGet, "/v2/music/tencent/lyric?word=hello&singer=other", "")
+	var resp SearchResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Data) != 1 || resp.Data[0].N != 1 || resp.Data[0].ID != 2 {
+		t.Errorf("按歌手过滤: %s", w.Body.String())
+	}
+	w = serve(http.MethodGet, "/v2/music/tencent/lyric?word=hello&album=alb", "")
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Data) != 1 || resp.Data[0].ID != 1 || resp.Data[0].Duration != 215 {
+		t.Errorf("按专辑过滤: %s", w.Body.String())
+	}
+	w = serve(http.MethodGet, "/v2/music/tencent/lyric?word=hello&singer=other&n=2", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), string(ErrCodeIndexOutOfRange)) {
+		t.Errorf("过滤后 n 超出范围应返回 404: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// --- 批量歌词接口 ---
+
+// newBatchUpstream 启动模拟的歌词上游，fn 在返回歌词前调用，可用于阻塞或计数；id=404 时上游返回错误
+func newBatchUpstream(t *testing.T, fn func(r *http.Request)) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fn != nil {
+			fn(r)
+		}
+		if r.URL.Query().Get("id") == "404" {
+			fmt.Fprint(w, `{"code":404,"message":"not found"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"code":200,"message":"ok","data":{"lrc":%q,"trans":"","yrc":%q,"roma":""}}`, testLrc, testYrc)
+	}))
+	saved := config
+	config.Upstream.BaseURL = srv.URL
+	t.Cleanup(func() {
+		srv.Close()
+		config = saved
+	})
+}
+
+// batchItems 生成 n 个按 id 请求的批量项
+func batchItems(n int) string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprintf(`{"id":"%d"}`, 1000+i)
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestBatchConcurrencyCap(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	newBatchUpstream(t, func(r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	})
+	config.Batch = BatchConfig{MaxItems: 50, DefaultConcurrency: 2, MaxConcurrency: 4}
+
+	tests := []struct {
+		concurrency string
+		want        int32
+	}{
+		{``, 2},                   // 未提供时使用默认值
+		{`,"concurrency":3`, 3},   // 按请求的并发度
+		{`,"concurrency":100`, 4}, // 超过上限时截断
+		{`,"concurrency":-1`, 2},  // 非正数使用默认值
+	}
+	for _, tt := range tests {
+		peak.Store(0)
+		w := serve(http.MethodPost, "/api/batch", `{"items":`+batchItems(12)+tt.concurrency+`}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tt.concurrency, w.Code, w.Body.String())
+		}
+		if got := peak.Load(); got != tt.want {
+			t.Errorf("%s: 上游最大并发 %d，期望 %d", tt.concurrency, got, tt.want)
+		}
+	}
+}
+
+func TestBatchRejectsInvalidRequest(t *testing.T) {
+	newBatchUpstream(t, nil)
+	config.Batch.MaxItems = 3
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		code   ErrorCode
+	}{
+		{"超过 max_items", http.MethodPost, `{"items":` + batchItems(4) + `}`, http.StatusBadRequest, ErrCodeBatchTooLarge},
+		{"items 为空", http.MethodPost, `{"items":[]}`, http.StatusBadRequest, ErrCodeMissingParameter},
+		{"请求体不是 JSON", http.MethodPost, `{"items":`, http.StatusBadRequest, ErrCodeInvalidBody},
+		{"不支持 GET", http.MethodGet, "", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, "/api/batch", tt.body)
+			var resp ErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != tt.status || resp.ErrorCode != tt.code {
+				t.Errorf("得到 %d %s，期望 %d %s", w.Code, resp.ErrorCode, tt.status, tt.code)
+			}
+		})
+	}
+
+	if w := serve(http.MethodPost, "/api/batch", `{"items":`+batchItems(3)+`}`); w.Code != http.StatusOK {
+		t.Errorf("恰好 max_items 项应成功: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBatchItemErrorEnvelopes(t *testing.T) {
+	newBatchUpstream(t, nil)
+
+	body := `{"items":[{"id":"1"},{"id":"404"},{},{"title":"x","min_score":2}]}`
+	w := serve(http.MethodPost, "/api/batch?lang=en", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("部分失败时整体仍应返回 200: %d %s", w.Code, w.Body.String())
+	}
+	var resp BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		code    int
+		errCode ErrorCode
+		message string
+	}{
+		{http.StatusOK, "", ""},
+		{http.StatusFailedDependency, ErrCodeUpstreamError, "Upstream returned an error"},
+		{http.StatusBadRequest, ErrCodeMissingParameter, "Missing parameter"},
+		{http.StatusBadRequest, ErrCodeInvalidParameter, "Invalid parameter"},
+	}
+	if len(resp.Data) != len(want) {
+		t.Fatalf("结果数 %d，期望 %d: %s", len(resp.Data), len(want), w.Body.String())
+	}
+	for i, item := range resp.Data {
+		if item.Index != i || item.Code != want[i].code {
+			t.Errorf("第 %d 项: index=%d code=%d，期望 index=%d code=%d", i, item.Index, item.Code, i, want[i].code)
+		}
+		if want[i].errCode == "" {
+			if item.Data == nil || item.Error != nil {
+				t.Errorf("第 %d 项应只有 data: %+v", i, item)
+			}
+			continue
+		}
+		if item.Data != nil || item.Error == nil {
+			t.Errorf("第 %d 项应只有 error: %+v", i, item)
+			continue
+		}
+		if item.Error.Code != want[i].code || item.Error.ErrorCode != want[i].errCode || item.Error.Message != want[i].message {
+			t.Errorf("第 %d 项错误 %+v，期望 %d %s %q", i, item.Error, want[i].code, want[i].errCode, want[i].message)
+		}
+	}
+	if upstream := resp.Data[1].Error.Upstream; !strings.Contains(string(upstream), `"code":404`) {
+		t.Errorf("上游错误应附带原始响应: %s", upstream)
+	}
+}
+
+// flushSignalRecorder 在每次 Flush 时通知 flushed
+type flushSignalRecorder struct {
+	*httptest.ResponseRecorder
+	flushed chan struct{}
+}
+
+func (r *flushSignalRecorder) Flush() {
+	r.ResponseRecorder.Flush()
+	select {
+	case r.flushed <- struct{}{}:
+	default:
+	}
+}
+
+func TestBatchStreamsInCompletionOrder(t *testing.T) {
+	for _, tt := range []struct{ target, accept string }{
+		{"/api/batch?stream=1", ""},
+		{"/api/batch", "application/x-ndjson"},
+	} {
+		w := &flushSignalRecorder{httptest.NewRecorder(), make(chan struct{}, 1)}
+		// 第 0 项等到第 1 项的结果已经写出后才返回
+		newBatchUpstream(t, func(r *http.Request) {
+			if r.URL.Query().Get("id") == "1" {
+				select {
+				case <-w.flushed:
+				case <-time.After(5 * time.Second):
+				}
+			}
+		})
+
+		req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(`{"items":[{"id":"1"},{"id":"404"}],"concurrency":2}`))
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		Handler(w, req)
+
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/x-ndjson") {
+			t.Errorf("%s: Content-Type = %s", tt.target, ct)
+		}
+		lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+		var got []string
+		for _, line := range lines {
+			var item BatchItemResult
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				t.Fatalf("%s: 第 %q 行不是 JSON: %v", tt.target, line, err)
+			}
+			got = append(got, fmt.Sprintf("%d:%d", item.Index, item.Code))
+		}
+		if strings.Join(got, " ") != "1:424 0:200" {
+			t.Errorf("%s: 输出顺序 %v，期望先完成的第 1 项在前", tt.target, got)
+		}
+	}
+}
+
+// --- 元数据匹配 ---
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Hello", "hello"},
+		{"Hello (feat. Someone)", "hello"},
+		{"Hello [ft. Someone]", "hello"},
+		{"Hello feat. Someone", "hello"},
+		{"Hello ft. Someone & Other", "hello"},
+		{"Hello (Live)", "hello"},
+		{"Hello - 2011 Remaster", "hello"},
+		{"Hello - Radio Edit", "hello"},
+		{"Hello (Remastered 2009)", "hello"},
+		{"晴天（伴奏）", "晴天"},
+		{"晴天【现场】", "晴天"},
+		{"Ｈｅｌｌｏ，Ｗｏｒｌｄ！", "helloworld"},
+		// 不是版本后缀的括号和破折号保留
+		{"Hello (Acoustic)", "helloacoustic"},
+		{"Hello - World", "helloworld"},
+	}
+	for _, tt := range tests {
+		if got := normalizeTitle(tt.title); got != tt.want {
+			t.Errorf("normalizeTitle(%q) = %q，期望 %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestScoreSong(t *testing.T) {
+	song := SearchSongItemSimplified{Song: "Hello", Singer: "Adele", Album: "25", Duration: 295}
+	tests := []struct {
+		name  string
+		song  SearchSongItemSimplified
+		query MatchQuery
+		want  float64
+	}{
+		{"完全一致", song, MatchQuery{Title: "Hello", Artist: "Adele", Album: "25", Duration: 295}, 1},
+		{"只有标题", song, MatchQuery{Title: "Hello"}, 1},
+		{"忽略 feat 后缀", song, MatchQuery{Title: "Hello (feat. Someone)", Artist: "Adele"}, 1},
+		{"忽略 remaster 后缀", SearchSongItemSimplified{Song: "Hello - 2016 Remaster", Singer: "Adele"}, MatchQuery{Title: "Hello", Artist: "Adele"}, 1},
+		{"多位歌手取最高", SearchSongItemSimplified{Song: "Hello", Singer: "Someone / Adele"}, MatchQuery{Title: "Hello", Artist: "Adele"}, 1},
+		{"歌手完全不同", song, MatchQuery{Title: "Hello", Artist: "Xyz"}, 0.5 / 0.8},
+		{"时长在容差内", song, MatchQuery{Title: "Hello", Duration: 295 + matchDurationExact}, 1},
+		{"时长差 11 秒", song, MatchQuery{Title: "Hello", Duration: 306}, (0.5 + 0.1*0.5) / 0.6},
+		{"时长差超过上限", song, MatchQuery{Title: "Hello", Duration: 295 + matchDurationMax}, 0.5 / 0.6},
+		{"上游没有时长", SearchSongItemSimplified{Song: "Hello"}, MatchQuery{Title: "Hello", Duration: 295}, (0.5 + 0.1*0.5) / 0.6},
+		{"标题完全不同", song, MatchQuery{Title: "Xyz", Artist: "Adele"}, 0.3 / 0.8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scoreSong(tt.song, tt.query); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("scoreSong = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinScoreValidation(t *testing.T) {
+	newTestUpstream(t)
+
+	for _, v := range []string{"-0.1", "1.5", "NaN", "abc", "Inf"} {
+		w := serve("GET", "/v2/music/tencent/lyric?title=Hello&min_score="+v, "")
+		var resp ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusBadRequest || resp.ErrorCode != ErrCodeInvalidParameter {
+			t.Errorf("min_score=%s: status = %d, body = %s，期望 400 INVALID_PARAMETER", v, w.Code, w.Body.String())
+		}
+	}
+	for _, v := range []string{"0", "0.5", "1"} {
+		if w := serve("GET", "/v2/music/tencent/lyric?title=Hello&artist=Artist&min_score="+v, ""); w.Code != http.StatusOK {
+			t.Errorf("min_score=%s: status = %d, body = %s，期望 200", v, w.Code, w.Body.String())
+		}
+	}
+
+	w := serve("POST", "/api/batch", `{"items":[{"title":"Hello","min_score":2},{"title":"Hello","artist":"Artist"}]}`)
+	var resp BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 2 || resp.Data[0].Error == nil || resp.Data[0].Error.ErrorCode != ErrCodeInvalidParameter || resp.Data[1].Code != http.StatusOK {
+		t.Errorf("批量结果 = %s，期望第 1 项 INVALID_PARAMETER、第 2 项 200", w.Body.String())
+	}
+}
+
+// --- LRCLIB 兼容接口 ---
+
+func TestBuildLrclibRecord(t *testing.T) {
+	song := SearchSongItemSimplified{ID: 7, Song: "Hello", Singer: "Artist", Album: "Alb", Duration: 215}
+	lyric := func(code int, lrc string) *LyricData {
+		data := &LyricData{Code: code}
+		data.Data.Lrc = lrc
+		return data
+	}
+
+	record := buildLrclibRecord(song, lyric(200, testLrc))
+	if record.ID != 7 || record.TrackName != "Hello" || record.ArtistName != "Artist" || record.AlbumName != "Alb" || record.Duration != 215 {
+		t.Errorf("元数据 = %+v", record)
+	}
+	if record.SyncedLyrics == nil || *record.SyncedLyrics != "[00:01.00] hello\n[00:05.00] world\n" {
+		t.Errorf("syncedLyrics = %v，期望只包含带时间的歌词行", record.SyncedLyrics)
+	}
+	if record.PlainLyrics == nil || *record.PlainLyrics != "hello\nworld" {
+		t.Errorf("plainLyrics = %v", record.PlainLyrics)
+	}
+	if record.Instrumental {
+		t.Error("instrumental = true，期望 false")
+	}
+
+	record = buildLrclibRecord(song, lyric(200, "[00:00.00]此歌曲为没有填词的纯音乐，请您欣赏\n"))
+	if !record.Instrumental || record.SyncedLyrics != nil || record.PlainLyrics != nil {
+		t.Errorf("纯音乐 = %+v，期望 instrumental 且没有歌词", record)
+	}
+
+	for name, data := range map[string]*LyricData{"无数据": nil, "上游错误": lyric(404, testLrc), "没有时间轴": lyric(200, "[ti:x]\n纯文本")} {
+		record := buildLrclibRecord(song, data)
+		if record.Instrumental || record.SyncedLyrics != nil || record.PlainLyrics != nil || record.TrackName != "Hello" {
+			t.Errorf("%s: %+v，期望只有元数据", name, record)
+		}
+	}
+
+	// 序列化时缺少歌词的字段为 null
+	out, _ := json.Marshal(buildLrclibRecord(song, nil))
+	if !strings.Contains(string(out), `"plainLyrics":null`) || !strings.Contains(string(out), `"syncedLyrics":null`) {
+		t.Errorf("JSON = %s，期望歌词字段为 null", out)
+	}
+}
+
+func TestPickLrclibCandidate(t *testing.T) {
+	songs := []SearchSongItemSimplified{
+		{MID: "other", Song: "Goodbye", Singer: "Nobody", Duration: 200},
+		{MID: "long", Song: "Hello", Singer: "Adele", Duration: 300},
+		{MID: "exact", Song: "Hello (Live)", Singer: "Adele", Duration: 295},
+	}
+	tests := []struct {
+		name  string
+		query MatchQuery
+		want  string
+	}{
+		{"不限时长取最高分", MatchQuery{Title: "Hello", Artist: "Adele"}, "long"},
+		{"跳过超出时长容差的候选", MatchQuery{Title: "Hello", Artist: "Adele", Duration: 295}, "exact"},
+		{"容差边界", MatchQuery{Title: "Hello", Artist: "Adele", Duration: 300 + lrclibDurationTolerance}, "long"},
+		{"全部超出容差", MatchQuery{Title: "Hello", Artist: "Adele", Duration: 100}, ""},
+		{"低于匹配度阈值", MatchQuery{Title: "完全不同的歌", Artist: "某人"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			song, ok := pickLrclibCandidate(songs, tt.query)
+			if ok != (tt.want != "") || song.MID != tt.want {
+				t.Errorf("候选 = %q (%v)，期望 %q", song.MID, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestLrclibSearchLimitsLyricFetches(t *testing.T) {
+	var lyricCalls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lyric", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lyricCalls, 1)
+		fmt.Fprintf(w, `{"code":200,"message":"ok","data":{"lrc":%q,"trans":"","yrc":"","roma":""}}`, testLrc)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var items []string
+		for i := 1; i <= 10; i++ {
+			song := fmt.Sprintf("Song %d", i)
+			if i == 8 {
+				song = "Target"
+			}
+			items = append(items, fmt.Sprintf(`{"id":%d,"mid":"m%d","song":%q,"singer":"Artist","album":"Alb"}`, i, i, song))
+		}
+		fmt.Fprintf(w, `{"code":200,"message":"ok","data":[%s]}`, strings.Join(items, ","))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.BaseURL = srv.URL
+	config.Search.DefaultNum = 10
+
+	w := serve("GET", "/api/search?q=song", "")
+	var records []LrclibRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if len(records) != lrclibSearchMaxResults || records[0].ID != 1 {
+		t.Errorf("返回 %d 条，首条 id = %d，期望按上游顺序返回 %d 条", len(records), records[0].ID, lrclibSearchMaxResults)
+	}
+	if n := atomic.LoadInt32(&lyricCalls); n != lrclibSearchMaxResults {
+		t.Errorf("歌词请求 %d 次，期望 %d 次", n, lrclibSearchMaxResults)
+	}
+
+	// 指定 track_name 时按匹配度排序后截取
+	w = serve("GET", "/api/search?track_name=Target&artist_name=Artist", "")
+	records = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil || len(records) == 0 {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if records[0].TrackName != "Target" {
+		t.Errorf("首条 = %q，期望匹配度最高的 Target", records[0].TrackName)
+	}
+}
+
+// --- OpenSubsonic 兼容接口 ---
+
+func TestDetectLyricLanguage(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  string
+	}{
+		{[]string{"君の名は@0"}, "jpn"},
+		{[]string{"我爱你@0", "カタカナ@1000"}, "jpn"}, // 有假名即判为日语，即使汉字更多
+		{[]string{"사랑해@0"}, "kor"},
+		{[]string{"我爱你@0", "love you@1000"}, "zho"},
+		{[]string{"hello world@0"}, "und"},
+		{nil, "und"},
+	}
+	for _, tt := range tests {
+		if got := detectLyricLanguage(songLines(tt.lines...)); got != tt.want {
+			t.Errorf("detectLyricLanguage(%v) = %s，期望 %s", tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestBuildSubsonicLyrics(t *testing.T) {
+	parsed := &ParsedLyric{
+		Meta:         map[string]string{"ti": "歌名", "ar": "歌手", "offset": "-200"},
+		Lines:        songLines("君の@0", "名は@1000"),
+		Translations: []MetaLine{{0, "你的"}, {1000, "名字"}},
+		Romaji:       songLines("kimi no@0", " @1000"),
+	}
+	data, _ := json.Marshal(buildSubsonicLyrics(parsed))
+	want := `[` +
+		`{"displayArtist":"歌手","displayTitle":"歌名","lang":"jpn","offset":-200,"synced":true,"line":[{"start":0,"value":"君の"},{"start":1000,"value":"名は"}]},` +
+		`{"displayArtist":"歌手","displayTitle":"歌名","lang":"zho","offset":-200,"synced":true,"line":[{"start":0,"value":"你的"},{"start":1000,"value":"名字"}]},` +
+		`{"displayArtist":"歌手","displayTitle":"歌名","lang":"jpn-Latn","offset":-200,"synced":true,"line":[{"start":0,"value":"kimi no"}]}` +
+		`]`
+	if string(data) != want {
+		t.Errorf("得到\n%s\n期望\n%s", data, want)
+	}
+
+	// 罗马音全部为空行时省略整条；没有歌词时结果为空
+	parsed = &ParsedLyric{Lines: songLines("a@0"), Romaji: songLines(" @0")}
+	if got := buildSubsonicLyrics(parsed); len(got) != 1 || got[0].Lang != "und" || got[0].Offset != 0 {
+		t.Errorf("空罗马音: %+v", got)
+	}
+	if got := buildSubsonicLyrics(&ParsedLyric{}); got != nil {
+		t.Errorf("空歌词: %+v", got)
+	}
+}
+
+func TestSubsonicLyricsResponse(t *testing.T) {
+	newTestUpstream(t)
+
+	w := serve(http.MethodGet, "/rest/getLyricsBySongId?id=1&f=json", "")
+	var body struct {
+		Resp SubsonicResponse `json:"subsonic-response"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("解析 JSON 失败: %v\n%s", err, w.Body.String())
+	}
+	resp := body.Resp
+	if w.Code != http.StatusOK || resp.Status != "ok" || resp.Version != subsonicAPIVersion || !resp.OpenSubsonic {
+		t.Fatalf("响应外层不符: %d %s", w.Code, w.Body.String())
+	}
+	if resp.LyricsList == nil || len(resp.LyricsList.StructuredLyrics) != 1 {
+		t.Fatalf("应有一条 structuredLyrics: %s", w.Body.String())
+	}
+	lyrics := resp.LyricsList.StructuredLyrics[0]
+	if lyrics.DisplayTitle != "测试歌曲" || lyrics.Lang != "und" || !lyrics.Synced ||
+		!reflect.DeepEqual(lyrics.Line, []SubsonicLyricLine{{1000, "hello"}, {5000, "world"}}) {
+		t.Errorf("structuredLyrics 不符: %+v", lyrics)
+	}
+
+	w = serve(http.MethodGet, "/rest/getLyricsBySongId.view?id=1", "")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("默认应输出 XML，Content-Type = %s", ct)
+	}
+	var xmlResp SubsonicResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &xmlResp); err != nil {
+		t.Fatalf("解析 XML 失败: %v\n%s", err, w.Body.String())
+	}
+	if xmlResp.XMLName.Space != subsonicXmlns || xmlResp.LyricsList == nil ||
+		!reflect.DeepEqual(xmlResp.LyricsList.StructuredLyrics[0].Line, lyrics.Line) {
+		t.Errorf("XML 与 JSON 内容不一致: %s", w.Body.String())
+	}
+
+	// 协议错误仍然是 HTTP 200，通过 status 和 error.code 区分
+	for target, code := range map[string]int{
+		"/rest/getLyricsBySongId?f=json":        subsonicErrMissing,
+		"/rest/getLyricsBySongId?id=404&f=json": subsonicErrNotFound,
+	} {
+		w := serve(http.MethodGet, target, "")
+		var body struct {
+			Resp SubsonicResponse `json:"subsonic-response"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusOK || body.Resp.Status != "failed" || body.Resp.Error == nil || body.Resp.Error.Code != code {
+			t.Errorf("%s: %d %s，期望 error.code %d", target, w.Code, w.Body.String(), code)
+		}
+	}
+}
+
+// --- 健康检查 ---
+
+func TestCheckReadySingleFlight(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, `{"code":200,"message":"ok","data":[]}`)
+	}))
+	defer srv.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.BaseURL = srv.URL
+	readyMu.Lock()
+	readyChecked = time.Time{}
+	readyMu.Unlock()
+
+	// 第一个调用方在探测完成前断开，不影响探测结果
+	canceled, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			if i == 0 {
+				ctx = canceled
+			}
+			_, errs[i] = checkReady(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("上游请求 %d 次，期望并发的就绪检查只探测 1 次", n)
+	}
+	for i, err := range errs[1:] {
+		if err != nil {
+			t.Errorf("调用方 %d: %v", i+1, err)
+		}
+	}
+	if _, err := checkReady(context.Background()); err != nil {
+		t.Errorf("缓存的结果 = %v，调用方断开不应导致失败被缓存", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("上游请求 %d 次，TTL 内应使用缓存", n)
+	}
+}
+
+func TestCheckReadyInitiatorHonorsContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, `{"code":200,"message":"ok","data":[]}`)
+	}))
+	defer srv.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Upstream.BaseURL = srv.URL
+	readyMu.Lock()
+	readyChecked = time.Time{}
+	readyMu.Unlock()
+
+	// 发起探测的调用方同样在自己的 ctx 结束时返回，不等待上游
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := checkReady(ctx); err != context.DeadlineExceeded {
+		t.Errorf("err = %v，期望 context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("耗时 %v，发起探测的调用方应在 ctx 超时后立即返回", elapsed)
+	}
+
+	// 后台探测继续完成并缓存成功结果
+	if _, err := checkReady(context.Background()); err != nil {
+		t.Errorf("探测结果 = %v，期望成功", err)
+	}
+}
+
+// --- 链路追踪 ---
+
+func TestParseTraceparent(t *testing.T) {
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"采样", "00-" + traceID + "-" + parentID + "-01", true, true},
+		{"未采样", "00-" + traceID + "-" + parentID + "-00", true, false},
+		{"其他标志位", "00-" + traceID + "-" + parentID + "-03", true, true},
+		{"首尾空白", "  00-" + traceID + "-" + parentID + "-01 ", true, true},
+		{"空", "", false, false},
+		{"段数不足", "00-" + traceID + "-01", false, false},
+		{"段数过多", "00-" + traceID + "-" + parentID + "-01-00", false, false},
+		{"版本 ff", "ff-" + traceID + "-" + parentID + "-01", false, false},
+		{"版本长度", "0-" + traceID + "-" + parentID + "-01", false, false},
+		{"trace-id 长度", "00-" + traceID[:31] + "-" + parentID + "-01", false, false},
+		{"parent-id 长度", "00-" + traceID + "-" + parentID + "0-01", false, false},
+		{"trace-id 非十六进制", "00-" + strings.Repeat("g", 32) + "-" + parentID + "-01", false, false},
+		{"parent-id 非十六进制", "00-" + traceID + "-00f067aa0ba902bz-01", false, false},
+		{"标志位非十六进制", "00-" + traceID + "-" + parentID + "-0x", false, false},
+		{"trace-id 全零", "00-" + strings.Repeat("0", 32) + "-" + parentID + "-01", false, false},
+		{"parent-id 全零", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTrace, gotParent, sampled, ok := parseTraceparent(tt.value)
+			if ok != tt.ok || sampled != tt.sampled {
+				t.Fatalf("ok = %v, sampled = %v，期望 %v, %v", ok, sampled, tt.ok, tt.sampled)
+			}
+			if ok && (gotTrace != traceID || gotParent != parentID) {
+				t.Errorf("trace-id = %q, parent-id = %q", gotTrace, gotParent)
+			}
+			if !ok && (gotTrace != "" || gotParent != "") {
+				t.Errorf("不合法时不应返回 ID: %q, %q", gotTrace, gotParent)
+			}
+		})
+	}
+}
+
+// blockingExporter 导出时阻塞到 ctx 结束或 release 关闭，started 收到每一批的 span 数
+type blockingExporter struct {
+	started chan int
+	release chan struct{}
+	errs    chan error
+}
+
+func (e *blockingExporter) export(ctx context.Context, spans []*traceSpan) error {
+	e.started <- len(spans)
+	select {
+	case <-ctx.Done():
+		e.errs <- ctx.Err()
+		return ctx.Err()
+	case <-e.release:
+		e.errs <- nil
+		return nil
+	}
+}
+
+func newBlockingExporter() *blockingExporter {
+	return &blockingExporter{started: make(chan int, 16), release: make(chan struct{}), errs: make(chan error, 16)}
+}
+
+func TestBatchSpanProcessorExportsAsync(t *testing.T) {
+	exporter := newBlockingExporter()
+	p := newBatchSpanProcessor(exporter, 8, 2, 10*time.Millisecond, 50*time.Millisecond)
+	before := counterValue(traceSpans, "failed")
+
+	// collector 无响应时请求不等待导出
+	start := time.Now()
+	p.enqueue([]*traceSpan{{Name: "a"}, {Name: "b"}})
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("enqueue 耗时 %v，期望立即返回", elapsed)
+	}
+
+	// 导出受超时限制
+	select {
+	case err := <-exporter.errs:
+		if err != context.DeadlineExceeded {
+			t.Errorf("导出结果 = %v，期望超时", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("导出没有在超时后结束")
+	}
+	if n := <-exporter.started; n != 2 {
+		t.Errorf("批大小 = %d，期望 2", n)
+	}
+	deadline := time.Now().Add(time.Second)
+	for counterValue(traceSpans, "failed")-before != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := counterValue(traceSpans, "failed") - before; got != 2 {
+		t.Errorf("failed 计数增加 %v，期望 2", got)
+	}
+}
+
+func TestBatchSpanProcessorBatching(t *testing.T) {
+	exporter := newBlockingExporter()
+	close(exporter.release)
+	p := newBatchSpanProcessor(exporter, 16, 3, 20*time.Millisecond, time.Second)
+
+	// 攒满 3 个立即导出，剩余 1 个等到定时导出
+	p.enqueue([]*traceSpan{{}, {}, {}, {}})
+	var sizes []int
+	for len(sizes) < 2 {
+		select {
+		case n := <-exporter.started:
+			sizes = append(sizes, n)
+		case <-time.After(time.Second):
+			t.Fatalf("批次 = %v，期望 [3 1]", sizes)
+		}
+	}
+	if fmt.Sprint(sizes) != "[3 1]" {
+		t.Errorf("批次 = %v，期望 [3 1]", sizes)
+	}
+}
+
+func TestBatchSpanProcessorDropsWhenFull(t *testing.T) {
+	exporter := newBlockingExporter()
+	defer close(exporter.release)
+	p := newBatchSpanProcessor(exporter, 2, 1, time.Hour, time.Hour)
+	before := counterValue(traceSpans, "dropped")
+
+	// 第一个 span 被取出并阻塞在导出中，之后队列只能再容纳 2 个
+	p.enqueue([]*traceSpan{{}})
+	<-exporter.started
+	p.enqueue([]*traceSpan{{}, {}, {}})
+	if got := counterValue(traceSpans, "dropped") - before; got != 1 {
+		t.Errorf("dropped 计数增加 %v，期望 1", got)
+	}
+}
+
+func TestHandlerExportsSpansWithIncomingTrace(t *testing.T) {
+	exporter := newBlockingExporter()
+	close(exporter.release)
+	var mu sync.Mutex
+	var spans []*traceSpan
+	recording := exporterFunc(func(ctx context.Context, batch []*traceSpan) error {
+		mu.Lock()
+		spans = append(spans, batch...)
+		mu.Unlock()
+		return exporter.export(ctx, batch)
+	})
+	saved := traceProcessor
+	defer func() { traceProcessor = saved }()
+	traceProcessor = newBatchSpanProcessor(recording, 16, 16, 10*time.Millisecond, time.Second)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	serve("GET", "/healthz", "", "traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	select {
+	case <-exporter.started:
+	case <-time.After(time.Second):
+		t.Fatal("没有导出 span")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(spans) != 1 || spans[0].TraceID != traceID || spans[0].ParentID != "00f067aa0ba902b7" || spans[0].Name != "GET healthz" {
+		t.Errorf("导出的 span = %+v", spans)
+	}
+}
+
+// exporterFunc 将函数适配为 spanExporter
+type exporterFunc func(ctx context.Context, spans []*traceSpan) error
+
+func (f exporterFunc) export(ctx context.Context, spans []*traceSpan) error { return f(ctx, spans) }
+
+// --- 监控指标 ---
+
+func counterValue(c *metricCounter, labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func TestConversionFailuresCountsUnparsableYrc(t *testing.T) {
+	before := counterValue(conversionFailures, "yrc")
+
+	data := &LyricData{}
+	data.Data.Lrc = "[00:01.00]hello\n"
+	data.Data.Yrc = "[abc]xyz\n"
+	parsed := parseLyricData(context.Background(), data)
+	if !parsed.Estimated || len(parsed.Lines) != 1 {
+		t.Errorf("应按 LRC 估算逐字时间，得到 %d 行，estimated = %v", len(parsed.Lines), parsed.Estimated)
+	}
+	if got := counterValue(conversionFailures, "yrc") - before; got != 1 {
+		t.Errorf("yrc 失败计数增加 %v，期望 1", got)
+	}
+
+	data.Data.Yrc = ""
+	parseLyricData(context.Background(), data)
+	if got := counterValue(conversionFailures, "yrc") - before; got != 1 {
+		t.Errorf("没有 YRC 时不应计为失败，计数增加 %v", got)
+	}
+}
+
+// --- 跨域 ---
+
+func TestValidOriginPattern(t *testing.T) {
+	tests := map[string]bool{
+		"*":                        true,
+		"https://app.example.com":  true,
+		"http://localhost:3000":    true,
+		"https://*.example.com":    true,
+		"app.example.com":          false,
+		"https://":                 false,
+		"://example.com":           false,
+		"https://example.com/path": false,
+		"https://*":                false,
+		"https://a.*.example.com":  false,
+		"https://*.*.example.com":  false,
+		"https://example.com?x=1":  false,
+	}
+	for pattern, want := range tests {
+		if got := validOriginPattern(pattern); got != want {
+			t.Errorf("validOriginPattern(%q) = %v，期望 %v", pattern, got, want)
+		}
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.CORS.AllowedOrigins = []string{"https://app.example.com", "https://*.example.net", "http://localhost:3000/"}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"http://app.example.com", false},
+		{"https://other.example.com", false},
+		{"https://a.example.net", true},
+		{"https://a.b.example.net", true},
+		{"https://example.net", false},
+		{"https://.example.net", false},
+		{"https://evilexample.net", false},
+		{"http://a.example.net", false},
+		{"https://a.example.net:8443", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+	}
+	for _, tt := range tests {
+		if got := originAllowed(tt.origin); got != tt.want {
+			t.Errorf("originAllowed(%q) = %v，期望 %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestApplyCORS(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		method      string
+		origin      string
+		handled     bool
+		allowOrigin string
+		vary        bool
+	}{
+		{"通配", []string{"*"}, false, "GET", "https://a.com", false, "*", false},
+		{"通配预检", []string{"*"}, false, "OPTIONS", "https://a.com", true, "*", false},
+		{"列表中的 origin 回显", []string{"https://a.com"}, true, "GET", "https://a.com", false, "https://a.com", true},
+		{"不在列表中", []string{"https://a.com"}, false, "GET", "https://b.com", false, "", true},
+		{"不在列表中的预检", []string{"https://a.com"}, false, "OPTIONS", "https://b.com", true, "", true},
+		{"没有 Origin", []string{"https://a.com"}, false, "GET", "", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.CORS.AllowedOrigins = tt.origins
+			config.CORS.AllowCredentials = tt.credentials
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			if handled := applyCORS(w, req); handled != tt.handled {
+				t.Errorf("applyCORS = %v，期望 %v", handled, tt.handled)
+			}
+			h := w.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q，期望 %q", got, tt.allowOrigin)
+			}
+			if got := strings.Contains(strings.Join(h.Values("Vary"), ","), "Origin"); got != tt.vary {
+				t.Errorf("Vary: Origin = %v，期望 %v", got, tt.vary)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials") == "true"; got != (tt.credentials && tt.allowOrigin != "") {
+				t.Errorf("Access-Control-Allow-Credentials = %q", h.Get("Access-Control-Allow-Credentials"))
+			}
+			if got := h.Get("Access-Control-Allow-Methods") != ""; got != (tt.method == "OPTIONS" && tt.allowOrigin != "") {
+				t.Errorf("Access-Control-Allow-Methods = %q", h.Get("Access-Control-Allow-Methods"))
+			}
+		})
+	}
+}
+
+// --- 间奏检测 ---
+
+func TestDetectInterludes(t *testing.T) {
+	tests := []struct {
+		name      string
+		lines     []*LineInfo
+		threshold int
+		want      string // 每个间奏 "开始-结束"，以空格分隔
+	}{
+		{"没有歌词", nil, 5000, ""},
+		{"阈值为 0 时不检测", songLines("a@0", "b@60000"), 0, ""},
+		{"空白等于阈值不算间奏", songLines("a@0", "b@6000"), 5000, ""},
+		{"空白超过阈值 1ms", songLines("a@0", "b@6001"), 5000, "1000-6001"},
+		{"前奏等于阈值不算间奏", songLines("a@5000"), 5000, ""},
+		{"前奏超过阈值", songLines("a@5001", "b@6001"), 5000, "0-5001"},
+		{"最后一行之后没有结束时间，不算间奏", songLines("a@0", "b@1000"), 5000, ""},
+		{"前奏和多处间奏", songLines("a@8000", "b@9000", "c@20000", "d@30000"), 5000, "0-8000 10000-20000 21000-30000"},
+		{
+			name: "按最后一个词的结束时间计算空白",
+			lines: []*LineInfo{
+				{StartTime: 0, EndTime: 7000, Words: lintWords("a@0+1000")},
+				{StartTime: 7000, EndTime: 8000, Words: lintWords("b@7000+1000")},
+			},
+			threshold: 5000,
+			want:      "1000-7000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, interlude := range detectInterludes(tt.lines, tt.threshold) {
+				got = append(got, fmt.Sprintf("%d-%d", interlude.StartTime, interlude.EndTime))
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("得到 %q，期望 %q", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInterludeThreshold(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Lyric.InterludeGapMs = 5000
+
+	for value, want := range map[string]int{
+		"": 0, "0": 0, "false": 0, "1": 5000, "true": 5000, "8000": 8000, "-1": 5000, "abc": 5000,
+	} {
+		if got := parseInterludeThreshold(value); got != want {
+			t.Errorf("parseInterludeThreshold(%q) = %d，期望 %d", value, got, want)
+		}
+	}
+}
+
+func TestInsertLrcInterludes(t *testing.T) {
+	tests := []struct {
+		name       string
+		lrc        string
+		interludes []InterludeInfo
+		want       string
+	}{
+		{
+			name: "没有间奏时原样返回",
+			lrc:  "[00:00.00]a\n[00:07.00]b\n",
+			want: "[00:00.00]a\n[00:07.00]b\n",
+		},
+		{
+			name:       "占位行插在间奏结束处的歌词之前",
+			lrc:        "[ti:歌名]\n[00:00.00]a\n[00:07.00]b\n",
+			interludes: []InterludeInfo{{1000, 7000}},
+			want:       "[ti:歌名]\n[00:00.00]a\n[00:01.00]…\n[00:07.00]b\n",
+		},
+		{
+			name:       "前奏占位行在第一行之前",
+			lrc:        "[00:06.00]a\n",
+			interludes: []InterludeInfo{{0, 6000}},
+			want:       "[00:00.00]…\n[00:06.00]a\n",
+		},
+		{
+			name:       "晚于所有歌词的间奏追加在末尾",
+			lrc:        "[00:00.00]a\n",
+			interludes: []InterludeInfo{{1000, 7000}},
+			want:       "[00:00.00]a\n[00:01.00]…\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := insertLrcInterludes(tt.lrc, tt.interludes); got != tt.want {
+				t.Errorf("得到 %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterludePlaceholders(t *testing.T) {
+	parsed := &ParsedLyric{
+		Lines:      songLines("a@0", "b@7000"),
+		Interludes: []InterludeInfo{{1000, 7000}},
+	}
+
+	eslrc, err := convertYrcToEnhancedLrc(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[00:00.00]<00:00.00>a<00:01.00>\n" +
+		"[00:01.00]<00:01.00>●<00:03.00>●<00:05.00>●<00:07.00>\n" +
+		"[00:07.00]<00:07.00>b<00:08.00>\n"
+	if eslrc != want {
+		t.Errorf("ESLRC 得到\n%s\n期望\n%s", eslrc, want)
+	}
+
+	ttml, err := convertYrcToTtml(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal([]byte(ttml), new(struct{})); err != nil {
+		t.Fatalf("TTML 不是合法的 XML: %v\n%s", err, ttml)
+	}
+	placeholder := `<p begin="00:01.000" end="00:07.000" ttm:role="x-interlude"></p>`
+	at := strings.Index(ttml, placeholder)
+	if at < 0 {
+		t.Fatalf("TTML 中没有间奏占位: %s", ttml)
+	}
+	if !(strings.Index(ttml, ">a<") < at && at < strings.Index(ttml, ">b<")) {
+		t.Errorf("间奏占位不在两行之间: %s", ttml)
+	}
+}
+
+// --- 歌曲段落识别 ---
+
+// songLines 按 "文本@开始毫秒" 生成每行一个词、时长 1 秒的歌词
+func songLines(specs ...string) []*LineInfo {
+	var lines []*LineInfo
+	for _, spec := range specs {
+		text, at, _ := strings.Cut(spec, "@")
+		var start int
+		fmt.Sscan(at, &start)
+		lines = append(lines, &LineInfo{
+			Words:     []WordInfo{{Text: text, StartTime: start, Duration: 1000}},
+			StartTime: start,
+			EndTime:   start + 1000,
+		})
+	}
+	return lines
+}
+
+func TestLabelSongStructure(t *testing.T) {
+	chorus := func(start int) []string {
+		return []string{fmt.Sprintf("Oh baby baby@%d", start), fmt.Sprintf("come back to me@%d", start+1000)}
+	}
+	join := func(parts ...[]string) []string {
+		var all []string
+		for _, p := range parts {
+			all = append(all, p...)
+		}
+		return all
+	}
+
+	tests := []struct {
+		name  string
+		lines []string
+		want  string // 段落序列
+	}{
+		{
+			name: "完整结构",
+			lines: join(
+				[]string{"walking down the street@0", "thinking about you@1000"},
+				chorus(10000),
+				[]string{"another day goes by@20000", "still waiting here@21000"},
+				chorus(30000),
+				[]string{"something different now@40000", "a brand new line@41000"},
+				chorus(50000),
+				[]string{"goodbye my love@60000"},
+			),
+			want: "Verse@0-2000,Chorus@10000-12000,Verse@20000-22000,Chorus@30000-32000,Bridge@40000-42000,Chorus@50000-52000,Outro@60000-61000",
+		},
+		{
+			name: "标点和大小写不同仍视为重复",
+			lines: join(
+				[]string{"Oh, baby baby!@0", "Come back to me.@1000"},
+				[]string{"verse line one@10000", "verse line two@11000"},
+				chorus(20000),
+			),
+			want: "Chorus@0-2000,Verse@10000-12000,Chorus@20000-22000",
+		},
+		{
+			name: "相邻的副歌合并",
+			lines: join(
+				[]string{"intro words here@0"},
+				chorus(10000),
+				chorus(20000),
+			),
+			want: "Verse@0-1000,Chorus@10000-22000",
+		},
+		{
+			name:  "没有重复",
+			lines: []string{"one line@0", "two line@1000", "three line@10000"},
+			want:  "",
+		},
+		{
+			name:  "只有单行重复不算副歌",
+			lines: []string{"hello there@0", "something else@1000", "hello there@10000", "another thing@11000"},
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := songLines(tt.lines...)
+			sections := labelSongStructure(lines)
+			var got []string
+			for _, sec := range sections {
+				got = append(got, fmt.Sprintf("%s@%d-%d", sec.Part, sec.Start, sec.End))
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("段落 = %s，期望 %s", strings.Join(got, ","), tt.want)
+			}
+			// 每行的 SongPart 与所在段落一致，未识别时为空
+			for _, line := range lines {
+				want := ""
+				for _, sec := range sections {
+					if line.StartTime >= sec.Start && line.StartTime < sec.End {
+						want = sec.Part
+					}
+				}
+				if line.SongPart != want {
+					t.Errorf("行 %q 的 SongPart = %q，期望 %q", lineText(line), line.SongPart, want)
+				}
+			}
+		})
+	}
+}
+
+// --- 歌词质量检查 ---
+
+// lintWords 按 "文本@开始+时长" 构造词，时长写作 0 时表示上游为 0 (解析时修正为 1ms)
+func lintWords(specs ...string) []WordInfo {
+	var words []WordInfo
+	for _, spec := range specs {
+		var w WordInfo
+		text, timing, _ := strings.Cut(spec, "@")
+		fmt.Sscanf(timing, "%d+%d", &w.StartTime, &w.Duration)
+		w.Text = text
+		if w.Duration == 0 {
+			w.Duration, w.ZeroDur = 1, true
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+func TestLintLyric(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Lyric.LintMaxGapMs = 5000
+
+	tests := []struct {
+		name  string
+		lines []*LineInfo
+		want  string // 每条发现的 "规则:行.词"，以空格分隔
+		fixed string // lint=fix 后每行的 "开始-结束:词开始+时长,..."，以 | 分隔；为空时不检查
+	}{
+		{
+			name: "没有问题",
+			lines: []*LineInfo{
+				{StartTime: 0, EndTime: 1000, Words: lintWords("a@0+500", "b@500+500")},
+				{StartTime: 1000, EndTime: 2000, Words: lintWords("c@1000+1000")},
+			},
+			want: "",
+		},
+		{
+			name:  "零时长词修正到下一个词开始",
+			lines: []*LineInfo{{StartTime: 0, EndTime: 1000, Words: lintWords("a@0+0", "b@400+600")}},
+			want:  "zero-duration-word:1.1",
+			fixed: "0-1000:0+400,400+600",
+		},
+		{
+			name:  "行末零时长词修正到行结束",
+			lines: []*LineInfo{{StartTime: 0, EndTime: 1000, Words: lintWords("a@0+500", "b@500+0")}},
+			want:  "zero-duration-word:1.2",
+			fixed: "0-1000:0+500,500+500",
+		},
+		{
+			name:  "词重叠时截短前一个词",
+			lines: []*LineInfo{{StartTime: 0, EndTime: 1000, Words: lintWords("a@0+600", "b@400+600")}},
+			want:  "word-overlap:1.2",
+			fixed: "0-1000:0+400,400+600",
+		},
+		{
+			name:  "词超出行范围时扩展行",
+			lines: []*LineInfo{{StartTime: 100, EndTime: 1000, Words: lintWords("a@0+500", "b@500+800")}},
+			want:  "word-out-of-line:1.1 word-out-of-line:1.2",
+			fixed: "0-1300:0+500,500+800",
+		},
+		{
+			name: "行时间倒序时排序",
+			lines: []*LineInfo{
+				{StartTime: 5000, EndTime: 6000, Words: lintWords("b@5000+1000")},
+				{StartTime: 1000, EndTime: 2000, Words: lintWords("a@1000+1000")},
+			},
+			want:  "non-monotonic-line:2.0",
+			fixed: "1000-2000:1000+1000|5000-6000:5000+1000",
+		},
+		{
+			name: "行重叠",
+			lines: []*LineInfo{
+				{StartTime: 0, EndTime: 1500, Words: lintWords("a@0+1500")},
+				{StartTime: 1000, EndTime: 2000, Words: lintWords("b@1000+1000")},
+			},
+			want: "line-overlap:2.0",
+		},
+		{
+			name: "间隔超过阈值",
+			lines: []*LineInfo{
+				{StartTime: 0, EndTime: 1000, Words: lintWords("a@0+1000")},
+				{StartTime: 6001, EndTime: 7000, Words: lintWords("b@6001+999")},
+			},
+			want: "large-gap:2.0",
+		},
+		{
+			name: "间隔等于阈值",
+			lines: []*LineInfo{
+				{StartTime: 0, EndTime: 1000, Words: lintWords("a@0+1000")},
+				{StartTime: 6000, EndTime: 7000, Words: lintWords("b@6000+1000")},
+			},
+			want: "",
+		},
+	}
+	clone := func(lines []*LineInfo) []*LineInfo {
+		var out []*LineInfo
+		for _, line := range lines {
+			c := *line
+			c.Words = append([]WordInfo(nil), line.Words...)
+			out = append(out, &c)
+		}
+		return out
+	}
+	describe := func(report LintReport) string {
+		var got []string
+		for _, f := range report.Findings {
+			got = append(got, fmt.Sprintf("%s:%d.%d", f.Rule, f.Line, f.Word))
+		}
+		return strings.Join(got, " ")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := &ParsedLyric{Lines: clone(tt.lines)}
+			report := lintLyric(parsed, false)
+			if got := describe(report); got != tt.want {
+				t.Errorf("发现 = %q，期望 %q", got, tt.want)
+			}
+			if report.Fixed != 0 || !reflect.DeepEqual(parsed.Lines, tt.lines) {
+				t.Error("lint=1 不应修改歌词")
+			}
+			if report.Errors+report.Warnings+report.Infos != len(report.Findings) {
+				t.Errorf("计数 = %+v 与发现数不一致", report)
+			}
+
+			parsed = &ParsedLyric{Lines: clone(tt.lines)}
+			report = lintLyric(parsed, true)
+			if got := describe(report); got != tt.want {
+				t.Errorf("lint=fix 发现 = %q，期望与 lint=1 相同的 %q", got, tt.want)
+			}
+			if tt.fixed == "" {
+				return
+			}
+			var lines []string
+			for _, line := range parsed.Lines {
+				var words []string
+				for _, w := range line.Words {
+					words = append(words, fmt.Sprintf("%d+%d", w.StartTime, w.Duration))
+				}
+				lines = append(lines, fmt.Sprintf("%d-%d:%s", line.StartTime, line.EndTime, strings.Join(words, ",")))
+			}
+			if got := strings.Join(lines, "|"); got != tt.fixed {
+				t.Errorf("修正后 = %s，期望 %s", got, tt.fixed)
+			}
+			if report.Fixed == 0 {
+				t.Error("fixed = 0，期望记录修正数")
+			}
+		})
+	}
+}
+
+func TestLintLyricSkipsEstimatedTiming(t *testing.T) {
+	parsed := &ParsedLyric{
+		Estimated: true,
+		Lines: []*LineInfo{
+			{StartTime: 5000, EndTime: 6000, Words: lintWords("b@5000+1000")},
+			{StartTime: 1000, EndTime: 2000, Words: lintWords("a@1000+0")},
+		},
+	}
+	report := lintLyric(parsed, true)
+	if len(report.Findings) != 1 || report.Findings[0].Rule != "estimated-timing" || report.Infos != 1 || report.Fixed != 0 {
+		t.Errorf("报告 = %+v，期望只有一条 estimated-timing", report)
+	}
+	if parsed.Lines[0].StartTime != 5000 {
+		t.Error("估算的时间轴不应被修正")
+	}
+}
+
+func TestLintIndicesIgnoreKanaRuby(t *testing.T) {
+	data := &LyricData{Code: 200}
+	data.Data.Lrc = "[kana:2いっしょ]\n[00:00.00]一緒に\n"
+	// 「一」「緒」共用一个读音，「に」与「緒」重叠
+	data.Data.Yrc = "[0,1000]一(0,300)緒(300,300)に(500,500)\n"
+
+	resp := buildLyricResponse(context.Background(), "", "", "", data, responseOptions{Lint: "1", Lines: true})
+	if resp.Data.Lint == nil || len(resp.Data.Lint.Findings) != 1 {
+		t.Fatalf("lint = %+v，期望一条发现", resp.Data.Lint)
+	}
+	if f := resp.Data.Lint.Findings[0]; f.Rule != "word-overlap" || f.Line != 1 || f.Word != 3 {
+		t.Errorf("发现 = %+v，期望第 1 行第 3 个词 (上游顺序) 的 word-overlap", f)
+	}
+	if len(resp.Data.Lines) != 1 || len(resp.Data.Lines[0].Words) != 3 || len(resp.Data.Lines[0].Words[0].Ruby) != 1 {
+		t.Errorf("lines = %+v，期望 3 个词且注音在检查后添加", resp.Data.Lines)
+	}
+}
@@ -0,0 +1,429 @@
+// Package client 是 Lyric API 的 Go 客户端。
+//
+// 类型与服务端 /openapi.json 中的 schema 一一对应，client_test.go 会检查它们与服务端类型是否一致。
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client Lyric API 客户端
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Lang       string // 响应消息的语言 (zh-CN 或 en)，为空时使用服务端默认的中文
+	APIKey     string // 服务端启用认证时使用的 API Key，通过 X-API-Key 请求头发送
+}
+
+// NewClient 创建客户端，baseURL 如 "https://lyric.example.com"
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// --- 响应类型 ---
+
+// APIError 服务端返回的错误，应按 ErrorCode 判断错误类型。
+// Message 按请求语言翻译，Details 是不翻译的中文诊断文本，格式不稳定，不应用于判断
+type APIError struct {
+	Status    int             `json:"-"`
+	Code      int             `json:"code"`
+	ErrorCode string          `json:"error_code"`
+	Message   string          `json:"message"`
+	Details   string          `json:"details,omitempty"`
+	Upstream  json.RawMessage `json:"upstream,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Details != "" {
+		return fmt.Sprintf("lyric api: %d %s %s: %s", e.Status, e.ErrorCode, e.Message, e.Details)
+	}
+	return fmt.Sprintf("lyric api: %d %s %s", e.Status, e.ErrorCode, e.Message)
+}
+
+// 服务端错误码，见 Readme 的错误码表
+const (
+	ErrMissingParameter     = "MISSING_PARAMETER"
+	ErrInvalidParameter     = "INVALID_PARAMETER"
+	ErrInvalidBody          = "INVALID_BODY"
+	ErrBatchTooLarge        = "BATCH_TOO_LARGE"
+	ErrMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	ErrUpstreamTimeout      = "UPSTREAM_TIMEOUT"
+	ErrUpstreamUnavailable  = "UPSTREAM_UNAVAILABLE"
+	ErrUpstreamError        = "UPSTREAM_ERROR"
+	ErrSongNotFound         = "SONG_NOT_FOUND"
+	ErrLowMatchConfidence   = "LOW_MATCH_CONFIDENCE"
+	ErrIndexOutOfRange      = "INDEX_OUT_OF_RANGE"
+	ErrLyricNotFound        = "LYRIC_NOT_FOUND"
+	ErrNoWordTiming         = "NO_WORD_TIMING"
+	ErrTooManyRequests      = "TOO_MANY_REQUESTS"
+	ErrStreamingUnsupported = "STREAMING_UNSUPPORTED"
+	ErrUnauthorized         = "UNAUTHORIZED"
+	ErrForbidden            = "FORBIDDEN"
+	ErrQuotaExceeded        = "QUOTA_EXCEEDED"
+	ErrUpstreamCircuitOpen  = "UPSTREAM_CIRCUIT_OPEN"
+	ErrConfigInvalid        = "CONFIG_INVALID"
+)
+
+// Song 搜索结果中的一首歌曲
+type Song struct {
+	N             int    `json:"n"`
+	Song          string `json:"song"`
+	Singer        string `json:"singer"`
+	ID            int    `json:"id"`
+	MID           string `json:"mid"`
+	Album         string `json:"album"`
+	Duration      int    `json:"duration,omitempty"` // 秒
+	Cover         string `json:"cover,omitempty"`
+	ReleaseDate   string `json:"release_date,omitempty"`
+	VIP           *bool  `json:"vip,omitempty"`
+	HasWordLyrics *bool  `json:"has_word_lyrics,omitempty"`
+}
+
+// SearchResult 搜索响应
+type SearchResult struct {
+	Page int    `json:"page"`
+	Num  int    `json:"num"`
+	Data []Song `json:"data"`
+}
+
+// Lyric 歌曲和歌词数据
+type Lyric struct {
+	Song      string         `json:"song"`
+	Singer    string         `json:"singer"`
+	Album     string         `json:"album"`
+	LRC       string         `json:"lrc"`
+	ESLRC     string         `json:"eslrc"`
+	TTML      string         `json:"ttml"`
+	Estimated bool           `json:"estimated"`
+	HTML      string         `json:"html,omitempty"`
+	Lines     []LyricLine    `json:"lines,omitempty"`
+	Lint      *LintReport    `json:"lint,omitempty"`
+	Sections  []LyricSection `json:"sections,omitempty"`
+	Match     *MatchResult   `json:"match,omitempty"`
+}
+
+// LyricLine 结构化歌词中的一行
+type LyricLine struct {
+	Start     int         `json:"start"`
+	End       int         `json:"end"`
+	Text      string      `json:"text"`
+	Words     []LyricWord `json:"words"`
+	Interlude bool        `json:"interlude,omitempty"`
+	Part      string      `json:"part,omitempty"`
+}
+
+// LyricWord 结构化歌词中的一个字/词
+type LyricWord struct {
+	Text  string      `json:"text"`
+	Start int         `json:"start"`
+	End   int         `json:"end"`
+	Ruby  []LyricRuby `json:"ruby,omitempty"`
+}
+
+// LyricRuby 假名注音，Offset 为在词文本中的字符下标；Words 大于 0 时注音延续到后续的词，Base 为完整文本
+type LyricRuby struct {
+	Base    string `json:"base"`
+	Reading string `json:"reading"`
+	Offset  int    `json:"offset"`
+	Words   int    `json:"words,omitempty"`
+}
+
+// LyricSection 歌曲段落
+type LyricSection struct {
+	Part  string `json:"part"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// MatchResult 元数据匹配结果
+type MatchResult struct {
+	Score    float64 `json:"score"`
+	N        int     `json:"n"`
+	ID       int     `json:"id"`
+	MID      string  `json:"mid"`
+	Duration int     `json:"duration,omitempty"`
+}
+
+// LintFinding 歌词质量检查发现的问题
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Line     int    `json:"line"`
+	Word     int    `json:"word,omitempty"`
+	Time     int    `json:"time"`
+	Message  string `json:"message"`
+	Fixed    bool   `json:"fixed,omitempty"`
+}
+
+// LintReport 歌词质量检查报告
+type LintReport struct {
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	Infos    int           `json:"infos"`
+	Fixed    int           `json:"fixed"`
+	Findings []LintFinding `json:"findings"`
+}
+
+// TimelineLine 时间轴中的一行
+type TimelineLine struct {
+	Index       int    `json:"index"`
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+	Text        string `json:"text"`
+	Translation string `json:"translation,omitempty"`
+}
+
+// TimelineWord 时间轴中的一个字/词
+type TimelineWord struct {
+	Line  int    `json:"line"`
+	Index int    `json:"index"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// Position 播放位置查询结果
+type Position struct {
+	Position    int           `json:"position"`
+	Line        *TimelineLine `json:"line"`
+	Word        *TimelineWord `json:"word"`
+	NextLine    *TimelineLine `json:"next_line"`
+	NextChange  *int          `json:"next_change"`
+	UntilChange *int          `json:"until_change"`
+	Duration    int           `json:"duration"`
+}
+
+// BatchItem 批量请求中的一项：提供 ID/MID，或提供 Title 等元数据
+type BatchItem struct {
+	ID       int     `json:"id,omitempty"`
+	MID      string  `json:"mid,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Duration int     `json:"duration,omitempty"`
+	MinScore float64 `json:"min_score,omitempty"`
+}
+
+// BatchItemResult 批量请求中一项的结果，失败时 Error 有值
+type BatchItemResult struct {
+	Index int       `json:"index"`
+	Code  int       `json:"code"`
+	Data  *Lyric    `json:"data,omitempty"`
+	Error *APIError `json:"error,omitempty"`
+}
+
+// --- 请求选项 ---
+
+// LyricOptions 歌词输出选项
+type LyricOptions struct {
+	Lines     bool   // 返回结构化逐字歌词
+	HTML      bool   // 返回带注音的网页片段
+	Lint      string // "1" 或 "fix"
+	Interlude string // "1" 或阈值毫秒数
+}
+
+func (o *LyricOptions) encode(q url.Values) {
+	if o == nil {
+		return
+	}
+	if o.Lines {
+		q.Set("lines", "1")
+	}
+	if o.HTML {
+		q.Set("html", "1")
+	}
+	if o.Lint != "" {
+		q.Set("lint", o.Lint)
+	}
+	if o.Interlude != "" {
+		q.Set("interlude", o.Interlude)
+	}
+}
+
+// SearchOptions 搜索选项
+type SearchOptions struct {
+	Page   int
+	Num    int
+	Singer string
+	Album  string
+	Probe  bool // 探测是否有逐字歌词
+}
+
+// MatchQuery 按元数据匹配歌词
+type MatchQuery struct {
+	Title    string
+	Artist   string
+	Album    string
+	Duration int     // 秒
+	MinScore float64 // 为 0 时使用服务端默认值
+}
+
+// --- 接口方法 ---
+
+// Search 按关键字搜索歌曲
+func (c *Client) Search(ctx context.Context, word string, opts *SearchOptions) (*SearchResult, error) {
+	q := url.Values{"word": {word}}
+	if opts != nil {
+		if opts.Page > 0 {
+			q.Set("page", strconv.Itoa(opts.Page))
+		}
+		if opts.Num > 0 {
+			q.Set("num", strconv.Itoa(opts.Num))
+		}
+		if opts.Singer != "" {
+			q.Set("singer", opts.Singer)
+		}
+		if opts.Album != "" {
+			q.Set("album", opts.Album)
+		}
+		if opts.Probe {
+			q.Set("probe", "1")
+		}
+	}
+	var result SearchResult
+	if err := c.do(ctx, http.MethodGet, "/v2/music/tencent/lyric", q, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// LyricByID 按歌曲 ID 获取歌词
+func (c *Client) LyricByID(ctx context.Context, id int, opts *LyricOptions) (*Lyric, error) {
+	return c.lyric(ctx, url.Values{"id": {strconv.Itoa(id)}}, opts)
+}
+
+// LyricByMID 按歌曲 MID 获取歌词
+func (c *Client) LyricByMID(ctx context.Context, mid string, opts *LyricOptions) (*Lyric, error) {
+	return c.lyric(ctx, url.Values{"mid": {mid}}, opts)
+}
+
+// Match 按标题、歌手、专辑和时长匹配最合适的歌曲并返回歌词
+func (c *Client) Match(ctx context.Context, query MatchQuery, opts *LyricOptions) (*Lyric, error) {
+	q := url.Values{"title": {query.Title}}
+	if query.Artist != "" {
+		q.Set("artist", query.Artist)
+	}
+	if query.Album != "" {
+		q.Set("album", query.Album)
+	}
+	if query.Duration > 0 {
+		q.Set("duration", strconv.Itoa(query.Duration))
+	}
+	if query.MinScore > 0 {
+		q.Set("min_score", strconv.FormatFloat(query.MinScore, 'f', -1, 64))
+	}
+	return c.lyric(ctx, q, opts)
+}
+
+func (c *Client) lyric(ctx context.Context, q url.Values, opts *LyricOptions) (*Lyric, error) {
+	opts.encode(q)
+	var result struct {
+		Data Lyric `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v2/music/tencent/lyric", q, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Data, nil
+}
+
+// Position 查询歌曲 ID 在播放位置 t (毫秒) 处的当前行和当前字
+func (c *Client) Position(ctx context.Context, id int, t int) (*Position, error) {
+	return c.position(ctx, url.Values{"id": {strconv.Itoa(id)}, "t": {strconv.Itoa(t)}})
+}
+
+// PositionByMID 查询歌曲 MID 在播放位置 t (毫秒) 处的当前行和当前字
+func (c *Client) PositionByMID(ctx context.Context, mid string, t int) (*Position, error) {
+	return c.position(ctx, url.Values{"mid": {mid}, "t": {strconv.Itoa(t)}})
+}
+
+func (c *Client) position(ctx context.Context, q url.Values) (*Position, error) {
+	var result struct {
+		Data Position `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/position", q, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Data, nil
+}
+
+// Batch 批量获取歌词，结果按请求顺序排列；单项失败不影响整体，见 BatchItemResult.Error
+func (c *Client) Batch(ctx context.Context, items []BatchItem, concurrency int, opts *LyricOptions) ([]BatchItemResult, error) {
+	q := url.Values{}
+	opts.encode(q)
+	body := struct {
+		Items       []BatchItem `json:"items"`
+		Concurrency int         `json:"concurrency,omitempty"`
+	}{items, concurrency}
+	var result struct {
+		Data []BatchItemResult `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/batch", q, body, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := c.BaseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Lang != "" {
+		req.Header.Set("Accept-Language", c.Lang)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{Status: resp.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	return json.Unmarshal(data, out)
+}
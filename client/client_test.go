@@ -0,0 +1,141 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jwbb903/lyric-api/api"
+)
+
+// fill 把 v 的每个字段都设为非零值，指针和切片各分配一个元素，保证 omitempty 的字段也会序列化
+func fill(v reflect.Value) {
+	if v.Type() == reflect.TypeOf(json.RawMessage(nil)) {
+		v.SetBytes([]byte(`{"code":500}`))
+		return
+	}
+	if v.Type() == reflect.TypeOf(json.Number("")) {
+		v.SetString("7")
+		return
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i))
+			}
+		}
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.String:
+		v.SetString("text")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		v.SetInt(7)
+	case reflect.Float64:
+		v.SetFloat(0.5)
+	}
+}
+
+// roundTrip 把填满的 from 序列化后严格解码为 to 类型，再把 to 序列化，两份 JSON 必须一致：
+// to 缺少的字段会让解码失败 (未知字段)，to 多出的字段会出现在第二份 JSON 中。
+// ignore 为 to 有意不解码的顶层字段 (如响应外层的 code/message)
+func roundTrip(t *testing.T, from, to interface{}, ignore ...string) {
+	t.Helper()
+	src := reflect.New(reflect.TypeOf(from)).Elem()
+	fill(src)
+	data, err := json.Marshal(src.Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want map[string]interface{}
+	json.Unmarshal(data, &want)
+	for _, key := range ignore {
+		delete(want, key)
+	}
+	trimmed, _ := json.Marshal(want)
+
+	dst := reflect.New(reflect.TypeOf(to))
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst.Interface()); err != nil {
+		t.Fatalf("%T 无法解码 %T: %v", to, from, err)
+	}
+
+	out, _ := json.Marshal(dst.Interface())
+	var got map[string]interface{}
+	json.Unmarshal(out, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%T 与 %T 的 JSON 不一致\n得到 %s\n期望 %s", to, from, out, trimmed)
+	}
+}
+
+// TestTypesMatchServer 检查客户端类型与服务端的响应 (和请求) 类型字段一致，任一边增删字段都会失败
+func TestTypesMatchServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   interface{}
+		to     interface{}
+		ignore []string
+	}{
+		{"Song", api.SearchSongItemSimplified{}, Song{}, nil},
+		{"SearchResult", api.SearchResponse{}, SearchResult{}, []string{"code", "message"}},
+		{"Lyric", api.UnifiedLyricData{}, Lyric{}, nil},
+		{"APIError", api.ErrorResponse{}, APIError{}, nil},
+		{"Position", api.PositionData{}, Position{}, nil},
+		{"BatchItemResult", api.BatchItemResult{}, BatchItemResult{}, nil},
+		// 请求体方向相反：客户端发送，服务端解码
+		{"BatchItem", BatchItem{}, api.BatchItem{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTrip(t, tt.from, tt.to, tt.ignore...)
+		})
+	}
+}
+
+// TestErrorCodesMatchServer 检查错误码常量与服务端 OpenAPI 中的枚举一致
+func TestErrorCodesMatchServer(t *testing.T) {
+	w := httptest.NewRecorder()
+	api.Handler(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Enum []string `json:"enum"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("解析 OpenAPI 失败: %v", err)
+	}
+	server := spec.Components.Schemas["ErrorResponse"].Properties["error_code"].Enum
+	if len(server) == 0 {
+		t.Fatalf("OpenAPI 中没有 ErrorResponse.error_code 枚举: %s", w.Body.String())
+	}
+
+	client := []string{
+		ErrMissingParameter, ErrInvalidParameter, ErrInvalidBody, ErrBatchTooLarge,
+		ErrMethodNotAllowed, ErrUpstreamTimeout, ErrUpstreamUnavailable, ErrUpstreamError,
+		ErrSongNotFound, ErrLowMatchConfidence, ErrIndexOutOfRange, ErrLyricNotFound,
+		ErrNoWordTiming, ErrTooManyRequests, ErrStreamingUnsupported,
+		ErrUnauthorized, ErrForbidden, ErrQuotaExceeded, ErrUpstreamCircuitOpen,
+		ErrConfigInvalid,
+	}
+	sort.Strings(server)
+	sort.Strings(client)
+	if !reflect.DeepEqual(server, client) {
+		t.Errorf("错误码不一致\n服务端 %v\n客户端 %v", server, client)
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/jwbb903/lyric-api
+
+go 1.21
+
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.17.11
+)